@@ -7,17 +7,25 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
-	"sort"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/arthur/ical_merger/internal/agenda"
 	"github.com/arthur/ical_merger/internal/app"
+	"github.com/arthur/ical_merger/internal/availability"
 	"github.com/arthur/ical_merger/internal/config"
+	"github.com/arthur/ical_merger/internal/conflict"
+	"github.com/arthur/ical_merger/internal/export"
+	"github.com/arthur/ical_merger/internal/history"
 	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arthur/ical_merger/internal/stats"
+	"github.com/arthur/ical_merger/internal/store"
 	"github.com/arran4/golang-ical"
 )
 
@@ -44,6 +52,8 @@ func main() {
 		outputPath     = flag.String("output", "", "Path to output file (overrides config)")
 		localMode      = flag.Bool("local", false, "Run with local files instead of URLs")
 		calendarDir    = flag.String("calendar-dir", "./calendars", "Directory containing calendar files when using local mode")
+		exportCSV      = flag.String("export-csv", "", "Write the events of the last merge as CSV to this file (- for stdout) and exit")
+		exportQuery    = flag.String("export-query", "", "Query parameters of the CSV export, as for /export.csv (e.g. from=2025-03-01&to=2025-03-31&sources=Arthur)")
 	)
 	
 	// Log command-line arguments
@@ -76,6 +86,16 @@ func main() {
 		}
 	}
 
+	// Export the events as CSV instead of serving or syncing. The export
+	// reads the files of the last merge and doesn't merge itself, so it
+	// never triggers webhooks, emails or history entries.
+	if *exportCSV != "" {
+		if err := runExport(cfg, *exportCSV, *exportQuery); err != nil {
+			log.Fatalf("CSV export failed: %v", err)
+		}
+		return
+	}
+
 	merger := app.NewMerger(cfg)
 
 	// Do initial merge
@@ -87,553 +107,1370 @@ func main() {
 	if *serveMode {
 		log.Printf("Entering serve mode setup")
 		
-		// Add root handler for easy testing
-		http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-			log.Printf("Received request for: %s", r.URL.Path)
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("iCal Merger is running. Use /calendar to access the merged calendar."))
-		})
+		registerHandlers(http.DefaultServeMux, cfg, merger)
+
+		// Start HTTP server - correctly in a goroutine
+		log.Printf("Starting HTTP server on %s", *httpAddr)
+		go func() {
+			err := http.ListenAndServe(*httpAddr, nil)
+			log.Fatalf("*** HTTP server failed: %v ***", err)
+		}()
 		
-		log.Printf("Root handler registered")
+		log.Printf("HTTP server is now running")
+	}
+
+	// Set up periodic merges
+	ticker := time.NewTicker(time.Duration(cfg.SyncIntervalMinutes) * time.Minute)
+	defer ticker.Stop()
+
+	// Scheduled jobs like the agenda email and the reminders are checked every minute
+	clock := time.NewTicker(time.Minute)
+	defer clock.Stop()
+
+	log.Printf("iCal Merger started. Merging every %d minutes", cfg.SyncIntervalMinutes)
+
+	for {
+		select {
+		case <-ticker.C:
+			log.Println("Starting periodic merge")
+			if err := merger.Merge(); err != nil {
+				log.Printf("Periodic merge failed: %v", err)
+			}
+		case now := <-clock.C:
+			merger.Tick(now)
+		}
+	}
+}
+
+// registerHandlers adds the HTTP handlers of the server mode to mux
+func registerHandlers(mux *http.ServeMux, cfg *config.Config, merger *app.Merger) {
+	// Add root handler for easy testing
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Received request for: %s", r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("iCal Merger is running. Use /calendar to access the merged calendar."))
+	})
+	
+	log.Printf("Root handler registered")
+	
+	// HTTP handler for health check - keep this simple to test basic functionality
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Health check request received from %s", r.RemoteAddr)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+	
+	log.Printf("Health check handler registered")
+	
+	// HTTP handler for the report of the last merge (fetched and dropped events per calendar)
+	mux.HandleFunc("/api/report", func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Merge report request received from %s", r.RemoteAddr)
 		
-		// HTTP handler for health check - keep this simple to test basic functionality
-		http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-			log.Printf("Health check request received from %s", r.RemoteAddr)
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("OK"))
-		})
+		report := merger.LastReport()
+		if report == nil {
+			http.Error(w, "No merge has completed yet", http.StatusServiceUnavailable)
+			return
+		}
 		
-		log.Printf("Health check handler registered")
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Printf("Error encoding merge report: %v", err)
+		}
+	})
+	
+	log.Printf("Merge report handler registered")
+	
+	// HTTP handler for the overlapping events found by the last merge
+	mux.HandleFunc("/api/conflicts", func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Conflicts request received from %s", r.RemoteAddr)
 		
-		// HTTP handler to serve the merged calendar
-		http.HandleFunc("/calendar", func(w http.ResponseWriter, r *http.Request) {
-			log.Printf("Calendar request received from %s", r.RemoteAddr)
-			
-			// Only refresh cache if the nocache parameter is set
-			if r.URL.Query().Get("nocache") != "" {
-				log.Printf("Nocache parameter set, refreshing calendar data")
-				if err := merger.Merge(); err != nil {
-					log.Printf("Error merging calendars: %v", err)
-					http.Error(w, fmt.Sprintf("Error merging calendars: %v", err), http.StatusInternalServerError)
-					return
-				}
+		if cfg.Conflicts == nil {
+			http.Error(w, "Conflict detection is not enabled", http.StatusNotFound)
+			return
+		}
+		
+		conflicts := merger.Conflicts()
+		if conflicts == nil {
+			conflicts = []conflict.Conflict{}
+		}
+		
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"count":     len(conflicts),
+			"conflicts": conflicts,
+		}); err != nil {
+			log.Printf("Error encoding conflicts: %v", err)
+		}
+	})
+	
+	// HTTP handler for the calendar of conflict markers
+	mux.HandleFunc("/conflicts", func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Conflicts calendar request received from %s", r.RemoteAddr)
+		
+		if cfg.Conflicts == nil || cfg.Conflicts.Path == "" {
+			http.Error(w, "The conflicts calendar is not enabled", http.StatusNotFound)
+			return
+		}
+		
+		calData, err := os.ReadFile(cfg.Conflicts.Path)
+		if err != nil {
+			log.Printf("Error reading conflicts calendar: %v", err)
+			http.Error(w, fmt.Sprintf("Error reading conflicts calendar: %v", err), http.StatusInternalServerError)
+			return
+		}
+		
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"conflicts.ics\"")
+		w.Header().Set("X-WR-CALNAME", "Conflicts")
+		if _, err := w.Write(calData); err != nil {
+			log.Printf("Error sending conflicts calendar: %v", err)
+		}
+	})
+	
+	log.Printf("Conflict handlers registered")
+	
+	// writeCalendar serves the merged calendar in a format: "ics",
+	// "jcal" or "xcal"
+	writeCalendar := func(w http.ResponseWriter, r *http.Request, output config.Output, format string) {
+		log.Printf("Calendar request received from %s (%s)", r.RemoteAddr, format)
+		
+		// Only refresh cache if the nocache parameter is set
+		if r.URL.Query().Get("nocache") != "" {
+			log.Printf("Nocache parameter set, refreshing calendar data")
+			if err := merger.Merge(); err != nil {
+				log.Printf("Error merging calendars: %v", err)
+				http.Error(w, fmt.Sprintf("Error merging calendars: %v", err), http.StatusInternalServerError)
+				return
 			}
+		}
 
-			log.Printf("Opening calendar file from: %s", cfg.OutputPath)
-			// Open the merged calendar file
-			file, err := os.Open(cfg.OutputPath)
-			if err != nil {
-				log.Printf("Error opening calendar file: %v", err)
-				http.Error(w, fmt.Sprintf("Error opening calendar file: %v", err), http.StatusInternalServerError)
-				return
+		loc, err := time.LoadLocation(output.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		filter, err := queryFilter(r, loc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// The full feed has no default period, days_back and days_forward set one
+		if back, forward, ok := queryDays(r, 0, 0); ok && filter.From.IsZero() && filter.To.IsZero() {
+			now := time.Now().In(loc)
+			today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+			filter.From = today.AddDate(0, 0, -back)
+			filter.To = today.AddDate(0, 0, forward+1)
+		}
+		
+		// Serve the merged calendar as written, from the event store
+		feed, err := merger.Store().Lookup(output)
+		if err != nil {
+			log.Printf("Error loading calendar: %v", err)
+			http.Error(w, fmt.Sprintf("Error loading calendar: %v", err), http.StatusInternalServerError)
+			return
+		}
+		
+		// Set caching headers based on the calendar sync interval
+		maxAge := cfg.SyncIntervalMinutes * 60 // Convert minutes to seconds
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public", maxAge))
+		
+		if format == "jcal" || format == "xcal" {
+			cal := feed.Calendar
+			if filter.Active() {
+				cal = feed.Select(filter)
 			}
-			defer file.Close()
-			
-			// Read the calendar file
-			calData, err := io.ReadAll(file)
+			convert, mediaType, filename := ical.ToJCal, ical.JCalMediaType, "merged.json"
+			if format == "xcal" {
+				convert, mediaType, filename = ical.ToXCal, ical.XCalMediaType, "merged.xml"
+			}
+			data, err := convert(cal)
 			if err != nil {
-				log.Printf("Error reading calendar file: %v", err)
-				http.Error(w, fmt.Sprintf("Error reading calendar file: %v", err), http.StatusInternalServerError)
+				log.Printf("Error converting calendar to %s: %v", format, err)
+				http.Error(w, fmt.Sprintf("Error converting calendar: %v", err), http.StatusInternalServerError)
 				return
 			}
-			
-			// Apply Ruby compatibility fixes
-			fixedCalData := ical.RubyCompatibilityFixer(string(calData), cfg.OutputTimezone)
-
-			// Set content type and headers with charset explicitly specified
-			w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
-			w.Header().Set("Content-Disposition", "attachment; filename=\"merged.ics\"")
-			
-			// Set X-WR headers that some clients expect
-			w.Header().Set("X-WR-CALNAME", "Merged Calendar")
-			
-			// Set caching headers based on the calendar sync interval
-			maxAge := cfg.SyncIntervalMinutes * 60 // Convert minutes to seconds
-			w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public", maxAge))
-			
-			// Signal that the content is complete (not chunked)
-			w.Header().Set("Transfer-Encoding", "identity")
-			
-			// Write the fixed calendar data to the response
-			if _, err := w.Write([]byte(fixedCalData)); err != nil {
+			w.Header().Set("Content-Type", mediaType+"; charset=utf-8")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+			if _, err := w.Write(data); err != nil {
 				log.Printf("Error sending calendar: %v", err)
-				http.Error(w, fmt.Sprintf("Error sending calendar: %v", err), http.StatusInternalServerError)
 				return
 			}
-			log.Printf("Successfully served calendar to %s", r.RemoteAddr)
-		})
+			log.Printf("Successfully served %s calendar to %s", format, r.RemoteAddr)
+			return
+		}
 		
-		log.Printf("Calendar handler registered")
+		calData := string(feed.Data)
+		if filter.Active() {
+			calData = feed.Select(filter).Serialize()
+		}
 		
-		// HTTP handler to serve calendar data as JSON for TRMNL plugin
-		http.HandleFunc("/api/calendar", func(w http.ResponseWriter, r *http.Request) {
-			log.Printf("Calendar API request received from %s", r.RemoteAddr)
-			
-			// Only refresh cache if the nocache parameter is set
-			if r.URL.Query().Get("nocache") != "" {
-				log.Printf("Nocache parameter set, refreshing calendar data")
-				if err := merger.Merge(); err != nil {
-					log.Printf("Error merging calendars: %v", err)
-					http.Error(w, fmt.Sprintf("Error merging calendars: %v", err), http.StatusInternalServerError)
-					return
-				}
-			}
+		// Apply Ruby compatibility fixes
+		fixedCalData := ical.RubyCompatibilityFixer(calData, output.Timezone)
 
-			// Open the merged calendar file
-			file, err := os.Open(cfg.OutputPath)
-			if err != nil {
-				log.Printf("Error opening calendar file: %v", err)
-				http.Error(w, fmt.Sprintf("Error opening calendar file: %v", err), http.StatusInternalServerError)
+		// Set content type and headers with charset explicitly specified
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"merged.ics\"")
+		
+		// Set X-WR headers that some clients expect
+		w.Header().Set("X-WR-CALNAME", "Merged Calendar")
+		
+		// Signal that the content is complete (not chunked)
+		w.Header().Set("Transfer-Encoding", "identity")
+		
+		// Write the fixed calendar data to the response
+		if _, err := w.Write([]byte(fixedCalData)); err != nil {
+			log.Printf("Error sending calendar: %v", err)
+			http.Error(w, fmt.Sprintf("Error sending calendar: %v", err), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Successfully served calendar to %s", r.RemoteAddr)
+	}
+	
+	// HTTP handler to serve the merged calendar, as jCal or xCal if the
+	// client asks for it in the Accept header
+	serveCalendar := func(w http.ResponseWriter, r *http.Request, output config.Output) {
+		w.Header().Add("Vary", "Accept")
+		writeCalendar(w, r, output, negotiateFormat(r))
+	}
+	
+	// HTTP handler to serve the merged calendar as jCal (RFC 7265)
+	serveJCal := func(w http.ResponseWriter, r *http.Request, output config.Output) {
+		writeCalendar(w, r, output, "jcal")
+	}
+	
+	// HTTP handler to serve the merged calendar as xCal (RFC 6321)
+	serveXCal := func(w http.ResponseWriter, r *http.Request, output config.Output) {
+		writeCalendar(w, r, output, "xcal")
+	}
+	
+	mux.HandleFunc("/calendar", withOutput(cfg, serveCalendar))
+	mux.HandleFunc("/calendar/{name}", withOutput(cfg, serveCalendar))
+	mux.HandleFunc("/calendar.json", withOutput(cfg, serveJCal))
+	mux.HandleFunc("/calendar.json/{name}", withOutput(cfg, serveJCal))
+	mux.HandleFunc("/calendar.xml", withOutput(cfg, serveXCal))
+	mux.HandleFunc("/calendar.xml/{name}", withOutput(cfg, serveXCal))
+	
+	log.Printf("Calendar handler registered")
+	
+	// HTTP handler to serve calendar data as JSON for TRMNL plugin
+	serveCalendarAPI := func(w http.ResponseWriter, r *http.Request, output config.Output) {
+		log.Printf("Calendar API request received from %s", r.RemoteAddr)
+		
+		// Only refresh cache if the nocache parameter is set
+		if r.URL.Query().Get("nocache") != "" {
+			log.Printf("Nocache parameter set, refreshing calendar data")
+			if err := merger.Merge(); err != nil {
+				log.Printf("Error merging calendars: %v", err)
+				http.Error(w, fmt.Sprintf("Error merging calendars: %v", err), http.StatusInternalServerError)
 				return
 			}
-			defer file.Close()
-			
-			// Parse the calendar
-			calData, err := io.ReadAll(file)
-			if err != nil {
-				log.Printf("Error reading calendar file: %v", err)
-				http.Error(w, fmt.Sprintf("Error reading calendar file: %v", err), http.StatusInternalServerError)
-				return
+		}
+
+		loc, err := time.LoadLocation(output.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		filter, err := queryFilter(r, loc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		
+		// Use the parsed calendar of the event store
+		feed, err := merger.Store().Lookup(output)
+		if err != nil {
+			log.Printf("Error loading calendar: %v", err)
+			http.Error(w, fmt.Sprintf("Error loading calendar: %v", err), http.StatusInternalServerError)
+			return
+		}
+		calendar := feed.Select(filter)
+		
+		// Get date range from query params or use defaults
+		daysBack, daysForward, _ := queryDays(r, 1, 30)
+		now := time.Now()
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+		dateRange := map[string]string{
+			"start": today.AddDate(0, 0, -daysBack).Format("2006-01-02"),
+			"end":   today.AddDate(0, 0, daysForward).Format("2006-01-02"),
+		}
+		
+		// Filter events to the specified date range, from and to replace it
+		filteredCalendar := calendar
+		if filter.From.IsZero() && filter.To.IsZero() {
+			filteredCalendar = ical.FilterCalendarByDateRange(calendar, daysBack, daysForward)
+		} else {
+			dateRange = map[string]string{}
+			if !filter.From.IsZero() {
+				dateRange["start"] = filter.From.Format("2006-01-02")
 			}
-			
-			calendar, err := ical.ParseCalendar(strings.NewReader(string(calData)))
-			if err != nil {
-				log.Printf("Error parsing calendar: %v", err)
-				http.Error(w, fmt.Sprintf("Error parsing calendar: %v", err), http.StatusInternalServerError)
+			if !filter.To.IsZero() {
+				dateRange["end"] = filter.To.Add(-time.Nanosecond).Format("2006-01-02")
+			}
+		}
+		
+		// Convert calendar events to JSON format, grouped by day for easier template rendering
+		events := agenda.Events(filteredCalendar, now)
+		formattedDays := agenda.Days(events, now)
+		
+		// Create the final response
+		response := map[string]interface{}{
+			"days":         formattedDays,
+			"total_events": len(events),
+			"date_range":   dateRange,
+			"generated_at": time.Now().Format(time.RFC3339),
+		}
+		
+		// Set content type and headers
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "max-age=3600")
+		
+		// Serialize and return the JSON
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Printf("Error encoding calendar JSON: %v", err)
+			http.Error(w, fmt.Sprintf("Error encoding calendar JSON: %v", err), http.StatusInternalServerError)
+			return
+		}
+		
+		log.Printf("Successfully served calendar API to %s", r.RemoteAddr)
+	}
+	
+	mux.HandleFunc("/api/calendar", withOutput(cfg, serveCalendarAPI))
+	mux.HandleFunc("/api/calendar/{name}", withOutput(cfg, serveCalendarAPI))
+	
+	log.Printf("Calendar API handler registered")
+	
+	// HTTP handler to export the events as CSV, one row per occurrence
+	serveExport := func(w http.ResponseWriter, r *http.Request, output config.Output) {
+		log.Printf("CSV export request received from %s", r.RemoteAddr)
+		
+		loc, err := time.LoadLocation(output.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		filter, columns, err := queryExport(r, loc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		feed, err := merger.Store().Lookup(output)
+		if err != nil {
+			log.Printf("Error loading calendar: %v", err)
+			http.Error(w, fmt.Sprintf("Error loading calendar: %v", err), http.StatusInternalServerError)
+			return
+		}
+		
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFilename(filter)))
+		occurrences := ical.ExpandCalendar(feed.Select(filter), filter.From, filter.To, loc)
+		if err := export.WriteCSV(w, occurrences, columns, loc); err != nil {
+			log.Printf("Error sending CSV export: %v", err)
+			return
+		}
+		log.Printf("Successfully served %d events as CSV to %s", len(occurrences), r.RemoteAddr)
+	}
+	
+	mux.HandleFunc("/export.csv", withOutput(cfg, serveExport))
+	mux.HandleFunc("/export.csv/{name}", withOutput(cfg, serveExport))
+	
+	log.Printf("Export handler registered")
+	
+	// HTTP handler to serve a summary calendar (±30 days from current date)
+	serveSummary := func(w http.ResponseWriter, r *http.Request, output config.Output) {
+		log.Printf("Summary calendar request received from %s", r.RemoteAddr)
+		
+		// Only refresh cache if the nocache parameter is set
+		if r.URL.Query().Get("nocache") != "" {
+			log.Printf("Nocache parameter set, refreshing calendar data")
+			if err := merger.Merge(); err != nil {
+				log.Printf("Error merging calendars: %v", err)
+				http.Error(w, fmt.Sprintf("Error merging calendars: %v", err), http.StatusInternalServerError)
 				return
 			}
-			
-			// Get date range from query params or use defaults
-			daysBack := 1
-			daysForward := 30
-			if days := r.URL.Query().Get("days_back"); days != "" {
-				if val, err := strconv.Atoi(days); err == nil && val > 0 {
-					daysBack = val
-				}
+		}
+
+		loc, err := time.LoadLocation(output.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		filter, err := queryFilter(r, loc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		
+		// Use the parsed calendar of the event store
+		feed, err := merger.Store().Lookup(output)
+		if err != nil {
+			log.Printf("Error loading calendar: %v", err)
+			http.Error(w, fmt.Sprintf("Error loading calendar: %v", err), http.StatusInternalServerError)
+			return
+		}
+		calendar := feed.Select(filter)
+		
+		// Filter events to ±30 days (inclusive) unless the request sets a period
+		var filteredCalendar *ics.Calendar
+		if filter.From.IsZero() && filter.To.IsZero() {
+			daysBack, daysForward, _ := queryDays(r, 30, 30)
+			log.Printf("Filtering calendar events for summary.ics (inclusive date range)")
+			filteredCalendar = ical.FilterCalendarByDateRange(calendar, daysBack, daysForward)
+		} else {
+			filteredCalendar = ics.NewCalendar()
+			filteredCalendar.SetMethod(ics.MethodPublish)
+			filteredCalendar.SetProductId("-//ical_merger//GO")
+			for _, event := range calendar.Events() {
+				filteredCalendar.AddVEvent(event)
 			}
-			if days := r.URL.Query().Get("days_forward"); days != "" {
-				if val, err := strconv.Atoi(days); err == nil && val > 0 {
-					daysForward = val
+		}
+		
+		// Let the improved FilterCalendarByDateRange function handle all events 
+		// including edge cases
+		
+		// Set content type and headers
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"summary.ics\"")
+		w.Header().Set("X-WR-CALNAME", "Summary Calendar")
+		
+		// Set caching headers
+		maxAge := cfg.SyncIntervalMinutes * 60 // Convert minutes to seconds
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public", maxAge))
+		w.Header().Set("Transfer-Encoding", "identity")
+		
+		// Serialize the filtered calendar
+		serialized := filteredCalendar.Serialize()
+		
+		// Add required properties for Ruby client compatibility
+		// Insert the necessary calendar properties and VTIMEZONEs
+		var enhancedLines []string
+		
+		// First add the calendar properties
+		for _, line := range strings.Split(serialized, "\n") {
+			enhancedLines = append(enhancedLines, line)
+			if line == "VERSION:2.0" {
+				enhancedLines = append(enhancedLines, "CALSCALE:GREGORIAN")
+				enhancedLines = append(enhancedLines, "X-WR-CALNAME:Summary Calendar")
+				enhancedLines = append(enhancedLines, "X-WR-TIMEZONE:"+output.Timezone)
+			}
+		}
+		
+		// Fix any date formatting issues for Ruby icalendar library
+		// Specifically all DATE-only fields need VALUE=DATE parameter
+		for i, line := range enhancedLines {
+			// Fix all-day events by adding VALUE=DATE
+			if strings.HasPrefix(line, "DTSTART:") && len(line) == 17 { // Format: DTSTART:20250208
+				// Check that we're not accidentally adding a duplicate parameter
+				if !strings.Contains(line, "VALUE=DATE") {
+					enhancedLines[i] = strings.Replace(line, "DTSTART:", "DTSTART;VALUE=DATE:", 1)
 				}
 			}
-			
-			// Filter events to the specified date range
-			filteredCalendar := ical.FilterCalendarByDateRange(calendar, daysBack, daysForward)
-			
-			// Convert calendar events to JSON format
-			type EventJSON struct {
-				UID         string    `json:"uid"`
-				Summary     string    `json:"summary"`
-				StartTime   time.Time `json:"start_time"`
-				EndTime     time.Time `json:"end_time,omitempty"`
-				StartStr    string    `json:"start"`
-				EndStr      string    `json:"end,omitempty"`
-				Location    string    `json:"location,omitempty"`
-				Description string    `json:"description,omitempty"`
-				AllDay      bool      `json:"all_day"`
-				Categories  []string  `json:"categories,omitempty"`
-				Status      string    `json:"status,omitempty"`
+			if strings.HasPrefix(line, "DTEND:") && len(line) == 15 { // Format: DTEND:20250208
+				// Check that we're not accidentally adding a duplicate parameter
+				if !strings.Contains(line, "VALUE=DATE") {
+					enhancedLines[i] = strings.Replace(line, "DTEND:", "DTEND;VALUE=DATE:", 1)
+				}
 			}
 			
-			events := []EventJSON{}
-			now := time.Now()
-			today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
-			
-			for _, event := range filteredCalendar.Events() {
-				// Extract event properties
-				uid := event.GetProperty(ics.ComponentPropertyUniqueId).Value
-				summary := event.GetProperty(ics.ComponentPropertySummary).Value
-				
-				// Parse start time
-				var startTime time.Time
-				var isAllDay bool
-				
-				startProp := event.GetProperty(ics.ComponentPropertyDtStart)
-				if startProp == nil {
-					continue // Skip events without start time
-				}
-				
-				// Try to parse start time
-				dateFormats := []string{
-					"20060102T150405Z",     // UTC
-					"20060102T150405",      // Local
-					"20060102",             // Date only (all day)
-				}
-				
-				for _, format := range dateFormats {
-					if t, err := time.Parse(format, startProp.Value); err == nil {
-						startTime = t
-						isAllDay = format == "20060102"
-						break
-					}
-				}
-				
-				// Skip events we can't parse
-				if startTime.IsZero() {
-					log.Printf("Skipping event with unparseable date: %s", summary)
-					continue
-				}
-				
-				// Parse end time
-				var endTime time.Time
-				endProp := event.GetProperty(ics.ComponentPropertyDtEnd)
-				if endProp != nil {
-					for _, format := range dateFormats {
-						if t, err := time.Parse(format, endProp.Value); err == nil {
-							endTime = t
-							break
-						}
-					}
-				} else if isAllDay {
-					// For all-day events without end date, assume same day
-					endTime = startTime.AddDate(0, 0, 1)
-				} else {
-					// For timed events without end time, assume 1 hour
-					endTime = startTime.Add(1 * time.Hour)
-				}
-				
-				// Ensure we don't have a zero time for end_time
-				if endTime.IsZero() {
-					// Use fallback of 1 hour after start time
-					endTime = startTime.Add(1 * time.Hour)
-				}
-				
-				// Extract location if available
-				var location string
-				locProp := event.GetProperty(ics.ComponentPropertyLocation)
-				if locProp != nil {
-					location = locProp.Value
-				}
-				
-				// Extract description if available
-				var description string
-				descProp := event.GetProperty(ics.ComponentPropertyDescription)
-				if descProp != nil {
-					description = descProp.Value
-				}
-				
-				// Format times for display
-				var startStr, endStr string
-				if isAllDay {
-					startStr = startTime.Format("Jan 2")
-					if startTime.Year() != endTime.Year() || startTime.Month() != endTime.Month() || startTime.Day() != endTime.Day() {
-						endStr = endTime.AddDate(0, 0, -1).Format("Jan 2") // Subtract a day because all-day end dates are exclusive
-					}
-				} else {
-					if startTime.Year() == today.Year() && startTime.Month() == today.Month() && startTime.Day() == today.Day() {
-						startStr = startTime.Format("3:04 PM") // Same day, just show time
-					} else {
-						startStr = startTime.Format("Jan 2 3:04 PM") // Different day, show date and time
-					}
-					
-					if startTime.Year() == endTime.Year() && startTime.Month() == endTime.Month() && startTime.Day() == endTime.Day() {
-						endStr = endTime.Format("3:04 PM") // Same day, just show time
-					} else {
-						endStr = endTime.Format("Jan 2 3:04 PM") // Different day, show date and time
-					}
+			// Fix any timezone info for recurring events
+			if strings.HasPrefix(line, "DTSTART:") && strings.Contains(line, "T") { // timed event
+				// Check that we're not accidentally adding a duplicate parameter
+				if !strings.Contains(line, "TZID=") {
+					enhancedLines[i] = strings.Replace(line, "DTSTART:", "DTSTART;TZID="+output.Timezone+":", 1)
 				}
-				
-				// Extract categories if available
-				categories := []string{}
-				catProps := event.GetProperties(ics.ComponentPropertyCategories)
-				for _, prop := range catProps {
-					categories = append(categories, strings.Split(prop.Value, ",")...)
-				}
-				
-				// Extract status if available
-				status := "confirmed" // Default status
-				statusProp := event.GetProperty(ics.ComponentPropertyStatus)
-				if statusProp != nil {
-					status = strings.ToLower(statusProp.Value)
+			}
+			if strings.HasPrefix(line, "DTEND:") && strings.Contains(line, "T") { // timed event
+				// Check that we're not accidentally adding a duplicate parameter
+				if !strings.Contains(line, "TZID=") {
+					enhancedLines[i] = strings.Replace(line, "DTEND:", "DTEND;TZID="+output.Timezone+":", 1)
 				}
-				
-				// Add event to result
-				events = append(events, EventJSON{
-					UID:         uid,
-					Summary:     summary,
-					StartTime:   startTime,
-					EndTime:     endTime,
-					StartStr:    startStr,
-					EndStr:      endStr,
-					Location:    location,
-					Description: description,
-					AllDay:      isAllDay,
-					Categories:  categories,
-					Status:      status,
-				})
 			}
+		}
+		
+		// Add VTIMEZONE components after the METHOD line if not already present
+		if !strings.Contains(serialized, "BEGIN:VTIMEZONE") {
+			vtimezoneStr := fmt.Sprintf(`BEGIN:VTIMEZONE
+TZID:%s
+BEGIN:STANDARD
+DTSTART:19701101T030000
+TZOFFSETFROM:+0200
+TZOFFSETTO:+0100
+RRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU
+END:STANDARD
+BEGIN:DAYLIGHT
+DTSTART:19700329T020000
+TZOFFSETFROM:+0100
+TZOFFSETTO:+0200
+RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU
+END:DAYLIGHT
+END:VTIMEZONE`, output.Timezone)
 			
-			// Sort events by start time
-			sort.Slice(events, func(i, j int) bool {
-				return events[i].StartTime.Before(events[j].StartTime)
-			})
+			var finalLines []string
+			addedTimezone := false
 			
-			// Group events by day for easier template rendering
-			eventsByDay := make(map[string][]EventJSON)
-			for _, event := range events {
-				dateStr := event.StartTime.Format("2006-01-02")
-				eventsByDay[dateStr] = append(eventsByDay[dateStr], event)
+			for _, line := range enhancedLines {
+				finalLines = append(finalLines, line)
+				if line == "METHOD:PUBLISH" && !addedTimezone {
+					finalLines = append(finalLines, strings.Split(vtimezoneStr, "\n")...)
+					addedTimezone = true
+				}
 			}
 			
-			// Create a slice of day keys sorted chronologically
-			days := make([]string, 0, len(eventsByDay))
-			for day := range eventsByDay {
-				days = append(days, day)
+			enhancedLines = finalLines
+		}
+		
+		// Fix any malformed properties that might already have duplicate parameters
+		// For example: DTEND;VALUE=DATE:;VALUE=DATE:20250219
+		var fixedLines []string
+		for _, line := range enhancedLines {
+			// Fix malformed DTEND with duplicate parameters
+			if strings.Contains(line, "DTEND;VALUE=DATE:;VALUE=DATE:") {
+				line = strings.Replace(line, "DTEND;VALUE=DATE:;VALUE=DATE:", "DTEND;VALUE=DATE:", 1)
 			}
-			sort.Strings(days)
-			
-			// Build a formatted result for template rendering
-			type DayInfo struct {
-				Date       string     `json:"date"`
-				DateFmt    string     `json:"date_fmt"`
-				Weekday    string     `json:"weekday"`
-				IsToday    bool       `json:"is_today"`
-				IsTomorrow bool       `json:"is_tomorrow"`
-				Events     []EventJSON `json:"events"`
+			if strings.Contains(line, "DTEND;TZID="+output.Timezone+":;TZID="+output.Timezone+":") {
+				line = strings.Replace(line, "DTEND;TZID="+output.Timezone+":;TZID="+output.Timezone+":", "DTEND;TZID="+output.Timezone+":", 1)
 			}
-			
-			formattedDays := []DayInfo{}
-			tomorrow := today.AddDate(0, 0, 1)
-			
-			for _, day := range days {
-				date, _ := time.Parse("2006-01-02", day)
-				
-				isToday := date.Year() == today.Year() && date.Month() == today.Month() && date.Day() == today.Day()
-				isTomorrow := date.Year() == tomorrow.Year() && date.Month() == tomorrow.Month() && date.Day() == tomorrow.Day()
-				
-				var dateFmt string
-				if isToday {
-					dateFmt = "Today"
-				} else if isTomorrow {
-					dateFmt = "Tomorrow"
-				} else {
-					dateFmt = date.Format("Monday, Jan 2")
-				}
-				
-				formattedDays = append(formattedDays, DayInfo{
-					Date:       day,
-					DateFmt:    dateFmt,
-					Weekday:    date.Format("Monday"),
-					IsToday:    isToday,
-					IsTomorrow: isTomorrow,
-					Events:     eventsByDay[day],
-				})
+			fixedLines = append(fixedLines, line)
+		}
+		
+		enhancedOutput := strings.Join(fixedLines, "\n")
+		
+		// Send the enhanced output
+		if _, err := w.Write([]byte(enhancedOutput)); err != nil {
+			log.Printf("Error sending summary calendar: %v", err)
+			http.Error(w, fmt.Sprintf("Error sending summary calendar: %v", err), http.StatusInternalServerError)
+			return
+		}
+		
+		log.Printf("Successfully served summary calendar to %s", r.RemoteAddr)
+	}
+	
+	mux.HandleFunc("/summary", withOutput(cfg, serveSummary))
+	mux.HandleFunc("/summary/{name}", withOutput(cfg, serveSummary))
+	
+	log.Printf("Summary calendar handler registered")
+	
+	// HTTP handler for free/busy time. GET returns a VFREEBUSY for the
+	// start/end query period, POST answers an iTIP VFREEBUSY request.
+	// Only busy periods are published, never event details.
+	serveFreeBusy := func(w http.ResponseWriter, r *http.Request, output config.Output) {
+		log.Printf("Free/busy %s request received from %s", r.Method, r.RemoteAddr)
+		
+		loc, err := time.LoadLocation(output.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		
+		var request *ical.FreeBusyRequest
+		switch r.Method {
+		case http.MethodGet:
+			start, end, err := queryPeriod(r, loc, 7)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
 			}
-			
-			// Create the final response
-			response := map[string]interface{}{
-				"days":         formattedDays,
-				"total_events": len(events),
-				"date_range": map[string]string{
-					"start": today.AddDate(0, 0, -daysBack).Format("2006-01-02"),
-					"end":   today.AddDate(0, 0, daysForward).Format("2006-01-02"),
-				},
-				"generated_at": time.Now().Format(time.RFC3339),
+			request = &ical.FreeBusyRequest{Start: start, End: end}
+		case http.MethodPost:
+			requestCal, err := ical.ParseCalendar(io.LimitReader(r.Body, 1<<20))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error parsing free/busy request: %v", err), http.StatusBadRequest)
+				return
 			}
-			
-			// Set content type and headers
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("Cache-Control", "max-age=3600")
-			
-			// Serialize and return the JSON
-			if err := json.NewEncoder(w).Encode(response); err != nil {
-				log.Printf("Error encoding calendar JSON: %v", err)
-				http.Error(w, fmt.Sprintf("Error encoding calendar JSON: %v", err), http.StatusInternalServerError)
+			if request, err = ical.ParseFreeBusyRequest(requestCal); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-			
-			log.Printf("Successfully served calendar API to %s", r.RemoteAddr)
-		})
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		
+		feed, err := merger.Store().Lookup(output)
+		if err != nil {
+			log.Printf("Error loading calendar: %v", err)
+			http.Error(w, fmt.Sprintf("Error loading calendar: %v", err), http.StatusInternalServerError)
+			return
+		}
 		
-		log.Printf("Calendar API handler registered")
+		occurrences := feed.Occurrences(store.Query{From: request.Start, To: request.End, Sources: querySources(r)})
+		periods := ical.BusyPeriods(occurrences, request.Start, request.End)
 		
-		// HTTP handler to serve a summary calendar (±30 days from current date)
-		http.HandleFunc("/summary", func(w http.ResponseWriter, r *http.Request) {
-			log.Printf("Summary calendar request received from %s", r.RemoteAddr)
-			
-			// Only refresh cache if the nocache parameter is set
-			if r.URL.Query().Get("nocache") != "" {
-				log.Printf("Nocache parameter set, refreshing calendar data")
-				if err := merger.Merge(); err != nil {
-					log.Printf("Error merging calendars: %v", err)
-					http.Error(w, fmt.Sprintf("Error merging calendars: %v", err), http.StatusInternalServerError)
+		var reply *ics.Calendar
+		if r.Method == http.MethodPost {
+			reply = ical.FreeBusyReply(request, periods)
+		} else {
+			reply = ics.NewCalendar()
+			reply.SetMethod(ics.MethodPublish)
+			reply.SetProductId("-//ical_merger//GO")
+			reply.AddVBusy(ical.NewFreeBusy(fmt.Sprintf("freebusy-%d-%d", request.Start.Unix(), request.End.Unix()), request.Start, request.End, periods))
+		}
+		
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		if _, err := w.Write([]byte(reply.Serialize())); err != nil {
+			log.Printf("Error sending free/busy: %v", err)
+		}
+	}
+	
+	mux.HandleFunc("/freebusy", withOutput(cfg, serveFreeBusy))
+	mux.HandleFunc("/freebusy/{name}", withOutput(cfg, serveFreeBusy))
+	
+	log.Printf("Free/busy handler registered")
+	
+	// HTTP handler for the free slots common to the selected calendars
+	serveAvailability := func(w http.ResponseWriter, r *http.Request, output config.Output) {
+		log.Printf("Availability request received from %s", r.RemoteAddr)
+		query := r.URL.Query()
+		
+		timezone := output.Timezone
+		if tz := query.Get("tz"); tz != "" {
+			timezone = tz
+		}
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Unknown timezone %q", timezone), http.StatusBadRequest)
+			return
+		}
+		
+		req := availability.Request{Duration: time.Hour, Location: loc, AllDayBlocks: true}
+		if req.From, req.To, err = queryPeriod(r, loc, 7); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if value := query.Get("duration"); value != "" {
+			if req.Duration, err = time.ParseDuration(value); err != nil || req.Duration <= 0 {
+				http.Error(w, fmt.Sprintf("Invalid duration %q (use e.g. 90m or 2h)", value), http.StatusBadRequest)
+				return
+			}
+		}
+		if value := query.Get("hours"); value != "" {
+			if req.Windows, err = availability.ParseWindows(value); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if value := query.Get("weekdays"); value != "" {
+			req.Weekdays = make(map[time.Weekday]bool)
+			for _, day := range strings.Split(value, ",") {
+				weekday, err := config.ParseWeekday(day)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
 					return
 				}
+				req.Weekdays[weekday] = true
 			}
-
-			// Open the merged calendar file
-			file, err := os.Open(cfg.OutputPath)
-			if err != nil {
-				log.Printf("Error opening calendar file: %v", err)
-				http.Error(w, fmt.Sprintf("Error opening calendar file: %v", err), http.StatusInternalServerError)
+		}
+		switch query.Get("all_day") {
+		case "", "block":
+		case "ignore":
+			req.AllDayBlocks = false
+		default:
+			http.Error(w, "Invalid all_day (use block or ignore)", http.StatusBadRequest)
+			return
+		}
+		
+		known := make(map[string]bool)
+		for _, name := range cfg.SourcesOf(output) {
+			known[name] = true
+		}
+		sources := querySources(r)
+		for _, name := range sources {
+			if !known[name] {
+				http.Error(w, fmt.Sprintf("Unknown calendar %q", name), http.StatusBadRequest)
 				return
 			}
-			defer file.Close()
-			
-			// Parse the calendar
-			calData, err := io.ReadAll(file)
-			if err != nil {
-				log.Printf("Error reading calendar file: %v", err)
-				http.Error(w, fmt.Sprintf("Error reading calendar file: %v", err), http.StatusInternalServerError)
+		}
+		
+		feed, err := merger.Store().Lookup(output)
+		if err != nil {
+			log.Printf("Error loading calendar: %v", err)
+			http.Error(w, fmt.Sprintf("Error loading calendar: %v", err), http.StatusInternalServerError)
+			return
+		}
+		
+		occurrences := feed.Occurrences(store.Query{From: req.From, To: req.To, Sources: sources})
+		slots := availability.FreeSlots(occurrences, req)
+		
+		type SlotJSON struct {
+			Start   time.Time `json:"start"`
+			End     time.Time `json:"end"`
+			Minutes int       `json:"minutes"`
+		}
+		result := struct {
+			Start    time.Time  `json:"start"`
+			End      time.Time  `json:"end"`
+			Timezone string     `json:"timezone"`
+			Duration int        `json:"duration_minutes"`
+			Sources  []string   `json:"sources,omitempty"`
+			Slots    []SlotJSON `json:"slots"`
+		}{
+			Start:    req.From,
+			End:      req.To,
+			Timezone: loc.String(),
+			Duration: int(req.Duration.Minutes()),
+			Sources:  sources,
+			Slots:    []SlotJSON{},
+		}
+		for _, slot := range slots {
+			result.Slots = append(result.Slots, SlotJSON{
+				Start:   slot.Start.In(loc),
+				End:     slot.End.In(loc),
+				Minutes: int(slot.End.Sub(slot.Start).Minutes()),
+			})
+		}
+		
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			log.Printf("Error encoding availability: %v", err)
+		}
+	}
+	
+	mux.HandleFunc("/api/availability", withOutput(cfg, serveAvailability))
+	mux.HandleFunc("/api/availability/{name}", withOutput(cfg, serveAvailability))
+	
+	log.Printf("Availability handler registered")
+	
+	// HTTP handler for the time allocation per calendar and category
+	serveStats := func(w http.ResponseWriter, r *http.Request, output config.Output) {
+		log.Printf("Stats request received from %s", r.RemoteAddr)
+		
+		loc, err := time.LoadLocation(output.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		start, end, err := queryPeriod(r, loc, 30)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		
+		feed, err := merger.Store().Lookup(output)
+		if err != nil {
+			log.Printf("Error loading calendar: %v", err)
+			http.Error(w, fmt.Sprintf("Error loading calendar: %v", err), http.StatusInternalServerError)
+			return
+		}
+		sources := querySources(r)
+		occurrences := feed.Occurrences(store.Query{From: start, To: end, Sources: sources})
+		if len(sources) == 0 {
+			sources = cfg.SourcesOf(output)
+		}
+
+		result := stats.Compute(occurrences, start, end, loc, sources)
+		
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			log.Printf("Error encoding stats: %v", err)
+		}
+	}
+	
+	mux.HandleFunc("/api/stats", withOutput(cfg, serveStats))
+	mux.HandleFunc("/api/stats/{name}", withOutput(cfg, serveStats))
+	
+	log.Printf("Stats handler registered")
+	
+	// HTTP handler for the events added, removed or changed between syncs
+	serveChanges := func(w http.ResponseWriter, r *http.Request, output config.Output) {
+		log.Printf("Changes request received from %s", r.RemoteAddr)
+		
+		// since is an RFC 3339 time or a duration back from now like "24h"
+		var since time.Time
+		if value := r.URL.Query().Get("since"); value != "" {
+			if t, err := time.Parse(time.RFC3339, value); err == nil {
+				since = t
+			} else if d, err := time.ParseDuration(value); err == nil && d > 0 {
+				since = time.Now().Add(-d)
+			} else {
+				http.Error(w, fmt.Sprintf("Invalid since %q (use RFC 3339 or a duration like 24h)", value), http.StatusBadRequest)
 				return
 			}
-			
-			calendar, err := ical.ParseCalendar(strings.NewReader(string(calData)))
-			if err != nil {
-				log.Printf("Error parsing calendar: %v", err)
-				http.Error(w, fmt.Sprintf("Error parsing calendar: %v", err), http.StatusInternalServerError)
-				return
+		}
+		
+		types := make(map[string]bool)
+		for _, value := range r.URL.Query()["type"] {
+			for _, changeType := range strings.Split(value, ",") {
+				switch changeType = strings.TrimSpace(changeType); changeType {
+				case history.Added, history.Removed, history.TimeChanged, history.DetailsChanged:
+					types[changeType] = true
+				default:
+					http.Error(w, fmt.Sprintf("Invalid type %q (use added, removed, time-changed or details-changed)", changeType), http.StatusBadRequest)
+					return
+				}
 			}
-			
-			// Filter events to ±30 days (inclusive)
-			log.Printf("Filtering calendar events for summary.ics (inclusive date range)")
-			filteredCalendar := ical.FilterCalendarByDateRange(calendar, 30, 30)
-			
-			// Let the improved FilterCalendarByDateRange function handle all events 
-			// including edge cases
-			
-			// Set content type and headers
-			w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
-			w.Header().Set("Content-Disposition", "attachment; filename=\"summary.ics\"")
-			w.Header().Set("X-WR-CALNAME", "Summary Calendar")
-			
-			// Set caching headers
-			maxAge := cfg.SyncIntervalMinutes * 60 // Convert minutes to seconds
-			w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public", maxAge))
-			w.Header().Set("Transfer-Encoding", "identity")
-			
-			// Serialize the filtered calendar
-			output := filteredCalendar.Serialize()
-			
-			// Add required properties for Ruby client compatibility
-			// Insert the necessary calendar properties and VTIMEZONEs
-			var enhancedLines []string
-			
-			// First add the calendar properties
-			for _, line := range strings.Split(output, "\n") {
-				enhancedLines = append(enhancedLines, line)
-				if line == "VERSION:2.0" {
-					enhancedLines = append(enhancedLines, "CALSCALE:GREGORIAN")
-					enhancedLines = append(enhancedLines, "X-WR-CALNAME:Summary Calendar")
-					enhancedLines = append(enhancedLines, "X-WR-TIMEZONE:"+cfg.OutputTimezone)
+		}
+		
+		changes := []history.Change{}
+		for _, change := range merger.Changes(output.Name, since) {
+			if len(types) == 0 || types[change.Type] {
+				changes = append(changes, change)
+			}
+		}
+		
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"count":   len(changes),
+			"changes": changes,
+		}); err != nil {
+			log.Printf("Error encoding changes: %v", err)
+		}
+	}
+	
+	mux.HandleFunc("/api/changes", withOutput(cfg, serveChanges))
+	mux.HandleFunc("/api/changes/{name}", withOutput(cfg, serveChanges))
+	
+	log.Printf("Changes handler registered")
+	
+	// HTTP handler for the full-text search over the events of a feed
+	serveSearch := func(w http.ResponseWriter, r *http.Request, output config.Output) {
+		log.Printf("Search request received from %s", r.RemoteAddr)
+		
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if query == "" {
+			http.Error(w, "Missing search query q", http.StatusBadRequest)
+			return
+		}
+		limit := 50
+		if value := r.URL.Query().Get("limit"); value != "" {
+			if n, err := strconv.Atoi(value); err == nil && n > 0 {
+				limit = n
+			}
+		}
+		
+		loc, err := time.LoadLocation(output.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		// Without from or to, events are found whenever they take place
+		ranged := r.URL.Query().Get("from") != "" || r.URL.Query().Get("to") != ""
+		start, end, err := queryPeriod(r, loc, 30)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		
+		feed, err := merger.Store().Lookup(output)
+		if err != nil {
+			log.Printf("Error loading calendar: %v", err)
+			http.Error(w, fmt.Sprintf("Error loading calendar: %v", err), http.StatusInternalServerError)
+			return
+		}
+		
+		sources := make(map[string]bool)
+		for _, name := range querySources(r) {
+			sources[strings.ToLower(name)] = true
+		}
+		categories := make(map[string]bool)
+		for _, name := range queryList(r, "categories") {
+			categories[strings.ToLower(name)] = true
+		}
+		matchesAny := func(values []string, wanted map[string]bool) bool {
+			if len(wanted) == 0 {
+				return true
+			}
+			for _, value := range values {
+				if wanted[strings.ToLower(value)] {
+					return true
 				}
 			}
+			return false
+		}
+		
+		type OccurrenceJSON struct {
+			Start time.Time `json:"start"`
+			End   time.Time `json:"end"`
+		}
+		type ResultJSON struct {
+			*store.Event
+			Score       float64           `json:"score"`
+			Highlights  map[string]string `json:"highlights"`
+			Occurrences []OccurrenceJSON  `json:"occurrences,omitempty"`
+		}
+		results := []ResultJSON{}
+		total := 0
+		for _, result := range feed.Search(query) {
+			event := result.Event
+			// The info event of empty feeds is not a real event
+			if ical.IsInfoEvent(event.UID) {
+				continue
+			}
+			if !matchesAny(event.Sources, sources) || !matchesAny(event.Categories, categories) {
+				continue
+			}
 			
-			// Fix any date formatting issues for Ruby icalendar library
-			// Specifically all DATE-only fields need VALUE=DATE parameter
-			for i, line := range enhancedLines {
-				// Fix all-day events by adding VALUE=DATE
-				if strings.HasPrefix(line, "DTSTART:") && len(line) == 17 { // Format: DTSTART:20250208
-					// Check that we're not accidentally adding a duplicate parameter
-					if !strings.Contains(line, "VALUE=DATE") {
-						enhancedLines[i] = strings.Replace(line, "DTSTART:", "DTSTART;VALUE=DATE:", 1)
-					}
-				}
-				if strings.HasPrefix(line, "DTEND:") && len(line) == 15 { // Format: DTEND:20250208
-					// Check that we're not accidentally adding a duplicate parameter
-					if !strings.Contains(line, "VALUE=DATE") {
-						enhancedLines[i] = strings.Replace(line, "DTEND:", "DTEND;VALUE=DATE:", 1)
-					}
-				}
-				
-				// Fix any timezone info for recurring events
-				if strings.HasPrefix(line, "DTSTART:") && strings.Contains(line, "T") { // timed event
-					// Check that we're not accidentally adding a duplicate parameter
-					if !strings.Contains(line, "TZID=") {
-						enhancedLines[i] = strings.Replace(line, "DTSTART:", "DTSTART;TZID="+cfg.OutputTimezone+":", 1)
+			var occurrences []OccurrenceJSON
+			if ranged {
+				for _, o := range feed.Occurrences(store.Query{From: start, To: end, UID: event.UID}) {
+					if o.Event == event.VEvent {
+						occurrences = append(occurrences, OccurrenceJSON{Start: o.Start.In(loc), End: o.End.In(loc)})
 					}
 				}
-				if strings.HasPrefix(line, "DTEND:") && strings.Contains(line, "T") { // timed event
-					// Check that we're not accidentally adding a duplicate parameter
-					if !strings.Contains(line, "TZID=") {
-						enhancedLines[i] = strings.Replace(line, "DTEND:", "DTEND;TZID="+cfg.OutputTimezone+":", 1)
-					}
+				if len(occurrences) == 0 {
+					continue
 				}
 			}
 			
-			// Add VTIMEZONE components after the METHOD line if not already present
-			if !strings.Contains(output, "BEGIN:VTIMEZONE") {
-				vtimezoneStr := fmt.Sprintf(`BEGIN:VTIMEZONE
-TZID:%s
-BEGIN:STANDARD
-DTSTART:19701101T030000
-TZOFFSETFROM:+0200
-TZOFFSETTO:+0100
-RRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU
-END:STANDARD
-BEGIN:DAYLIGHT
-DTSTART:19700329T020000
-TZOFFSETFROM:+0100
-TZOFFSETTO:+0200
-RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU
-END:DAYLIGHT
-END:VTIMEZONE`, cfg.OutputTimezone)
-				
-				var finalLines []string
-				addedTimezone := false
-				
-				for _, line := range enhancedLines {
-					finalLines = append(finalLines, line)
-					if line == "METHOD:PUBLISH" && !addedTimezone {
-						finalLines = append(finalLines, strings.Split(vtimezoneStr, "\n")...)
-						addedTimezone = true
-					}
-				}
-				
-				enhancedLines = finalLines
+			total++
+			if len(results) < limit {
+				results = append(results, ResultJSON{Event: event, Score: result.Score, Highlights: result.Highlights, Occurrences: occurrences})
 			}
-			
-			// Fix any malformed properties that might already have duplicate parameters
-			// For example: DTEND;VALUE=DATE:;VALUE=DATE:20250219
-			var fixedLines []string
-			for _, line := range enhancedLines {
-				// Fix malformed DTEND with duplicate parameters
-				if strings.Contains(line, "DTEND;VALUE=DATE:;VALUE=DATE:") {
-					line = strings.Replace(line, "DTEND;VALUE=DATE:;VALUE=DATE:", "DTEND;VALUE=DATE:", 1)
-				}
-				if strings.Contains(line, "DTEND;TZID="+cfg.OutputTimezone+":;TZID="+cfg.OutputTimezone+":") {
-					line = strings.Replace(line, "DTEND;TZID="+cfg.OutputTimezone+":;TZID="+cfg.OutputTimezone+":", "DTEND;TZID="+cfg.OutputTimezone+":", 1)
-				}
-				fixedLines = append(fixedLines, line)
+		}
+		
+		response := map[string]interface{}{
+			"query":   query,
+			"count":   total,
+			"results": results,
+		}
+		if ranged {
+			response["start"] = start
+			response["end"] = end
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Printf("Error encoding search results: %v", err)
+		}
+	}
+	
+	mux.HandleFunc("/api/search", withOutput(cfg, serveSearch))
+	mux.HandleFunc("/api/search/{name}", withOutput(cfg, serveSearch))
+	
+	log.Printf("Search handler registered")
+	
+	// HTTP handler for the details of one event
+	serveEvent := func(w http.ResponseWriter, r *http.Request, output config.Output) {
+		log.Printf("Event request received from %s", r.RemoteAddr)
+		
+		feed, err := merger.Store().Lookup(output)
+		if err != nil {
+			log.Printf("Error loading calendar: %v", err)
+			http.Error(w, fmt.Sprintf("Error loading calendar: %v", err), http.StatusInternalServerError)
+			return
+		}
+		
+		uid := r.PathValue("uid")
+		event := feed.Find(uid, r.URL.Query().Get("recurrence_id"))
+		if event == nil {
+			http.Error(w, fmt.Sprintf("Unknown event %q", uid), http.StatusNotFound)
+			return
+		}
+		
+		// The other events with this UID are the overrides of single occurrences
+		var overrides []string
+		for _, other := range feed.Event(uid) {
+			if other != event && other.RecurrenceID != "" {
+				overrides = append(overrides, other.RecurrenceID)
 			}
-			
-			enhancedOutput := strings.Join(fixedLines, "\n")
-			
-			// Send the enhanced output
-			if _, err := w.Write([]byte(enhancedOutput)); err != nil {
-				log.Printf("Error sending summary calendar: %v", err)
-				http.Error(w, fmt.Sprintf("Error sending summary calendar: %v", err), http.StatusInternalServerError)
-				return
+		}
+		
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(struct {
+			*store.Event
+			Output     string             `json:"output"`
+			Properties []store.Property   `json:"properties"`
+			Alarms     [][]store.Property `json:"alarms,omitempty"`
+			Overrides  []string           `json:"overrides,omitempty"`
+		}{
+			Event:      event,
+			Output:     output.Name,
+			Properties: event.Properties(),
+			Alarms:     event.Alarms(),
+			Overrides:  overrides,
+		}); err != nil {
+			log.Printf("Error encoding event: %v", err)
+		}
+	}
+	
+	mux.HandleFunc("/api/events/{uid}", withOutput(cfg, serveEvent))
+	mux.HandleFunc("/api/events/{uid}/{name}", withOutput(cfg, serveEvent))
+	
+	log.Printf("Event handler registered")
+	
+	// HTTP handler listing the calendars of a feed with their number of
+	// events and the result of the last fetch. URLs are left out, they
+	// often contain access tokens.
+	serveSources := func(w http.ResponseWriter, r *http.Request, output config.Output) {
+		log.Printf("Sources request received from %s", r.RemoteAddr)
+		
+		feed := merger.Store().Feed(output.Name)
+		
+		fetched := make(map[string]app.SourceReport)
+		if report := merger.LastReport(); report != nil {
+			for _, source := range report.Sources {
+				fetched[source.Name] = source
 			}
-			
-			log.Printf("Successfully served summary calendar to %s", r.RemoteAddr)
-		})
+		}
 		
-		log.Printf("Summary calendar handler registered")
-
-		// Start HTTP server - correctly in a goroutine
-		log.Printf("Starting HTTP server on %s", *httpAddr)
-		go func() {
-			err := http.ListenAndServe(*httpAddr, nil)
-			log.Fatalf("*** HTTP server failed: %v ***", err)
-		}()
+		type SourceJSON struct {
+			Name       string   `json:"name"`
+			Initials   string   `json:"initials"`
+			Color      string   `json:"color,omitempty"`
+			Categories []string `json:"categories,omitempty"`
+			Privacy    string   `json:"privacy"`
+			Events     int      `json:"events"`
+			Fetched    int      `json:"fetched"`
+			Error      string   `json:"error,omitempty"`
+		}
+		sources := []SourceJSON{}
+		for _, cal := range cfg.Calendars {
+			if !slices.Contains(cfg.SourcesOf(output), cal.Name) {
+				continue
+			}
+			source := SourceJSON{
+				Name:       cal.Name,
+				Initials:   cal.Initials,
+				Color:      cal.Color,
+				Categories: cal.Categories,
+				Privacy:    cal.Privacy,
+				Fetched:    fetched[cal.Name].Fetched,
+				Error:      fetched[cal.Name].Error,
+			}
+			if source.Initials == "" {
+				source.Initials = ical.Initials(cal.Name)
+			}
+			// Show the privacy level as the merge reads it, e.g. "Busy-Only" as busy-only
+			if level, err := ical.ParsePrivacyLevel(cal.Privacy); err == nil {
+				source.Privacy = string(level)
+			}
+			if feed != nil {
+				source.Events = len(feed.Source(cal.Name))
+			}
+			sources = append(sources, source)
+		}
 		
-		log.Printf("HTTP server is now running")
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"output":  output.Name,
+			"count":   len(sources),
+			"sources": sources,
+		}); err != nil {
+			log.Printf("Error encoding sources: %v", err)
+		}
 	}
+	
+	mux.HandleFunc("/api/sources", withOutput(cfg, serveSources))
+	mux.HandleFunc("/api/sources/{name}", withOutput(cfg, serveSources))
+	
+	// HTTP handler for the upcoming occurrences of one calendar
+	serveSourceEvents := func(w http.ResponseWriter, r *http.Request, output config.Output) {
+		log.Printf("Source events request received from %s", r.RemoteAddr)
+		
+		name := r.PathValue("source")
+		if !slices.Contains(cfg.SourcesOf(output), name) {
+			http.Error(w, fmt.Sprintf("Unknown calendar %q", name), http.StatusNotFound)
+			return
+		}
+		
+		loc, err := time.LoadLocation(output.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		start, end, err := queryPeriod(r, loc, 30)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		feed, err := merger.Store().Lookup(output)
+		if err != nil {
+			log.Printf("Error loading calendar: %v", err)
+			http.Error(w, fmt.Sprintf("Error loading calendar: %v", err), http.StatusInternalServerError)
+			return
+		}
+		
+		// Each occurrence links to the details of its event
+		type OccurrenceJSON struct {
+			*store.Event
+			Start time.Time `json:"start"`
+			End   time.Time `json:"end"`
+			Link  string    `json:"link"`
+		}
+		events := []OccurrenceJSON{}
+		for _, o := range feed.Occurrences(store.Query{From: start, To: end, Sources: []string{name}, Text: r.URL.Query().Get("q")}) {
+			event := feed.Find(o.Event.GetProperty(ics.ComponentPropertyUniqueId).Value, recurrenceID(o.Event))
+			if event == nil {
+				continue
+			}
+			events = append(events, OccurrenceJSON{Event: event, Start: o.Start.In(loc), End: o.End.In(loc), Link: eventLink(output, event)})
+		}
+		
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"source": name,
+			"start":  start,
+			"end":    end,
+			"count":  len(events),
+			"events": events,
+		}); err != nil {
+			log.Printf("Error encoding source events: %v", err)
+		}
+	}
+	
+	mux.HandleFunc("/api/sources/{source}/events", withOutput(cfg, serveSourceEvents))
+	mux.HandleFunc("/api/sources/{source}/events/{name}", withOutput(cfg, serveSourceEvents))
+	
+	log.Printf("Sources handlers registered")
+}
 
-	// Set up periodic merges
-	ticker := time.NewTicker(time.Duration(cfg.SyncIntervalMinutes) * time.Minute)
-	defer ticker.Stop()
+// runExport writes the CSV export of the command line to path, "-" for
+// stdout. The events come from the output files as last written by a
+// merge. The query takes the parameters of /export.csv, and "output" for
+// a named output feed.
+func runExport(cfg *config.Config, path, query string) error {
+	r, err := http.NewRequest(http.MethodGet, "/export.csv?"+query, nil)
+	if err != nil {
+		return fmt.Errorf("invalid export query: %w", err)
+	}
+	output, ok := cfg.FindOutput(r.URL.Query().Get("output"))
+	if !ok {
+		return fmt.Errorf("unknown output %q", r.URL.Query().Get("output"))
+	}
+	loc, err := time.LoadLocation(output.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	filter, columns, err := queryExport(r, loc)
+	if err != nil {
+		return err
+	}
+	events := store.New()
+	events.Load([]config.Output{output})
+	feed, err := events.Lookup(output)
+	if err != nil {
+		return err
+	}
+	occurrences := ical.ExpandCalendar(feed.Select(filter), filter.From, filter.To, loc)
 
-	log.Printf("iCal Merger started. Merging every %d minutes", cfg.SyncIntervalMinutes)
+	w := io.Writer(os.Stdout)
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := export.WriteCSV(w, occurrences, columns, loc); err != nil {
+		return err
+	}
+	log.Printf("Exported %d events to %s", len(occurrences), path)
+	return nil
+}
 
-	for {
-		select {
-		case <-ticker.C:
-			log.Println("Starting periodic merge")
-			if err := merger.Merge(); err != nil {
-				log.Printf("Periodic merge failed: %v", err)
+// queryExport reads the parameters of the CSV export: the filters of
+// /api/calendar, with the same default period of 1 day back to 30 days
+// ahead, and the comma-separated "columns"
+func queryExport(r *http.Request, loc *time.Location) (store.Filter, []string, error) {
+	filter, err := queryFilter(r, loc)
+	if err != nil {
+		return filter, nil, err
+	}
+	columns, err := export.ParseColumns(r.URL.Query().Get("columns"))
+	if err != nil {
+		return filter, nil, err
+	}
+	if filter.From.IsZero() && filter.To.IsZero() {
+		back, forward, _ := queryDays(r, 1, 30)
+		now := time.Now().In(loc)
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+		filter.From = today.AddDate(0, 0, -back)
+		filter.To = today.AddDate(0, 0, forward+1)
+	}
+	// Occurrences need a period, a month from the given from or to
+	if filter.To.IsZero() {
+		filter.To = filter.From.AddDate(0, 0, 31)
+	}
+	if filter.From.IsZero() {
+		filter.From = filter.To.AddDate(0, 0, -31)
+	}
+	return filter, columns, nil
+}
+
+// exportFilename names the CSV file after its period, e.g.
+// events-2025-03-01-2025-03-31.csv
+func exportFilename(filter store.Filter) string {
+	return fmt.Sprintf("events-%s-%s.csv", filter.From.Format("2006-01-02"), filter.To.Add(-time.Nanosecond).Format("2006-01-02"))
+}
+
+// withOutput adapts a handler to serve the default output, or the named
+// output given by the {name} path segment
+func withOutput(cfg *config.Config, handler func(http.ResponseWriter, *http.Request, config.Output)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		output, ok := cfg.FindOutput(name)
+		if !ok {
+			log.Printf("Request for unknown output %q from %s", name, r.RemoteAddr)
+			http.Error(w, fmt.Sprintf("Unknown output %q", name), http.StatusNotFound)
+			return
+		}
+		handler(w, r, output)
+	}
+}
+
+// calendarFormats are the media types of the calendar formats served by
+// /calendar
+var calendarFormats = map[string]string{
+	"text/calendar":    "ics",
+	ical.JCalMediaType: "jcal",
+	ical.XCalMediaType: "xcal",
+}
+
+// negotiateFormat picks the calendar format from the Accept header: the
+// supported media type with the highest quality, iCalendar on ties and
+// for clients that accept anything
+func negotiateFormat(r *http.Request) string {
+	format, best := "ics", 0.0
+	for _, header := range r.Header.Values("Accept") {
+		for _, accepted := range strings.Split(header, ",") {
+			mediaType, params, _ := strings.Cut(accepted, ";")
+			candidate, ok := calendarFormats[strings.ToLower(strings.TrimSpace(mediaType))]
+			if !ok {
+				continue
+			}
+			quality := 1.0
+			for _, param := range strings.Split(params, ";") {
+				if name, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok && strings.EqualFold(name, "q") {
+					if q, err := strconv.ParseFloat(value, 64); err == nil {
+						quality = q
+					}
+				}
+			}
+			if quality > best || (quality == best && candidate == "ics") {
+				format, best = candidate, quality
 			}
 		}
 	}
-}
\ No newline at end of file
+	return format
+}
+
+// eventLink is the path of the details of an event in the feed of output
+func eventLink(output config.Output, event *store.Event) string {
+	link := "/api/events/" + url.PathEscape(event.UID)
+	if output.Name != "" {
+		link += "/" + url.PathEscape(output.Name)
+	}
+	if event.RecurrenceID != "" {
+		link += "?" + url.Values{"recurrence_id": {event.RecurrenceID}}.Encode()
+	}
+	return link
+}
+
+// recurrenceID returns the RECURRENCE-ID of an event, "" if it has none
+func recurrenceID(event *ics.VEvent) string {
+	if prop := event.GetProperty(ics.ComponentPropertyRecurrenceId); prop != nil {
+		return prop.Value
+	}
+	return ""
+}
+
+// querySources returns the calendar names of the "sources" query parameter
+func querySources(r *http.Request) []string {
+	return queryList(r, "sources")
+}
+
+// queryList returns the comma-separated values of a query parameter, which
+// may also be given several times
+func queryList(r *http.Request, name string) []string {
+	var values []string
+	for _, value := range r.URL.Query()[name] {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
+
+// eventStatuses are the accepted values of the "status" query parameter
+var eventStatuses = map[string]bool{"confirmed": true, "tentative": true, "cancelled": true}
+
+// queryFilter reads the filter parameters shared by the feed endpoints:
+// sources, categories, from/to (dates in loc, to is inclusive, or RFC 3339
+// times), q, exclude_all_day and status
+func queryFilter(r *http.Request, loc *time.Location) (store.Filter, error) {
+	query := r.URL.Query()
+	filter := store.Filter{
+		Sources:    querySources(r),
+		Categories: queryList(r, "categories"),
+		Text:       strings.TrimSpace(query.Get("q")),
+	}
+	
+	var err error
+	if filter.From, filter.To, err = queryRange(r, loc); err != nil {
+		return filter, err
+	}
+	
+	if value := query.Get("exclude_all_day"); value != "" {
+		exclude, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid exclude_all_day %q (use true or false)", value)
+		}
+		filter.ExcludeAllDay = exclude
+	}
+	for _, status := range queryList(r, "status") {
+		status = strings.ToLower(status)
+		if !eventStatuses[status] {
+			return filter, fmt.Errorf("invalid status %q (use confirmed, tentative or cancelled)", status)
+		}
+		filter.Statuses = append(filter.Statuses, status)
+	}
+	return filter, nil
+}
+
+// queryDays reads the days_back and days_forward query parameters, keeping
+// the defaults for missing or invalid values. ok reports whether any was given.
+func queryDays(r *http.Request, defaultBack, defaultForward int) (back, forward int, ok bool) {
+	back, forward = defaultBack, defaultForward
+	if days := r.URL.Query().Get("days_back"); days != "" {
+		if val, err := strconv.Atoi(days); err == nil && val > 0 {
+			back, ok = val, true
+		}
+	}
+	if days := r.URL.Query().Get("days_forward"); days != "" {
+		if val, err := strconv.Atoi(days); err == nil && val > 0 {
+			forward, ok = val, true
+		}
+	}
+	return back, forward, ok
+}
+
+// queryRange reads the "from" and "to" query parameters of all endpoints
+// that take a period, as dates (2006-01-02, in loc) or RFC 3339 times. A
+// date includes the whole day, also for to. Missing values are zero.
+func queryRange(r *http.Request, loc *time.Location) (from, to time.Time, err error) {
+	query := r.URL.Query()
+	if value := query.Get("from"); value != "" {
+		if from, err = time.ParseInLocation("2006-01-02", value, loc); err != nil {
+			if from, err = time.Parse(time.RFC3339, value); err != nil {
+				return from, to, fmt.Errorf("invalid from %q (use YYYY-MM-DD or RFC 3339)", value)
+			}
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		// A date includes the whole day
+		if to, err = time.ParseInLocation("2006-01-02", value, loc); err == nil {
+			to = to.AddDate(0, 0, 1)
+		} else if to, err = time.Parse(time.RFC3339, value); err != nil {
+			return from, to, fmt.Errorf("invalid to %q (use YYYY-MM-DD or RFC 3339)", value)
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return from, to, fmt.Errorf("to must not be before from")
+	}
+	return from, to, nil
+}
+
+// queryPeriod reads the period of queryRange, which defaults to today and
+// the following days. Without from, the period starts today; without to,
+// it lasts defaultDays.
+func queryPeriod(r *http.Request, loc *time.Location, defaultDays int) (time.Time, time.Time, error) {
+	start, end, err := queryRange(r, loc)
+	if err != nil {
+		return start, end, err
+	}
+	if start.IsZero() {
+		now := time.Now().In(loc)
+		start = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+		if !end.IsZero() && !start.Before(end) {
+			return start, end, fmt.Errorf("to must not be before from (default: today)")
+		}
+	}
+	if end.IsZero() {
+		end = start.AddDate(0, 0, defaultDays)
+	}
+	return start, end, nil
+}
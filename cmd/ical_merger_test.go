@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arthur/ical_merger/internal/app"
+	"github.com/arthur/ical_merger/internal/config"
+)
+
+// testCalendars are the source calendars of the test server
+var testCalendars = map[string]string{
+	"Arthur": `BEGIN:VEVENT
+UID:standup
+SUMMARY:Standup
+DTSTART:20250106T080000Z
+DTEND:20250106T081500Z
+RRULE:FREQ=DAILY;COUNT=5
+END:VEVENT
+BEGIN:VEVENT
+UID:standup
+RECURRENCE-ID:20250108T080000Z
+SUMMARY:Late standup
+DTSTART:20250108T100000Z
+DTEND:20250108T101500Z
+END:VEVENT
+`,
+	"Hannah": `BEGIN:VEVENT
+UID:swimming
+SUMMARY:Swimming
+DTSTART:20250107T150000Z
+DTEND:20250107T160000Z
+END:VEVENT
+`,
+}
+
+// newTestServer merges the test calendars into the default output and a
+// "kids" output with Hannah's calendar, and serves them like -serve does.
+// Arthur's events are colored steelblue.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := &config.Config{
+		OutputPath:          filepath.Join(dir, "out", "merged.ics"),
+		OutputTimezone:      "UTC",
+		SyncIntervalMinutes: 15,
+		Outputs:             []config.Output{{Name: "kids", Sources: []string{"Hannah"}}},
+	}
+	for _, name := range []string{"Arthur", "Hannah"} {
+		path := filepath.Join(dir, name+".ics")
+		data := "BEGIN:VCALENDAR\nVERSION:2.0\nPRODID:-//TEST//EN\n" + testCalendars[name] + "END:VCALENDAR\n"
+		if err := os.WriteFile(path, []byte(strings.ReplaceAll(data, "\n", "\r\n")), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg.Calendars = append(cfg.Calendars, config.Calendar{Name: name, URL: "file://" + path})
+	}
+	cfg.Calendars[0].Color = "steelblue"
+
+	merger := app.NewMerger(cfg)
+	if err := merger.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	registerHandlers(mux, cfg, merger)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// get requests a path from the test server and returns the status and body
+func get(t *testing.T, server *httptest.Server, path string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+// TestCalendarOutputs tests that /calendar/{name} serves the calendars of
+// a named output and rejects unknown outputs
+func TestCalendarOutputs(t *testing.T) {
+	server := newTestServer(t)
+
+	status, body := get(t, server, "/calendar")
+	if status != http.StatusOK || !strings.Contains(body, "SUMMARY:[Arthur] Standup") || !strings.Contains(body, "SUMMARY:[Hannah] Swimming") {
+		t.Errorf("Expected both calendars in the default output, got %d:\n%s", status, body)
+	}
+
+	status, body = get(t, server, "/calendar/kids")
+	if status != http.StatusOK || strings.Contains(body, "Standup") || !strings.Contains(body, "SUMMARY:[Hannah] Swimming") {
+		t.Errorf("Expected only Hannah's calendar in the kids output, got %d:\n%s", status, body)
+	}
+
+	for _, path := range []string{"/calendar/unknown", "/api/calendar/unknown", "/calendar.json/unknown"} {
+		if status, _ := get(t, server, path); status != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, status)
+		}
+	}
+}
+
+// TestCalendarAPISources tests the source, sources and color fields of the
+// events of /api/calendar
+func TestCalendarAPISources(t *testing.T) {
+	server := newTestServer(t)
+
+	status, body := get(t, server, "/api/calendar?from=2025-01-06&to=2025-01-07")
+	if status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", status, body)
+	}
+	var response struct {
+		Days []struct {
+			Events []struct {
+				UID     string   `json:"uid"`
+				Source  string   `json:"source"`
+				Sources []string `json:"sources"`
+				Color   string   `json:"color"`
+			} `json:"events"`
+		} `json:"days"`
+	}
+	if err := json.Unmarshal([]byte(body), &response); err != nil {
+		t.Fatalf("Invalid response: %v\n%s", err, body)
+	}
+	got := make(map[string]string)
+	for _, day := range response.Days {
+		for _, event := range day.Events {
+			got[event.UID] = event.Source + " " + strings.Join(event.Sources, "+") + " " + event.Color
+		}
+	}
+	if got["standup"] != "Arthur Arthur steelblue" || got["swimming"] != "Hannah Hannah " {
+		t.Errorf("Unexpected sources: %v\n%s", got, body)
+	}
+}
+
+// TestPeriods tests that all endpoints read their period from from and to,
+// with dates including the whole day
+func TestPeriods(t *testing.T) {
+	server := newTestServer(t)
+
+	status, body := get(t, server, "/freebusy?from=2025-01-07&to=2025-01-07")
+	if status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", status, body)
+	}
+	for _, want := range []string{
+		"DTSTART:20250107T000000Z",
+		"DTEND:20250108T000000Z",
+		"FREEBUSY;FBTYPE=BUSY:20250107T080000Z/20250107T081500Z",
+		"FREEBUSY;FBTYPE=BUSY:20250107T150000Z/20250107T160000Z",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %s in the free/busy reply:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "20250108T1") {
+		t.Errorf("Expected only Jan 7 in the free/busy reply:\n%s", body)
+	}
+
+	status, body = get(t, server, "/api/search?q=standup&from=2025-01-08&to=2025-01-08")
+	if status != http.StatusOK || !strings.Contains(body, `"count":1,`) || !strings.Contains(body, `"occurrences":[{"start":"2025-01-08T10:00:00Z"`) {
+		t.Errorf("Expected the moved standup of Jan 8, got %d:\n%s", status, body)
+	}
+
+	for _, path := range []string{
+		"/freebusy?from=2025-01-08&to=2025-01-07",
+		"/api/stats?from=yesterday",
+		"/api/availability?to=2025-13-01",
+		"/api/calendar?from=2025-01-08&to=2025-01-07",
+	} {
+		if status, _ := get(t, server, path); status != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", path, status)
+		}
+	}
+}
+
+// TestEventsAndSources tests the event and source endpoints of the default
+// and a named output
+func TestEventsAndSources(t *testing.T) {
+	server := newTestServer(t)
+
+	status, body := get(t, server, "/api/events/standup")
+	if status != http.StatusOK || !strings.Contains(body, `"summary":"[Arthur] Standup"`) || !strings.Contains(body, `"overrides":["20250108T080000Z"]`) {
+		t.Errorf("Expected the standup series with its override, got %d:\n%s", status, body)
+	}
+	status, body = get(t, server, "/api/events/standup?recurrence_id=20250108T080000Z")
+	if status != http.StatusOK || !strings.Contains(body, `"summary":"[Arthur] Late standup"`) {
+		t.Errorf("Expected the moved standup, got %d:\n%s", status, body)
+	}
+	status, body = get(t, server, "/api/events/swimming/kids")
+	if status != http.StatusOK || !strings.Contains(body, `"output":"kids"`) {
+		t.Errorf("Expected swimming in the kids output, got %d:\n%s", status, body)
+	}
+
+	status, body = get(t, server, "/api/sources/kids")
+	if status != http.StatusOK || !strings.Contains(body, `"name":"Hannah"`) || strings.Contains(body, "Arthur") {
+		t.Errorf("Expected only Hannah's calendar in the kids output, got %d:\n%s", status, body)
+	}
+	status, body = get(t, server, "/api/sources/Arthur/events?from=2025-01-08&to=2025-01-08")
+	if status != http.StatusOK || !strings.Contains(body, `"link":"/api/events/standup?recurrence_id=20250108T080000Z"`) {
+		t.Errorf("Expected a link to the moved standup, got %d:\n%s", status, body)
+	}
+	status, body = get(t, server, "/api/sources/Hannah/events/kids?from=2025-01-07&to=2025-01-07")
+	if status != http.StatusOK || !strings.Contains(body, `"link":"/api/events/swimming/kids"`) {
+		t.Errorf("Expected a link to swimming in the kids output, got %d:\n%s", status, body)
+	}
+
+	for _, path := range []string{
+		"/api/events/unknown",
+		"/api/events/standup/kids",
+		"/api/events/standup/unknown",
+		"/api/events/standup?recurrence_id=20250109T080000Z",
+		"/api/sources/unknown",
+		"/api/sources/Nobody/events",
+		"/api/sources/Arthur/events/kids",
+		"/api/sources/Hannah/events/unknown",
+	} {
+		if status, _ := get(t, server, path); status != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, status)
+		}
+	}
+}
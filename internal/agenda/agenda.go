@@ -0,0 +1,272 @@
+package agenda
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arran4/golang-ical"
+)
+
+// Event is an event as shown by the TRMNL plugin and the agenda emails
+type Event struct {
+	UID         string    `json:"uid"`
+	Summary     string    `json:"summary"`
+	StartTime   time.Time `json:"start_time"`
+	EndTime     time.Time `json:"end_time,omitempty"`
+	StartStr    string    `json:"start"`
+	EndStr      string    `json:"end,omitempty"`
+	Location    string    `json:"location,omitempty"`
+	Description string    `json:"description,omitempty"`
+	AllDay      bool      `json:"all_day"`
+	Categories  []string  `json:"categories,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	Cancelled   bool      `json:"cancelled"`
+	Tentative   bool      `json:"tentative"`
+	Source      string    `json:"source,omitempty"`
+	Sources     []string  `json:"sources,omitempty"`
+	Color       string    `json:"color,omitempty"`
+}
+
+// Day is the list of events starting on one day
+type Day struct {
+	Date       string  `json:"date"`
+	DateFmt    string  `json:"date_fmt"`
+	Weekday    string  `json:"weekday"`
+	IsToday    bool    `json:"is_today"`
+	IsTomorrow bool    `json:"is_tomorrow"`
+	Events     []Event `json:"events"`
+}
+
+// Events converts the events of a calendar as they are written, without
+// expanding recurrences, sorted by start time. now decides which events
+// are shown without a date.
+func Events(cal *ics.Calendar, now time.Time) []Event {
+	events := []Event{}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+
+	for _, event := range cal.Events() {
+		// Extract event properties
+		summary := event.GetProperty(ics.ComponentPropertySummary).Value
+
+		// Parse start time
+		var startTime time.Time
+		var isAllDay bool
+
+		startProp := event.GetProperty(ics.ComponentPropertyDtStart)
+		if startProp == nil {
+			continue // Skip events without start time
+		}
+
+		// Try to parse start time
+		dateFormats := []string{
+			"20060102T150405Z", // UTC
+			"20060102T150405",  // Local
+			"20060102",         // Date only (all day)
+		}
+
+		for _, format := range dateFormats {
+			if t, err := time.Parse(format, startProp.Value); err == nil {
+				startTime = t
+				isAllDay = format == "20060102"
+				break
+			}
+		}
+
+		// Skip events we can't parse
+		if startTime.IsZero() {
+			log.Printf("Skipping event with unparseable date: %s", summary)
+			continue
+		}
+
+		// Parse end time
+		var endTime time.Time
+		endProp := event.GetProperty(ics.ComponentPropertyDtEnd)
+		if endProp != nil {
+			for _, format := range dateFormats {
+				if t, err := time.Parse(format, endProp.Value); err == nil {
+					endTime = t
+					break
+				}
+			}
+		} else if isAllDay {
+			// For all-day events without end date, assume same day
+			endTime = startTime.AddDate(0, 0, 1)
+		} else {
+			// For timed events without end time, assume 1 hour
+			endTime = startTime.Add(1 * time.Hour)
+		}
+
+		// Ensure we don't have a zero time for end_time
+		if endTime.IsZero() {
+			// Use fallback of 1 hour after start time
+			endTime = startTime.Add(1 * time.Hour)
+		}
+
+		events = append(events, newEvent(event, startTime, endTime, isAllDay, today))
+	}
+
+	// Sort events by start time
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].StartTime.Before(events[j].StartTime)
+	})
+	return events
+}
+
+// FromOccurrences converts expanded occurrences with their times in loc,
+// sorted by start time
+func FromOccurrences(occurrences []ical.Occurrence, now time.Time, loc *time.Location) []Event {
+	events := []Event{}
+	now = now.In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	for _, o := range occurrences {
+		events = append(events, newEvent(o.Event, o.Start.In(loc), o.End.In(loc), o.AllDay, today))
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].StartTime.Before(events[j].StartTime)
+	})
+	return events
+}
+
+// newEvent converts an event with known times, today decides the display strings
+func newEvent(event *ics.VEvent, startTime, endTime time.Time, isAllDay bool, today time.Time) Event {
+	var uid, summary string
+	if uidProp := event.GetProperty(ics.ComponentPropertyUniqueId); uidProp != nil {
+		uid = uidProp.Value
+	}
+	if summaryProp := event.GetProperty(ics.ComponentPropertySummary); summaryProp != nil {
+		summary = summaryProp.Value
+	}
+
+	// Extract location if available
+	var location string
+	locProp := event.GetProperty(ics.ComponentPropertyLocation)
+	if locProp != nil {
+		location = locProp.Value
+	}
+
+	// Extract description if available
+	var description string
+	descProp := event.GetProperty(ics.ComponentPropertyDescription)
+	if descProp != nil {
+		description = descProp.Value
+	}
+
+	// Format times for display
+	var startStr, endStr string
+	if isAllDay {
+		startStr = startTime.Format("Jan 2")
+		if startTime.Year() != endTime.Year() || startTime.Month() != endTime.Month() || startTime.Day() != endTime.Day() {
+			endStr = endTime.AddDate(0, 0, -1).Format("Jan 2") // Subtract a day because all-day end dates are exclusive
+		}
+	} else {
+		if startTime.Year() == today.Year() && startTime.Month() == today.Month() && startTime.Day() == today.Day() {
+			startStr = startTime.Format("3:04 PM") // Same day, just show time
+		} else {
+			startStr = startTime.Format("Jan 2 3:04 PM") // Different day, show date and time
+		}
+
+		if startTime.Year() == endTime.Year() && startTime.Month() == endTime.Month() && startTime.Day() == endTime.Day() {
+			endStr = endTime.Format("3:04 PM") // Same day, just show time
+		} else {
+			endStr = endTime.Format("Jan 2 3:04 PM") // Different day, show date and time
+		}
+	}
+
+	// Extract categories if available
+	categories := []string{}
+	catProps := event.GetProperties(ics.ComponentPropertyCategories)
+	for _, prop := range catProps {
+		categories = append(categories, strings.Split(prop.Value, ",")...)
+	}
+
+	// Extract status if available
+	status := "confirmed" // Default status
+	statusProp := event.GetProperty(ics.ComponentPropertyStatus)
+	if statusProp != nil {
+		status = strings.ToLower(statusProp.Value)
+	}
+
+	// Extract the source calendars and color added by the merger
+	var sources []string
+	for _, prop := range event.GetProperties(ical.PropertySource) {
+		sources = append(sources, prop.Value)
+	}
+	var source string
+	if len(sources) > 0 {
+		source = sources[0]
+	}
+	var color string
+	if colorProp := event.GetProperty(ics.ComponentPropertyColor); colorProp != nil {
+		color = colorProp.Value
+	}
+
+	return Event{
+		UID:         uid,
+		Summary:     summary,
+		StartTime:   startTime,
+		EndTime:     endTime,
+		StartStr:    startStr,
+		EndStr:      endStr,
+		Location:    location,
+		Description: description,
+		AllDay:      isAllDay,
+		Categories:  categories,
+		Status:      status,
+		Cancelled:   status == "cancelled",
+		Tentative:   status == "tentative",
+		Source:      source,
+		Sources:     sources,
+		Color:       color,
+	}
+}
+
+// Days groups sorted events by the day they start on, in chronological
+// order. Today and tomorrow are named as such.
+func Days(events []Event, now time.Time) []Day {
+	// Group events by day for easier template rendering
+	eventsByDay := make(map[string][]Event)
+	for _, event := range events {
+		dateStr := event.StartTime.Format("2006-01-02")
+		eventsByDay[dateStr] = append(eventsByDay[dateStr], event)
+	}
+
+	// Create a slice of day keys sorted chronologically
+	days := make([]string, 0, len(eventsByDay))
+	for day := range eventsByDay {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	formattedDays := []Day{}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	tomorrow := today.AddDate(0, 0, 1)
+
+	for _, day := range days {
+		date, _ := time.Parse("2006-01-02", day)
+
+		isToday := date.Year() == today.Year() && date.Month() == today.Month() && date.Day() == today.Day()
+		isTomorrow := date.Year() == tomorrow.Year() && date.Month() == tomorrow.Month() && date.Day() == tomorrow.Day()
+
+		var dateFmt string
+		if isToday {
+			dateFmt = "Today"
+		} else if isTomorrow {
+			dateFmt = "Tomorrow"
+		} else {
+			dateFmt = date.Format("Monday, Jan 2")
+		}
+
+		formattedDays = append(formattedDays, Day{
+			Date:       day,
+			DateFmt:    dateFmt,
+			Weekday:    date.Format("Monday"),
+			IsToday:    isToday,
+			IsTomorrow: isTomorrow,
+			Events:     eventsByDay[day],
+		})
+	}
+	return formattedDays
+}
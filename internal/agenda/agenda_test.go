@@ -0,0 +1,70 @@
+package agenda
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arran4/golang-ical"
+)
+
+// TestEventSources tests that the source, sources and color fields of the
+// calendar API come from the properties added by the merger
+func TestEventSources(t *testing.T) {
+	parse := func(data string) *ics.Calendar {
+		cal, err := ical.ParseCalendar(strings.NewReader(strings.ReplaceAll(data, "\n", "\r\n")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cal
+	}
+	shared := `BEGIN:VEVENT
+UID:party
+SUMMARY:Party
+DTSTART:20250110T180000Z
+DTEND:20250110T220000Z
+END:VEVENT
+`
+	arthur := parse("BEGIN:VCALENDAR\nVERSION:2.0\nPRODID:-//TEST//EN\n" + shared + `BEGIN:VEVENT
+UID:standup
+SUMMARY:Standup
+DTSTART:20250106T080000Z
+DTEND:20250106T081500Z
+RRULE:FREQ=DAILY;COUNT=2
+END:VEVENT
+END:VCALENDAR
+`)
+	hannah := parse("BEGIN:VCALENDAR\nVERSION:2.0\nPRODID:-//TEST//EN\n" + shared + "END:VCALENDAR\n")
+	merged := ical.MergeCalendars([]ical.Source{
+		{Name: "Arthur", Calendar: arthur, Color: "steelblue"},
+		{Name: "Hannah", Calendar: hannah},
+	}, ical.MergeOptions{})
+
+	now := time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC)
+	check := func(name string, events []Event) {
+		got := make(map[string]string)
+		for _, event := range events {
+			got[event.UID] = event.Source + " " + strings.Join(event.Sources, "+") + " " + event.Color
+		}
+		want := map[string]string{
+			"party":   "Arthur Arthur+Hannah steelblue",
+			"standup": "Arthur Arthur steelblue",
+		}
+		for uid, w := range want {
+			if got[uid] != w {
+				t.Errorf("%s: %s: got %q, want %q", name, uid, got[uid], w)
+			}
+		}
+	}
+	check("Events", Events(merged, now))
+	occurrences := ical.ExpandCalendar(merged, now, now.AddDate(0, 0, 7), time.UTC)
+	check("FromOccurrences", FromOccurrences(occurrences, now, time.UTC))
+
+	// Events of calendars not written by the merger have no source
+	for _, event := range Events(arthur, now) {
+		if event.Source != "" || event.Sources != nil || event.Color != "" {
+			t.Errorf("Unexpected source of %s: %+v", event.UID, event)
+		}
+	}
+}
@@ -1,41 +1,180 @@
 package app
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/arthur/ical_merger/internal/config"
+	"github.com/arthur/ical_merger/internal/conflict"
+	"github.com/arthur/ical_merger/internal/email"
+	"github.com/arthur/ical_merger/internal/filter"
+	"github.com/arthur/ical_merger/internal/history"
 	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arthur/ical_merger/internal/reminder"
+	"github.com/arthur/ical_merger/internal/store"
+	"github.com/arthur/ical_merger/internal/transform"
+	"github.com/arthur/ical_merger/internal/webhook"
 	"github.com/arran4/golang-ical"
 )
 
 // Merger handles the merging of multiple calendars
 type Merger struct {
 	cfg *config.Config
+	
+	// merging is held for a whole merge, so the ticker and the handlers
+	// that force a merge never compare against the same previous files
+	// and report the same changes twice
+	merging sync.Mutex
+	
+	mu         sync.RWMutex
+	lastReport *Report
+	conflicts  []conflict.Conflict
+	
+	// store keeps the merged events of every output for the HTTP handlers
+	store *store.Store
+	// history keeps the changes between the merged calendars of successive syncs
+	history *history.History
+	// webhooks are told about the changes found by each merge
+	webhooks *webhook.Dispatcher
+	// email sends the daily agenda and the upcoming changes, nil if not configured
+	email *email.Notifier
+	// reminders notify about events shortly before they start, nil if not configured
+	reminders *reminder.Scheduler
 }
 
 // NewMerger creates a new Merger instance
 func NewMerger(cfg *config.Config) *Merger {
-	return &Merger{
-		cfg: cfg,
+	// Serve the files of the last run until the first merge is done
+	events := store.New()
+	events.Load(cfg.AllOutputs())
+	
+	m := &Merger{
+		cfg:      cfg,
+		store:    events,
+		history:  history.New(cfg.HistorySize),
+		webhooks: webhook.New(cfg.Webhooks),
+		email:    email.New(cfg, events, time.Now()),
 	}
+	m.reminders = reminder.New(cfg, events, m.email)
+	return m
+}
+
+// Store returns the merged events of all outputs
+func (m *Merger) Store() *store.Store {
+	return m.store
+}
+
+// LastReport returns the report of the most recent merge, or nil before the first merge
+func (m *Merger) LastReport() *Report {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastReport
+}
+
+// Conflicts returns the overlapping events found by the most recent merge
+func (m *Merger) Conflicts() []conflict.Conflict {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.conflicts
+}
+
+// Changes returns the changes of an output (by name, "" for the default
+// output) detected after since, oldest first
+func (m *Merger) Changes(output string, since time.Time) []history.Change {
+	return m.history.Since(output, since)
+}
+
+// Tick runs the jobs that are due at now: the daily agenda email and the
+// reminders. The main loop calls it every minute.
+func (m *Merger) Tick(now time.Time) {
+	m.email.Tick(now)
+	m.reminders.Tick(now)
 }
 
-// Merge fetches all calendars and combines them into a single iCalendar file
+// Merge fetches all calendars and combines them into a single iCalendar file.
+// Concurrent calls run one after the other.
 func (m *Merger) Merge() error {
-	calendars := make(map[string]*ics.Calendar)
+	m.merging.Lock()
+	defer m.merging.Unlock()
+	
+	report := &Report{StartedAt: time.Now()}
+	defer func() {
+		report.FinishedAt = time.Now()
+		report.Log()
+		m.mu.Lock()
+		m.lastReport = report
+		m.mu.Unlock()
+	}()
+
+	// Rules evaluate weekdays and times of day in the output timezone
+	loc, err := time.LoadLocation(m.cfg.OutputTimezone)
+	if err != nil {
+		log.Printf("Unknown output timezone %s, using UTC: %v", m.cfg.OutputTimezone, err)
+		loc = time.UTC
+	}
+
+	// Keep the sources in config order so the merged output is stable
+	var calendars []ical.Source
 
 	// Fetch each calendar
 	for _, cal := range m.cfg.Calendars {
+		sourceReport := SourceReport{Name: cal.Name}
+		
+		// Compile the rules first so a broken rule never leaks filtered events
+		rules, err := filter.New(cal.Rules, loc)
+		if err != nil {
+			log.Printf("Invalid rules for calendar %s: %v", cal.Name, err)
+			sourceReport.Error = err.Error()
+			report.Sources = append(report.Sources, sourceReport)
+			continue
+		}
+		transforms, err := transform.New(cal.Transforms, loc)
+		if err != nil {
+			log.Printf("Invalid transforms for calendar %s: %v", cal.Name, err)
+			sourceReport.Error = err.Error()
+			report.Sources = append(report.Sources, sourceReport)
+			continue
+		}
+		
 		log.Printf("Fetching calendar %s from %s", cal.Name, cal.URL)
 		calendar, err := ical.FetchCalendar(cal.URL)
 		if err != nil {
 			log.Printf("Error fetching calendar %s: %v", cal.Name, err)
+			sourceReport.Error = err.Error()
+			report.Sources = append(report.Sources, sourceReport)
 			continue
 		}
-		calendars[cal.Name] = calendar
+		sourceReport.Fetched = len(calendar.Events())
+		
+		// Clean up the events, then drop unwanted ones before merging
+		sourceReport.Transformed = transforms.Apply(calendar)
+		sourceReport.Dropped = rules.Apply(calendar)
+		report.Sources = append(report.Sources, sourceReport)
+		
+		// Unknown privacy levels fall back to busy-only rather than leaking details
+		privacy, err := ical.ParsePrivacyLevel(cal.Privacy)
+		if err != nil {
+			log.Printf("Calendar %s: %v, using %s", cal.Name, err, privacy)
+		}
+		
+		calendars = append(calendars, ical.Source{
+			Name:            cal.Name,
+			Calendar:        calendar,
+			SummaryTemplate: cal.SummaryTemplate,
+			Initials:        cal.Initials,
+			DisablePrefix:   cal.DisablePrefix,
+			Color:           cal.Color,
+			Categories:      cal.Categories,
+			Privacy:         privacy,
+			KeepAlarms:      cal.KeepAlarms,
+			Alarms:          alarmsFromConfig(cal.Alarms),
+			Travel:          travelFromConfig(cal.Travel),
+		})
 	}
 
 	if len(calendars) == 0 {
@@ -43,47 +182,262 @@ func (m *Merger) Merge() error {
 		return nil
 	}
 
+	// Write every output, a failing output doesn't stop the others
+	var errs []error
+	var changes []history.Change
+	var feeds []*store.Feed
+	for _, output := range m.cfg.AllOutputs() {
+		feed, outputChanges, err := m.writeOutput(output, calendars, report)
+		if err != nil {
+			log.Printf("Error writing output %s: %v", outputLabel(output), err)
+			errs = append(errs, err)
+		}
+		if feed != nil {
+			feeds = append(feeds, feed)
+		}
+		changes = append(changes, outputChanges...)
+	}
+	
+	// Serve the new events of all outputs at once
+	m.store.Replace(feeds...)
+	
+	// Record what changed since the last sync and tell the webhooks
+	m.history.Add(changes)
+	m.webhooks.Notify(changes)
+	m.email.NotifyChanges(changes, time.Now())
+	
+	// Look for overlapping events across and within the calendars
+	if m.cfg.Conflicts != nil {
+		if err := m.detectConflicts(calendars, loc, report); err != nil {
+			log.Printf("Error writing conflicts calendar: %v", err)
+			errs = append(errs, err)
+		}
+	}
+	
+	return errors.Join(errs...)
+}
+
+// detectConflicts stores the conflicts between the calendars and writes the
+// conflicts calendar if one is configured
+func (m *Merger) detectConflicts(sources []ical.Source, loc *time.Location, report *Report) error {
+	// The conflicts aren't tied to an output, they are reported at the
+	// strictest privacy level of all outputs
+	privacy := ical.PrivacyFull
+	for _, output := range m.cfg.AllOutputs() {
+		level, err := ical.ParsePrivacyLevel(output.Privacy)
+		if err != nil {
+			log.Printf("Output %s: %v, using %s", outputLabel(output), err, level)
+		}
+		privacy = privacy.Stricter(level)
+	}
+	conflicts := conflict.New(m.cfg.Conflicts, loc).Detect(sources, privacy, time.Now())
+	report.Conflicts = len(conflicts)
+	
+	m.mu.Lock()
+	m.conflicts = conflicts
+	m.mu.Unlock()
+	
+	if m.cfg.Conflicts.Path == "" {
+		return nil
+	}
+	
+	if err := os.MkdirAll(filepath.Dir(m.cfg.Conflicts.Path), 0755); err != nil {
+		return err
+	}
+	log.Printf("Writing %d conflicts to %s", len(conflicts), m.cfg.Conflicts.Path)
+	serialized := conflict.Calendar(conflicts, loc).Serialize()
+	return os.WriteFile(m.cfg.Conflicts.Path, []byte(ical.RubyCompatibilityFixer(serialized, m.cfg.OutputTimezone)), 0644)
+}
+
+// writeOutput merges the calendars selected by an output and writes the result
+// to its path. It returns the feed of the written file for the store and the
+// changes since the previous version of the file.
+func (m *Merger) writeOutput(output config.Output, sources []ical.Source, report *Report) (feed *store.Feed, changes []history.Change, err error) {
+	outputReport := OutputReport{Name: outputLabel(output), Path: output.Path}
+	defer func() {
+		if err != nil {
+			outputReport.Error = err.Error()
+		}
+		report.Outputs = append(report.Outputs, outputReport)
+	}()
+
+	loc, err := time.LoadLocation(output.Timezone)
+	if err != nil {
+		log.Printf("Unknown timezone %s for output %s, using UTC: %v", output.Timezone, outputLabel(output), err)
+		loc = time.UTC
+	}
+	
+	rules, err := filter.New(output.Rules, loc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid rules: %w", err)
+	}
+
+	// Select the calendars of this output. Output rules work on copies
+	// because the fetched calendars are shared between outputs.
+	selected := make(map[string]bool)
+	for _, name := range output.Sources {
+		selected[name] = true
+	}
+	var calendars []ical.Source
+	for _, source := range sources {
+		if len(selected) > 0 && !selected[source.Name] {
+			continue
+		}
+		
+		source.Calendar = copyCalendar(source.Calendar)
+		for reason, n := range rules.Apply(source.Calendar) {
+			if outputReport.Dropped == nil {
+				outputReport.Dropped = make(map[string]int)
+			}
+			outputReport.Dropped[reason] += n
+		}
+		
+		// The output's prefix style replaces the calendar's
+		if output.SummaryTemplate != "" {
+			source.SummaryTemplate = output.SummaryTemplate
+		}
+		if output.DisablePrefix {
+			source.DisablePrefix = true
+		}
+		calendars = append(calendars, source)
+	}
+
 	// Merge the calendars
-	log.Println("Merging calendars")
-	merged := ical.MergeCalendars(calendars)
+	log.Printf("Merging calendars for output %s", outputLabel(output))
+	outputPrivacy, err := ical.ParsePrivacyLevel(output.Privacy)
+	if err != nil {
+		log.Printf("Output %v, using %s", err, outputPrivacy)
+	}
+	cancelled, err := ical.ParseStatusPolicy(output.Cancelled)
+	if err != nil {
+		log.Printf("Output %s: %v", outputLabel(output), err)
+	}
+	tentative, err := ical.ParseStatusPolicy(output.Tentative)
+	if err != nil {
+		log.Printf("Output %s: %v", outputLabel(output), err)
+	}
+	merged := ical.MergeCalendars(calendars, ical.MergeOptions{
+		MultiSourceTemplate: output.MultiSourceTemplate,
+		Privacy:             outputPrivacy,
+		Alarms:              alarmsFromConfig(output.Alarms),
+		Cancelled:           cancelled,
+		Tentative:           tentative,
+	})
 
 	// Ensure we have at least one event in the merged calendar
 	if len(merged.Events()) == 0 {
 		log.Println("No events found in any calendar, creating dummy event")
 		// Add a dummy event if the calendar is empty
-		dummyEvent := ics.NewEvent("dummy-event-" + time.Now().Format("20060102150405"))
-		dummyEvent.SetProperty(ics.ComponentPropertySummary, "Calendar Merger Info")
-		dummyEvent.SetProperty(ics.ComponentPropertyDescription, "No valid events were found in any of the source calendars")
-		now := time.Now()
-		dummyEvent.SetProperty(ics.ComponentPropertyDtStart, now.Format("20060102T150405Z"))
-		dummyEvent.SetProperty(ics.ComponentPropertyDtEnd, now.Add(time.Hour).Format("20060102T150405Z"))
-		merged.AddVEvent(dummyEvent)
+		merged.AddVEvent(ical.NewInfoEvent(time.Now()))
 	}
 
 	// Ensure output directory exists
-	outputDir := filepath.Dir(m.cfg.OutputPath)
+	outputDir := filepath.Dir(output.Path)
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return err
+		return nil, nil, err
 	}
 
+	// The store still holds the previous version to find out what changed
+	// since the last sync
+	previous := m.store.Feed(output.Name)
+	
 	// Write the merged calendar to file
-	file, err := os.Create(m.cfg.OutputPath)
+	file, err := os.Create(output.Path)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	defer file.Close()
 
-	log.Printf("Writing merged calendar to %s (%d events)", m.cfg.OutputPath, len(merged.Events()))
+	log.Printf("Writing merged calendar to %s (%d events)", output.Path, len(merged.Events()))
+	outputReport.Events = len(merged.Events())
 	
 	// Serialize the calendar 
-	output := merged.Serialize()
+	serialized := merged.Serialize()
 	
 	// Apply Ruby compatibility fixes if needed
-	fixedOutput := ical.RubyCompatibilityFixer(output, m.cfg.OutputTimezone)
+	fixedOutput := ical.RubyCompatibilityFixer(serialized, output.Timezone)
 	
 	if _, err := file.WriteString(fixedOutput); err != nil {
-		return err
+		return nil, nil, err
+	}
+	
+	// Index the file as written so the store serves exactly what clients
+	// download. The file is written anyway, the store keeps serving the
+	// previous version.
+	feed, err = store.NewFeed(output.Name, []byte(fixedOutput), loc, time.Now(), ical.Attendees(calendars, outputPrivacy))
+	if err != nil {
+		return nil, nil, fmt.Errorf("not storing the events: %w", err)
+	}
+	
+	// Compare the files as written so both sides went through the same fixes.
+	// There is nothing to compare against on the first write.
+	if previous != nil {
+		changes = history.Diff(previous.Calendar, feed.Calendar, loc)
+		for i := range changes {
+			changes[i].Output = output.Name
+		}
+		outputReport.Changes = len(changes)
+		return feed, changes, nil
 	}
 	
-	return nil
-}
\ No newline at end of file
+	return feed, nil, nil
+}
+
+// alarmsFromConfig converts configured alarms, invalid ones are logged and skipped
+func alarmsFromConfig(alarms []config.Alarm) []ical.Alarm {
+	var result []ical.Alarm
+	for _, alarm := range alarms {
+		allDay, trigger, err := alarm.Trigger()
+		if err != nil {
+			log.Printf("Skipping alarm: %v", err)
+			continue
+		}
+		result = append(result, ical.Alarm{AllDay: allDay, Trigger: trigger})
+	}
+	return result
+}
+
+// travelFromConfig converts the travel settings of a calendar. Invalid
+// settings are logged and disable the buffers.
+func travelFromConfig(travel *config.Travel) *ical.Travel {
+	if travel == nil {
+		return nil
+	}
+	before, after, err := travel.Durations()
+	if err != nil {
+		log.Printf("Disabling travel buffers: %v", err)
+		return nil
+	}
+	result := &ical.Travel{Before: before, After: after}
+	for _, location := range travel.Locations {
+		pattern, err := location.Regexp()
+		if err != nil {
+			log.Printf("Disabling travel buffers: %v", err)
+			return nil
+		}
+		before, after, err := location.Durations()
+		if err != nil {
+			log.Printf("Disabling travel buffers: %v", err)
+			return nil
+		}
+		result.Locations = append(result.Locations, ical.TravelLocation{Pattern: pattern, Before: before, After: after})
+	}
+	return result
+}
+
+// copyCalendar returns a calendar sharing the components of cal, so that
+// components can be removed from the copy without touching the original
+func copyCalendar(cal *ics.Calendar) *ics.Calendar {
+	return &ics.Calendar{
+		Components:         append([]ics.Component(nil), cal.Components...),
+		CalendarProperties: cal.CalendarProperties,
+	}
+}
+
+// outputLabel names an output in logs and reports
+func outputLabel(output config.Output) string {
+	if output.Name == "" {
+		return "default"
+	}
+	return output.Name
+}
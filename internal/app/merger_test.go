@@ -0,0 +1,195 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/config"
+	"github.com/arthur/ical_merger/internal/webhook"
+)
+
+const mergerCalendar = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:standup
+SUMMARY:Standup
+DTSTART:20250106T080000Z
+DTEND:20250106T081500Z
+END:VEVENT
+END:VCALENDAR
+`
+
+// TestConcurrentMerge tests that merges running at the same time, like the
+// periodic one and a forced one, report each change once
+func TestConcurrentMerge(t *testing.T) {
+	var mu sync.Mutex
+	var received []webhook.Payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhook.Payload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Invalid payload: %v", err)
+		}
+		mu.Lock()
+		received = append(received, payload)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	// The source answers the merges together, once all of them asked or
+	// after a timeout when they run one after the other. Merges that
+	// aren't serialized then all compare against the same previous file.
+	const merges = 5
+	calendar := mergerCalendar
+	// The first merge asks too
+	var waiting sync.WaitGroup
+	waiting.Add(merges + 1)
+	released := make(chan struct{})
+	go func() {
+		waiting.Wait()
+		close(released)
+	}()
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		waiting.Done()
+		select {
+		case <-released:
+		case <-time.After(100 * time.Millisecond):
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write([]byte(strings.ReplaceAll(calendar, "\n", "\r\n")))
+	}))
+	defer source.Close()
+
+	m := NewMerger(&config.Config{
+		Calendars:      []config.Calendar{{Name: "Arthur", URL: source.URL}},
+		OutputPath:     filepath.Join(t.TempDir(), "merged.ics"),
+		OutputTimezone: "UTC",
+		Webhooks:       []config.Webhook{{URL: server.URL}},
+	})
+	if err := m.Merge(); err != nil {
+		t.Fatal(err)
+	}
+
+	// One added event for the concurrent merges to find
+	mu.Lock()
+	calendar = strings.Replace(mergerCalendar, "END:VCALENDAR", `BEGIN:VEVENT
+UID:dentist
+SUMMARY:Dentist
+DTSTART:20250107T080000Z
+DTEND:20250107T090000Z
+END:VEVENT
+END:VCALENDAR`, 1)
+	mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < merges; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := m.Merge(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	m.webhooks.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 1 || received[0].UID != "dentist" {
+		t.Errorf("Expected one delivery for the added event, got %+v", received)
+	}
+	if changes := m.history.Since("", time.Time{}); len(changes) != 1 {
+		t.Errorf("Expected one change in the history, got %d", len(changes))
+	}
+}
+
+// TestOutputs tests that each output merges its own calendars with its own
+// rules and privacy, next to the default output with everything
+func TestOutputs(t *testing.T) {
+	dir := t.TempDir()
+	for name, events := range map[string]string{
+		"Arthur": `BEGIN:VEVENT
+UID:standup
+SUMMARY:Standup
+LOCATION:Office
+DTSTART:20250106T080000Z
+DTEND:20250106T081500Z
+END:VEVENT
+`,
+		"Hannah": `BEGIN:VEVENT
+UID:swimming
+SUMMARY:Swimming
+LOCATION:Pool
+DTSTART:20250107T150000Z
+DTEND:20250107T160000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:dentist
+SUMMARY:Dentist
+DTSTART:20250108T090000Z
+DTEND:20250108T093000Z
+END:VEVENT
+`,
+	} {
+		data := "BEGIN:VCALENDAR\nVERSION:2.0\nPRODID:-//TEST//EN\n" + events + "END:VCALENDAR\n"
+		if err := os.WriteFile(filepath.Join(dir, name+".ics"), []byte(strings.ReplaceAll(data, "\n", "\r\n")), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := NewMerger(&config.Config{
+		Calendars: []config.Calendar{
+			{Name: "Arthur", URL: "file://" + filepath.Join(dir, "Arthur.ics")},
+			{Name: "Hannah", URL: "file://" + filepath.Join(dir, "Hannah.ics")},
+		},
+		OutputPath:     filepath.Join(dir, "out", "merged.ics"),
+		OutputTimezone: "UTC",
+		Outputs: []config.Output{
+			{Name: "kids", Sources: []string{"Hannah"}, Rules: &config.Rules{Exclude: []config.Rule{{Name: "no-dentist", Summary: "^Dentist"}}}},
+			{Name: "public", Privacy: "busy-only"},
+		},
+	})
+	if err := m.Merge(); err != nil {
+		t.Fatal(err)
+	}
+
+	describe := func(name string) string {
+		feed := m.Store().Feed(name)
+		if feed == nil {
+			t.Fatalf("No feed for output %q", name)
+		}
+		var events []string
+		for _, event := range feed.Events {
+			events = append(events, event.Summary+"@"+event.Location)
+		}
+		return strings.Join(events, ", ")
+	}
+	for _, tt := range []struct{ output, want string }{
+		{"", "[Arthur] Standup@Office, [Hannah] Swimming@Pool, [Hannah] Dentist@"},
+		{"kids", "[Hannah] Swimming@Pool"},
+		{"public", "[Arthur] Busy@, [Hannah] Busy@, [Hannah] Busy@"},
+	} {
+		if got := describe(tt.output); got != tt.want {
+			t.Errorf("Output %q: got %s, want %s", tt.output, got, tt.want)
+		}
+	}
+
+	// Named outputs are written next to the default output
+	if _, err := os.Stat(filepath.Join(dir, "out", "kids.ics")); err != nil {
+		t.Errorf("Expected the kids output file: %v", err)
+	}
+	for _, output := range m.LastReport().Outputs {
+		if output.Name == "kids" && output.Dropped["no-dentist"] != 1 {
+			t.Errorf("Expected the dentist dropped from kids, got %v", output.Dropped)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SourceReport describes what happened to one calendar during a merge
+type SourceReport struct {
+	Name    string `json:"name"`
+	Fetched int    `json:"fetched"`
+	// Transformed counts the events changed by the calendar's transforms
+	Transformed int            `json:"transformed,omitempty"`
+	Dropped     map[string]int `json:"dropped,omitempty"`
+	Error       string         `json:"error,omitempty"`
+}
+
+// TotalDropped returns the number of events dropped from this calendar
+func (s *SourceReport) TotalDropped() int {
+	total := 0
+	for _, n := range s.Dropped {
+		total += n
+	}
+	return total
+}
+
+// OutputReport describes one written output
+type OutputReport struct {
+	Name    string         `json:"name"`
+	Path    string         `json:"path"`
+	Events  int            `json:"events"`
+	Dropped map[string]int `json:"dropped,omitempty"`
+	// Changes counts the events added, removed or changed since the previous sync
+	Changes int    `json:"changes,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Report summarizes a merge run
+type Report struct {
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+	Sources    []SourceReport `json:"sources"`
+	Outputs    []OutputReport `json:"outputs"`
+	// Conflicts counts the overlapping events found, if conflict detection is enabled
+	Conflicts int `json:"conflicts,omitempty"`
+}
+
+// Log writes the report to the log, one line per calendar
+func (r *Report) Log() {
+	for _, source := range r.Sources {
+		if source.Error != "" {
+			log.Printf("Merge report: %s failed: %s", source.Name, source.Error)
+			continue
+		}
+
+		var reasons []string
+		for reason, n := range source.Dropped {
+			reasons = append(reasons, fmt.Sprintf("%s=%d", reason, n))
+		}
+		sort.Strings(reasons)
+
+		if len(reasons) == 0 {
+			log.Printf("Merge report: %s fetched %d events, transformed %d, dropped 0",
+				source.Name, source.Fetched, source.Transformed)
+		} else {
+			log.Printf("Merge report: %s fetched %d events, transformed %d, dropped %d (%s)",
+				source.Name, source.Fetched, source.Transformed, source.TotalDropped(), strings.Join(reasons, ", "))
+		}
+	}
+	for _, output := range r.Outputs {
+		if output.Error != "" {
+			log.Printf("Merge report: output %s failed: %s", output.Name, output.Error)
+			continue
+		}
+		dropped := 0
+		for _, n := range output.Dropped {
+			dropped += n
+		}
+		log.Printf("Merge report: output %s has %d events (%d dropped by output rules, %d changed since the last sync)",
+			output.Name, output.Events, dropped, output.Changes)
+	}
+	if r.Conflicts > 0 {
+		log.Printf("Merge report: %d conflicts found", r.Conflicts)
+	}
+	log.Printf("Merge report: finished in %s", r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond))
+}
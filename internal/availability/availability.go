@@ -0,0 +1,170 @@
+package availability
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arran4/golang-ical"
+)
+
+// Window is a time of day range in minutes after midnight, End may be 24:00
+type Window struct {
+	Start int
+	End   int
+}
+
+// AllDay is the window used when no working hours are given
+var AllDay = Window{Start: 0, End: 24 * 60}
+
+// Request describes the free slots to look for
+type Request struct {
+	// From and To delimit the searched period
+	From time.Time
+	To   time.Time
+	// Duration is the minimum length of a slot
+	Duration time.Duration
+	// Windows are the working hours of each day, evaluated in Location
+	Windows  []Window
+	Location *time.Location
+	// Weekdays limits the search to these days, empty means every day
+	Weekdays map[time.Weekday]bool
+	// AllDayBlocks makes opaque all-day events block their whole day
+	AllDayBlocks bool
+}
+
+// Slot is a free time range
+type Slot struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// ParseWindows parses working hours like "09:00-12:00,13:00-17:30"
+func ParseWindows(value string) ([]Window, error) {
+	var windows []Window
+	for _, part := range strings.Split(value, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid working hours %q (use HH:MM-HH:MM)", part)
+		}
+		start, err := parseTimeOfDay(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid working hours %q: %w", part, err)
+		}
+		end, err := parseTimeOfDay(bounds[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid working hours %q: %w", part, err)
+		}
+		if end <= start {
+			return nil, fmt.Errorf("invalid working hours %q: end must be after start", part)
+		}
+		windows = append(windows, Window{Start: start, End: end})
+	}
+	return windows, nil
+}
+
+// parseTimeOfDay parses "HH:MM" into minutes after midnight, "24:00" is the end of the day
+func parseTimeOfDay(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// FreeSlots returns the maximal free ranges of at least req.Duration within
+// the working hours, i.e. the times in which none of the occurrences is busy.
+// Transparent and cancelled events never block, tentative events do.
+func FreeSlots(occurrences []ical.Occurrence, req Request) []Slot {
+	loc := req.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	windows := req.Windows
+	if len(windows) == 0 {
+		windows = []Window{AllDay}
+	}
+
+	busy := busyRanges(occurrences, req.AllDayBlocks)
+
+	var slots []Slot
+	from, to := req.From.In(loc), req.To.In(loc)
+	for day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc); day.Before(to); day = day.AddDate(0, 0, 1) {
+		if len(req.Weekdays) > 0 && !req.Weekdays[day.Weekday()] {
+			continue
+		}
+		for _, window := range windows {
+			// Use the wall clock so windows stay put on daylight saving days
+			start := time.Date(day.Year(), day.Month(), day.Day(), window.Start/60, window.Start%60, 0, 0, loc)
+			end := time.Date(day.Year(), day.Month(), day.Day(), window.End/60, window.End%60, 0, 0, loc)
+			if start.Before(from) {
+				start = from
+			}
+			if end.After(to) {
+				end = to
+			}
+			for _, free := range subtract(Slot{Start: start, End: end}, busy) {
+				if free.End.Sub(free.Start) >= req.Duration {
+					slots = append(slots, free)
+				}
+			}
+		}
+	}
+	return slots
+}
+
+// busyRanges returns the sorted, joined busy ranges of the occurrences
+func busyRanges(occurrences []ical.Occurrence, allDayBlocks bool) []Slot {
+	var ranges []Slot
+	for _, o := range occurrences {
+		if o.AllDay && !allDayBlocks {
+			continue
+		}
+		if strings.EqualFold(ical.PropertyValue(o.Event, ics.ComponentPropertyTransp), "TRANSPARENT") ||
+			strings.EqualFold(ical.PropertyValue(o.Event, ics.ComponentPropertyStatus), "CANCELLED") {
+			continue
+		}
+		ranges = append(ranges, Slot{Start: o.Start, End: o.End})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start.Before(ranges[j].Start) })
+
+	var joined []Slot
+	for _, r := range ranges {
+		if n := len(joined); n > 0 && !r.Start.After(joined[n-1].End) {
+			if r.End.After(joined[n-1].End) {
+				joined[n-1].End = r.End
+			}
+			continue
+		}
+		joined = append(joined, r)
+	}
+	return joined
+}
+
+// subtract removes the sorted busy ranges from a window
+func subtract(window Slot, busy []Slot) []Slot {
+	var free []Slot
+	cursor := window.Start
+	for _, b := range busy {
+		if !b.End.After(cursor) {
+			continue
+		}
+		if !b.Start.Before(window.End) {
+			break
+		}
+		if b.Start.After(cursor) {
+			free = append(free, Slot{Start: cursor, End: b.Start})
+		}
+		cursor = b.End
+	}
+	if cursor.Before(window.End) {
+		free = append(free, Slot{Start: cursor, End: window.End})
+	}
+	return free
+}
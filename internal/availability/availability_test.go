@@ -0,0 +1,78 @@
+package availability
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arran4/golang-ical"
+)
+
+// TestFreeSlots tests working hours, busy events, transparent events and
+// all-day blockers
+func TestFreeSlots(t *testing.T) {
+	cal, err := ics.ParseCalendar(strings.NewReader(strings.ReplaceAll(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:dentist
+SUMMARY:Dentist
+DTSTART:20250106T100000Z
+DTEND:20250106T110000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:lunch
+SUMMARY:Lunch
+DTSTART:20250106T113000Z
+DTEND:20250106T120000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:podcast
+SUMMARY:Podcast
+TRANSP:TRANSPARENT
+DTSTART:20250106T140000Z
+DTEND:20250106T150000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:holiday
+SUMMARY:Holiday
+DTSTART;VALUE=DATE:20250107
+END:VEVENT
+END:VCALENDAR
+`, "\n", "\r\n")))
+	if err != nil {
+		t.Fatalf("Failed to parse test calendar: %v", err)
+	}
+
+	windows, err := ParseWindows("09:00-12:00,13:00-17:00")
+	if err != nil {
+		t.Fatalf("Failed to parse windows: %v", err)
+	}
+	req := Request{
+		From:         time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC),
+		To:           time.Date(2025, 1, 8, 0, 0, 0, 0, time.UTC),
+		Duration:     time.Hour,
+		Windows:      windows,
+		Location:     time.UTC,
+		AllDayBlocks: true,
+	}
+	occurrences := ical.ExpandCalendar(cal, req.From, req.To, time.UTC)
+
+	describe := func(slots []Slot) string {
+		var parts []string
+		for _, slot := range slots {
+			parts = append(parts, slot.Start.Format("02 15:04")+"-"+slot.End.Format("15:04"))
+		}
+		return strings.Join(parts, ",")
+	}
+
+	if got, want := describe(FreeSlots(occurrences, req)), "06 09:00-10:00,06 13:00-17:00"; got != want {
+		t.Errorf("Unexpected slots: got %s, want %s", got, want)
+	}
+
+	req.AllDayBlocks = false
+	if got, want := describe(FreeSlots(occurrences, req)), "06 09:00-10:00,06 13:00-17:00,07 09:00-12:00,07 13:00-17:00"; got != want {
+		t.Errorf("Unexpected slots without all-day blockers: got %s, want %s", got, want)
+	}
+}
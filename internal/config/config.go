@@ -2,13 +2,389 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/ical"
 )
 
 // Calendar represents a single calendar source
 type Calendar struct {
 	Name string `json:"name"`
 	URL  string `json:"url"`
+	
+	// SummaryTemplate is a Go text/template used to rewrite event titles,
+	// e.g. "🏠 {{.Summary}}" (default "[{{.Calendar}}] {{.Summary}}")
+	SummaryTemplate string `json:"summaryTemplate,omitempty"`
+	// Initials is the short label used in templates (default: first letter of the name)
+	Initials string `json:"initials,omitempty"`
+	// DisablePrefix keeps the original event titles of this calendar
+	DisablePrefix bool `json:"disablePrefix,omitempty"`
+	
+	// Color is emitted as the RFC 7986 COLOR of this calendar's events, e.g. "steelblue"
+	Color string `json:"color,omitempty"`
+	// Categories are added to the CATEGORIES of this calendar's events
+	Categories []string `json:"categories,omitempty"`
+	
+	// Transforms rewrite this calendar's events before rules and merging
+	Transforms []Transform `json:"transforms,omitempty"`
+	// Rules drop events from this calendar before merging
+	Rules *Rules `json:"rules,omitempty"`
+	
+	// Privacy masks this calendar's events: "full" (default), "title-only" or "busy-only"
+	Privacy string `json:"privacy,omitempty"`
+	
+	// KeepAlarms keeps the calendar's own VALARMs in the merged output
+	KeepAlarms bool `json:"keepAlarms,omitempty"`
+	// Alarms are added to this calendar's events that have no alarm of their own
+	Alarms []Alarm `json:"alarms,omitempty"`
+	
+	// Travel adds "Travel" blocks before and after events with a location
+	Travel *Travel `json:"travel,omitempty"`
+}
+
+// Travel configures the travel buffers of a calendar. Durations use Go
+// syntax like "20m" or "1h30m".
+type Travel struct {
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	// Locations override the durations for matching locations, first match wins
+	Locations []TravelLocation `json:"locations,omitempty"`
+}
+
+// TravelLocation sets the travel durations for locations matching a
+// case-insensitive regular expression
+type TravelLocation struct {
+	Pattern string `json:"pattern"`
+	Before  string `json:"before,omitempty"`
+	After   string `json:"after,omitempty"`
+}
+
+// Durations returns the parsed before and after durations, empty means zero
+func (t Travel) Durations() (before, after time.Duration, err error) {
+	return travelDurations(t.Before, t.After)
+}
+
+// Durations returns the parsed before and after durations, empty means zero
+func (l TravelLocation) Durations() (before, after time.Duration, err error) {
+	return travelDurations(l.Before, l.After)
+}
+
+// Regexp compiles the location pattern
+func (l TravelLocation) Regexp() (*regexp.Regexp, error) {
+	return regexp.Compile("(?i)" + l.Pattern)
+}
+
+// travelDurations parses a pair of travel durations
+func travelDurations(beforeText, afterText string) (before, after time.Duration, err error) {
+	for _, d := range []struct {
+		text   string
+		result *time.Duration
+	}{{beforeText, &before}, {afterText, &after}} {
+		if d.text == "" {
+			continue
+		}
+		if *d.result, err = time.ParseDuration(d.text); err != nil || *d.result < 0 {
+			return 0, 0, fmt.Errorf("invalid travel duration %q", d.text)
+		}
+	}
+	return before, after, nil
+}
+
+// validate checks the durations and location patterns
+func (t Travel) validate() error {
+	if _, _, err := t.Durations(); err != nil {
+		return err
+	}
+	for _, location := range t.Locations {
+		if _, _, err := location.Durations(); err != nil {
+			return err
+		}
+		if _, err := location.Regexp(); err != nil {
+			return fmt.Errorf("invalid travel location pattern %q: %w", location.Pattern, err)
+		}
+	}
+	return nil
+}
+
+// Alarm is a default reminder. Set Before for timed events, or At (and
+// DaysBefore) for all-day events.
+type Alarm struct {
+	// Before is the lead time for timed events, e.g. "30m"
+	Before string `json:"before,omitempty"`
+	// At is the time of day ("HH:MM") of the reminder for all-day events
+	At string `json:"at,omitempty"`
+	// DaysBefore moves the all-day reminder to an earlier day, 1 is the day before
+	DaysBefore int `json:"daysBefore,omitempty"`
+}
+
+// Trigger returns whether the alarm applies to all-day events and its offset from the event start
+func (a Alarm) Trigger() (allDay bool, offset time.Duration, err error) {
+	switch {
+	case a.Before != "" && a.At != "":
+		return false, 0, fmt.Errorf("alarm must set either before or at, not both")
+	case a.Before != "":
+		before, err := time.ParseDuration(a.Before)
+		if err != nil || before < 0 {
+			return false, 0, fmt.Errorf("invalid alarm lead time %q", a.Before)
+		}
+		return false, -before, nil
+	case a.At != "":
+		at, err := time.Parse("15:04", a.At)
+		if err != nil || a.DaysBefore < 0 {
+			return true, 0, fmt.Errorf("invalid all-day alarm at %q, %d days before", a.At, a.DaysBefore)
+		}
+		offset := time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute
+		return true, offset - time.Duration(a.DaysBefore)*24*time.Hour, nil
+	}
+	return false, 0, fmt.Errorf("alarm must set before or at")
+}
+
+// Transform is one step of a calendar's rewrite pipeline. Op is one of:
+//   - "replace": regex replace Pattern with Replacement in Property
+//   - "set": set Property to Value
+//   - "remove": remove Property
+//   - "move": move the text matching Pattern (or all text) From one property To another
+//   - "normalize-all-day": turn 00:00-23:59 style events into all-day events
+type Transform struct {
+	Op          string `json:"op"`
+	Property    string `json:"property,omitempty"`
+	Pattern     string `json:"pattern,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+	Value       string `json:"value,omitempty"`
+	From        string `json:"from,omitempty"`
+	To          string `json:"to,omitempty"`
+	// Tolerance is how far from midnight near-all-day events may start and end (default "1m")
+	Tolerance string `json:"tolerance,omitempty"`
+}
+
+// Transform operations, see Transform
+const (
+	TransformReplace         = "replace"
+	TransformSet             = "set"
+	TransformRemove          = "remove"
+	TransformMove            = "move"
+	TransformNormalizeAllDay = "normalize-all-day"
+)
+
+// DefaultTransformTolerance is how far a near-all-day event may be from midnight
+const DefaultTransformTolerance = time.Minute
+
+// PatternRegexp compiles the pattern, nil if the transform has none
+func (t Transform) PatternRegexp() (*regexp.Regexp, error) {
+	if t.Pattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(t.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	return re, nil
+}
+
+// ToleranceDuration returns the parsed tolerance, DefaultTransformTolerance if unset
+func (t Transform) ToleranceDuration() (time.Duration, error) {
+	if t.Tolerance == "" {
+		return DefaultTransformTolerance, nil
+	}
+	tolerance, err := time.ParseDuration(t.Tolerance)
+	if err != nil || tolerance < 0 {
+		return 0, fmt.Errorf("invalid tolerance %q", t.Tolerance)
+	}
+	return tolerance, nil
+}
+
+// Validate checks the operation and its settings. The properties a
+// transform writes must end up in the merged calendar, setting or removing
+// any other property has no effect. It is used both when the config is
+// loaded and when the transforms are compiled.
+func (t Transform) Validate() error {
+	var target string
+	switch t.Op {
+	case TransformReplace:
+		if t.Property == "" || t.Pattern == "" {
+			return fmt.Errorf("%s: property and pattern are required", t.Op)
+		}
+		target = t.Property
+	case TransformSet, TransformRemove:
+		if t.Property == "" {
+			return fmt.Errorf("%s: property is required", t.Op)
+		}
+		target = t.Property
+	case TransformMove:
+		if t.From == "" || t.To == "" {
+			return fmt.Errorf("%s: from and to are required", t.Op)
+		}
+		target = t.To
+	case TransformNormalizeAllDay:
+		if _, err := t.ToleranceDuration(); err != nil {
+			return fmt.Errorf("%s: %w", t.Op, err)
+		}
+	default:
+		return fmt.Errorf("unknown operation %q", t.Op)
+	}
+	if _, err := t.PatternRegexp(); err != nil {
+		return fmt.Errorf("%s: %w", t.Op, err)
+	}
+	if target != "" && !ical.IsMergedProperty(target) {
+		var names []string
+		for _, prop := range ical.MergedProperties {
+			names = append(names, string(prop))
+		}
+		return fmt.Errorf("%s: property %s is not part of merged events (use %s)", t.Op, target, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// Rules decides which events of a calendar are merged. When Include is set,
+// an event must match at least one include rule; events matching any
+// exclude rule are always dropped.
+type Rules struct {
+	Include []Rule `json:"include,omitempty"`
+	Exclude []Rule `json:"exclude,omitempty"`
+}
+
+// Rule matches an event when all of its set conditions match
+type Rule struct {
+	// Name is used in the merge report, defaults to the rule position
+	Name string `json:"name,omitempty"`
+	
+	// Summary is a regular expression matched against the event title
+	Summary string `json:"summary,omitempty"`
+	// Categories matches events having any of these categories
+	Categories []string `json:"categories,omitempty"`
+	// Status, Transp and Class match the STATUS, TRANSP and CLASS values (case-insensitive)
+	Status []string `json:"status,omitempty"`
+	Transp []string `json:"transp,omitempty"`
+	Class  []string `json:"class,omitempty"`
+	// MinDuration and MaxDuration bound the event length, e.g. "15m" or "8h"
+	MinDuration string `json:"minDuration,omitempty"`
+	MaxDuration string `json:"maxDuration,omitempty"`
+	// Weekdays matches the start day, e.g. ["Sat", "Sunday"]
+	Weekdays []string `json:"weekdays,omitempty"`
+	// StartAfter and StartBefore bound the start time of day ("HH:MM") of timed events
+	StartAfter  string `json:"startAfter,omitempty"`
+	StartBefore string `json:"startBefore,omitempty"`
+	// AllDay matches only all-day (true) or only timed (false) events
+	AllDay *bool `json:"allDay,omitempty"`
+}
+
+// SummaryRegexp compiles the summary pattern, nil if the rule has none
+func (r Rule) SummaryRegexp() (*regexp.Regexp, error) {
+	if r.Summary == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(r.Summary)
+	if err != nil {
+		return nil, fmt.Errorf("invalid summary pattern: %w", err)
+	}
+	return re, nil
+}
+
+// Durations returns the parsed minimum and maximum duration, empty means zero
+func (r Rule) Durations() (min, max time.Duration, err error) {
+	if r.MinDuration != "" {
+		if min, err = time.ParseDuration(r.MinDuration); err != nil {
+			return 0, 0, fmt.Errorf("invalid minDuration: %w", err)
+		}
+	}
+	if r.MaxDuration != "" {
+		if max, err = time.ParseDuration(r.MaxDuration); err != nil {
+			return 0, 0, fmt.Errorf("invalid maxDuration: %w", err)
+		}
+	}
+	return min, max, nil
+}
+
+// StartTimes returns StartAfter and StartBefore in minutes after midnight,
+// -1 if unset
+func (r Rule) StartTimes() (after, before int, err error) {
+	after, before = -1, -1
+	if r.StartAfter != "" {
+		if after, err = parseTimeOfDay(r.StartAfter); err != nil {
+			return -1, -1, fmt.Errorf("invalid startAfter: %w", err)
+		}
+	}
+	if r.StartBefore != "" {
+		if before, err = parseTimeOfDay(r.StartBefore); err != nil {
+			return -1, -1, fmt.Errorf("invalid startBefore: %w", err)
+		}
+	}
+	return after, before, nil
+}
+
+// Days returns the set of weekdays, nil if the rule matches any day
+func (r Rule) Days() (map[time.Weekday]bool, error) {
+	if len(r.Weekdays) == 0 {
+		return nil, nil
+	}
+	days := make(map[time.Weekday]bool)
+	for _, day := range r.Weekdays {
+		weekday, err := ParseWeekday(day)
+		if err != nil {
+			return nil, err
+		}
+		days[weekday] = true
+	}
+	return days, nil
+}
+
+// validate checks all conditions of the rules, a nil block is valid
+func (rs *Rules) validate() error {
+	if rs == nil {
+		return nil
+	}
+	for _, block := range []struct {
+		kind  string
+		rules []Rule
+	}{{"include", rs.Include}, {"exclude", rs.Exclude}} {
+		for i, r := range block.rules {
+			name := r.Name
+			if name == "" {
+				name = fmt.Sprintf("%s #%d", block.kind, i+1)
+			}
+			if _, err := r.SummaryRegexp(); err != nil {
+				return fmt.Errorf("rule %s: %w", name, err)
+			}
+			if _, _, err := r.Durations(); err != nil {
+				return fmt.Errorf("rule %s: %w", name, err)
+			}
+			if _, _, err := r.StartTimes(); err != nil {
+				return fmt.Errorf("rule %s: %w", name, err)
+			}
+			if _, err := r.Days(); err != nil {
+				return fmt.Errorf("rule %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ParseWeekday parses an English weekday name or its three-letter abbreviation
+func ParseWeekday(day string) (time.Weekday, error) {
+	day = strings.ToLower(strings.TrimSpace(day))
+	if len(day) >= 3 {
+		for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+			name := strings.ToLower(weekday.String())
+			if strings.HasPrefix(name, day) {
+				return weekday, nil
+			}
+		}
+	}
+	return time.Sunday, fmt.Errorf("invalid weekday: %q", day)
+}
+
+// parseTimeOfDay parses "HH:MM" into minutes after midnight
+func parseTimeOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
 }
 
 // Config holds the application configuration
@@ -17,8 +393,228 @@ type Config struct {
 	OutputPath         string     `json:"outputPath"`
 	SyncIntervalMinutes int       `json:"syncIntervalMinutes"`
 	OutputTimezone     string     `json:"outputTimezone"`
+	
+	// MultiSourceTemplate rewrites titles of events found in several calendars,
+	// e.g. "[{{.Initials}}] {{.Summary}}" gives "[A+H] Dinner". Empty keeps the title.
+	MultiSourceTemplate string `json:"multiSourceTemplate,omitempty"`
+	
+	// Privacy is the minimum privacy level of the merged output, see Calendar.Privacy
+	Privacy string `json:"privacy,omitempty"`
+	
+	// Alarms are added to all events of the default output that have no alarm of their own
+	Alarms []Alarm `json:"alarms,omitempty"`
+	
+	// Cancelled and Tentative handle events with that STATUS: "keep" (default), "drop" or "mark"
+	Cancelled string `json:"cancelled,omitempty"`
+	Tentative string `json:"tentative,omitempty"`
+	
+	// Outputs are additional named feeds, each merged from its own selection of calendars
+	Outputs []Output `json:"outputs,omitempty"`
+	
+	// Conflicts enables the detection of overlapping events, nil disables it
+	Conflicts *Conflicts `json:"conflicts,omitempty"`
+	
+	// HistorySize is the number of changes between syncs kept for /api/changes (default: 1000)
+	HistorySize int `json:"historySize,omitempty"`
+	
+	// Webhooks are called with the changes found by each merge
+	Webhooks []Webhook `json:"webhooks,omitempty"`
+	
+	// Email sends a daily agenda and notifies about upcoming changes, nil disables it
+	Email *Email `json:"email,omitempty"`
+	
+	// Reminders notify about events shortly before they start, nil disables them
+	Reminders *Reminders `json:"reminders,omitempty"`
+}
+
+// Reminders configures the reminders sent before the events of a feed start
+type Reminders struct {
+	// Output is the feed whose events are reminded of (default: the default feed)
+	Output string `json:"output,omitempty"`
+	// LeadTimes are how long before the start reminders are sent, e.g. ["1h", "10m"] (default: ["15m"])
+	LeadTimes []string `json:"leadTimes,omitempty"`
+	// AllDay includes all-day events, their lead times count back from midnight
+	AllDay bool `json:"allDay,omitempty"`
+	// Sources limits the reminders to events of these calendars (default: all)
+	Sources []string `json:"sources,omitempty"`
+	// Channels are where the reminders are sent
+	Channels []ReminderChannel `json:"channels"`
+	// StatePath remembers the reminders sent across restarts (default: reminders.json next to outputPath)
+	StatePath string `json:"statePath,omitempty"`
 }
 
+// ReminderChannel is one way of delivering reminders
+type ReminderChannel struct {
+	// Type is "webhook" (JSON POST), "ntfy" (plain-text push to a topic URL) or "email"
+	Type string `json:"type"`
+	// URL of the webhook or ntfy topic, e.g. "https://ntfy.sh/family-calendar"
+	URL string `json:"url,omitempty"`
+	// Secret signs webhook requests like the change webhooks
+	Secret string `json:"secret,omitempty"`
+	// Token is sent as a bearer token to ntfy
+	Token string `json:"token,omitempty"`
+	// Priority of ntfy messages, 1 (min) to 5 (max)
+	Priority int `json:"priority,omitempty"`
+}
+
+// Leads returns the parsed lead times
+func (r Reminders) Leads() ([]time.Duration, error) {
+	if len(r.LeadTimes) == 0 {
+		return []time.Duration{15 * time.Minute}, nil
+	}
+	var leads []time.Duration
+	for _, text := range r.LeadTimes {
+		d, err := time.ParseDuration(text)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid lead time %q (use a duration like 15m or 24h)", text)
+		}
+		leads = append(leads, d)
+	}
+	return leads, nil
+}
+
+// Email configures the SMTP server and the emails about a feed
+type Email struct {
+	Host string `json:"host"`
+	// Port of the SMTP server (default: 587, or 465 with "tls" security)
+	Port     int    `json:"port,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Security is "starttls" (default), "tls" for implicit TLS or "none"
+	Security string   `json:"security,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	// Output is the feed the emails are about (default: the default feed)
+	Output string `json:"output,omitempty"`
+	
+	// DigestTime is when the daily agenda is sent, "HH:MM" in the feed's timezone (default: "07:00")
+	DigestTime string `json:"digestTime,omitempty"`
+	// DigestDays is how many days the agenda covers (default: 1, today only)
+	DigestDays int `json:"digestDays,omitempty"`
+	// DisableDigest turns the daily agenda off
+	DisableDigest bool `json:"disableDigest,omitempty"`
+	
+	// ChangeWindow sends an email right away when events starting within this
+	// duration are added, removed or changed (default: "48h")
+	ChangeWindow string `json:"changeWindow,omitempty"`
+	// DisableChanges turns the change emails off
+	DisableChanges bool `json:"disableChanges,omitempty"`
+}
+
+// Webhook posts the changes of a feed as JSON to a URL
+type Webhook struct {
+	URL string `json:"url"`
+	// Output is the feed whose changes are posted (default: the default feed)
+	Output string `json:"output,omitempty"`
+	// Secret signs the payloads with HMAC-SHA256 in the X-ICalMerger-Signature header
+	Secret string `json:"secret,omitempty"`
+	// Sources limits the hook to events of these calendars (default: all)
+	Sources []string `json:"sources,omitempty"`
+	// Types limits the hook to these change types (default: added, removed and time-changed)
+	Types []string `json:"types,omitempty"`
+	// Retries is how often a failed delivery is retried (default: 3)
+	Retries *int `json:"retries,omitempty"`
+	// Timeout of each delivery attempt, e.g. "5s" (default: 10s)
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// Conflicts configures the overlap detection served at /api/conflicts
+type Conflicts struct {
+	// Pairs lists the calendar pairs whose overlapping events conflict, e.g.
+	// ["Arthur", "Hannah"], or ["Arthur", "Arthur"] for double bookings.
+	// "*" matches any calendar. Empty means every pair, including double bookings.
+	Pairs [][]string `json:"pairs,omitempty"`
+	// Days is how many days ahead conflicts are searched (default: 30)
+	Days int `json:"days,omitempty"`
+	// Path writes a calendar of "Conflict" marker events, served at /conflicts
+	Path string `json:"path,omitempty"`
+}
+
+// Output is a named feed served at /calendar/{name}, /summary/{name} and /api/calendar/{name}
+type Output struct {
+	Name string `json:"name"`
+	// Path of the merged file (default: <name>.ics next to outputPath)
+	Path string `json:"path,omitempty"`
+	// Sources lists the calendar names merged into this feed (default: all)
+	Sources []string `json:"sources,omitempty"`
+	// Rules drop events from this feed only
+	Rules *Rules `json:"rules,omitempty"`
+	// Privacy is the minimum privacy level of this feed
+	Privacy string `json:"privacy,omitempty"`
+	// SummaryTemplate replaces the calendar summary templates in this feed
+	SummaryTemplate string `json:"summaryTemplate,omitempty"`
+	// DisablePrefix keeps the original event titles in this feed
+	DisablePrefix bool `json:"disablePrefix,omitempty"`
+	// MultiSourceTemplate rewrites titles of events found in several calendars
+	MultiSourceTemplate string `json:"multiSourceTemplate,omitempty"`
+	// Timezone of this feed (default: outputTimezone)
+	Timezone string `json:"timezone,omitempty"`
+	// Alarms are added to all events of this feed that have no alarm of their own
+	Alarms []Alarm `json:"alarms,omitempty"`
+	// Cancelled and Tentative handle events with that STATUS in this feed
+	Cancelled string `json:"cancelled,omitempty"`
+	Tentative string `json:"tentative,omitempty"`
+}
+
+// SourcesOf returns the names of the calendars merged into an output
+func (c *Config) SourcesOf(output Output) []string {
+	if len(output.Sources) > 0 {
+		return output.Sources
+	}
+	names := make([]string, 0, len(c.Calendars))
+	for _, cal := range c.Calendars {
+		names = append(names, cal.Name)
+	}
+	return names
+}
+
+// outputNamePattern restricts output names to what is safe in URLs and file names
+var outputNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+
+
+// TimeoutDuration returns the parsed delivery timeout, 0 if none is set
+func (w Webhook) TimeoutDuration() (time.Duration, error) {
+	if w.Timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(w.Timeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q", w.Timeout)
+	}
+	return d, nil
+}
+
+// emailSecurity are the accepted values of the email security setting
+var emailSecurity = map[string]bool{"": true, "starttls": true, "tls": true, "none": true}
+
+// DigestClock returns the time of day of the daily agenda in minutes after midnight
+func (e Email) DigestClock() (int, error) {
+	if e.DigestTime == "" {
+		return 7 * 60, nil
+	}
+	t, err := time.Parse("15:04", e.DigestTime)
+	if err != nil {
+		return 0, fmt.Errorf("invalid digestTime %q (use HH:MM)", e.DigestTime)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// ChangeWindowDuration returns how far ahead changes are mailed
+func (e Email) ChangeWindowDuration() (time.Duration, error) {
+	if e.ChangeWindow == "" {
+		return 48 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(e.ChangeWindow)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid changeWindow %q", e.ChangeWindow)
+	}
+	return d, nil
+}
+
+// changeTypes are the accepted values of the webhook types
+var changeTypes = map[string]bool{"added": true, "removed": true, "time-changed": true, "details-changed": true}
+
 // Load reads configuration from the config file
 func Load() (*Config, error) {
 	configPath := os.Getenv("CONFIG_PATH")
@@ -36,6 +632,11 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
+	// Validate early so typos are reported at startup
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
 	// Set default interval if not specified
 	if cfg.SyncIntervalMinutes <= 0 {
 		cfg.SyncIntervalMinutes = 15
@@ -57,4 +658,261 @@ func Load() (*Config, error) {
 	}
 
 	return &cfg, nil
-}
\ No newline at end of file
+}
+
+// DefaultOutput returns the feed configured by the top-level settings,
+// which merges all calendars and is served at /calendar
+func (c *Config) DefaultOutput() Output {
+	return Output{
+		Path:                c.OutputPath,
+		Privacy:             c.Privacy,
+		MultiSourceTemplate: c.MultiSourceTemplate,
+		Timezone:            c.OutputTimezone,
+		Alarms:              c.Alarms,
+		Cancelled:           c.Cancelled,
+		Tentative:           c.Tentative,
+	}
+}
+
+// AllOutputs returns the default output followed by the named outputs,
+// with their paths and timezones defaulted from the top-level settings
+func (c *Config) AllOutputs() []Output {
+	outputs := []Output{c.DefaultOutput()}
+	for _, output := range c.Outputs {
+		if output.Path == "" {
+			output.Path = filepath.Join(filepath.Dir(c.OutputPath), output.Name+".ics")
+		}
+		if output.Timezone == "" {
+			output.Timezone = c.OutputTimezone
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs
+}
+
+// FindOutput returns the output with the given name, "" is the default output
+func (c *Config) FindOutput(name string) (Output, bool) {
+	for _, output := range c.AllOutputs() {
+		if output.Name == name {
+			return output, true
+		}
+	}
+	return Output{}, false
+}
+
+// validate checks the settings that would otherwise only fail during a merge
+func (c *Config) validate() error {
+	calendarNames := make(map[string]bool)
+	for _, cal := range c.Calendars {
+		calendarNames[cal.Name] = true
+		
+		// Validate summary templates early so typos are reported at startup
+		if cal.SummaryTemplate != "" {
+			if _, err := ical.ParseSummaryTemplate(cal.Name, cal.SummaryTemplate); err != nil {
+				return fmt.Errorf("invalid summaryTemplate for calendar %s: %w", cal.Name, err)
+			}
+		}
+		// Validate privacy levels, a typo must not publish details by accident
+		if _, err := ical.ParsePrivacyLevel(cal.Privacy); err != nil {
+			return fmt.Errorf("calendar %s: %w", cal.Name, err)
+		}
+		for _, alarm := range cal.Alarms {
+			if _, _, err := alarm.Trigger(); err != nil {
+				return fmt.Errorf("calendar %s: %w", cal.Name, err)
+			}
+		}
+		if cal.Travel != nil {
+			if err := cal.Travel.validate(); err != nil {
+				return fmt.Errorf("calendar %s: %w", cal.Name, err)
+			}
+		}
+		if err := cal.Rules.validate(); err != nil {
+			return fmt.Errorf("calendar %s: %w", cal.Name, err)
+		}
+		for i, transform := range cal.Transforms {
+			if err := transform.Validate(); err != nil {
+				return fmt.Errorf("calendar %s: transform #%d: %w", cal.Name, i+1, err)
+			}
+		}
+	}
+	for _, alarm := range c.Alarms {
+		if _, _, err := alarm.Trigger(); err != nil {
+			return err
+		}
+	}
+	if _, err := ical.ParsePrivacyLevel(c.Privacy); err != nil {
+		return err
+	}
+	if _, err := ical.ParseStatusPolicy(c.Cancelled); err != nil {
+		return fmt.Errorf("cancelled: %w", err)
+	}
+	if _, err := ical.ParseStatusPolicy(c.Tentative); err != nil {
+		return fmt.Errorf("tentative: %w", err)
+	}
+	if c.MultiSourceTemplate != "" {
+		if _, err := ical.ParseMultiSourceTemplate("multiSourceTemplate", c.MultiSourceTemplate); err != nil {
+			return fmt.Errorf("invalid multiSourceTemplate: %w", err)
+		}
+	}
+	
+	if c.Conflicts != nil {
+		for _, pair := range c.Conflicts.Pairs {
+			if len(pair) != 2 {
+				return fmt.Errorf("conflict pairs need exactly two calendars, got %v", pair)
+			}
+			for _, name := range pair {
+				if name != "*" && !calendarNames[name] {
+					return fmt.Errorf("conflict pair %v: unknown calendar %q", pair, name)
+				}
+			}
+		}
+		if c.Conflicts.Days < 0 {
+			return fmt.Errorf("invalid conflict days %d", c.Conflicts.Days)
+		}
+	}
+	
+	if c.HistorySize < 0 {
+		return fmt.Errorf("invalid historySize %d", c.HistorySize)
+	}
+	
+	outputNames := make(map[string]bool)
+	for _, output := range c.Outputs {
+		if !outputNamePattern.MatchString(output.Name) {
+			return fmt.Errorf("invalid output name %q (use letters, digits, - and _)", output.Name)
+		}
+		if outputNames[output.Name] {
+			return fmt.Errorf("duplicate output name %q", output.Name)
+		}
+		outputNames[output.Name] = true
+		
+		for _, source := range output.Sources {
+			if !calendarNames[source] {
+				return fmt.Errorf("output %s: unknown calendar %q", output.Name, source)
+			}
+		}
+		if _, err := ical.ParsePrivacyLevel(output.Privacy); err != nil {
+			return fmt.Errorf("output %s: %w", output.Name, err)
+		}
+		if err := output.Rules.validate(); err != nil {
+			return fmt.Errorf("output %s: %w", output.Name, err)
+		}
+		if _, err := ical.ParseStatusPolicy(output.Cancelled); err != nil {
+			return fmt.Errorf("output %s: cancelled: %w", output.Name, err)
+		}
+		if _, err := ical.ParseStatusPolicy(output.Tentative); err != nil {
+			return fmt.Errorf("output %s: tentative: %w", output.Name, err)
+		}
+		for _, alarm := range output.Alarms {
+			if _, _, err := alarm.Trigger(); err != nil {
+				return fmt.Errorf("output %s: %w", output.Name, err)
+			}
+		}
+		if output.SummaryTemplate != "" {
+			if _, err := ical.ParseSummaryTemplate("summaryTemplate", output.SummaryTemplate); err != nil {
+				return fmt.Errorf("output %s: invalid summaryTemplate: %w", output.Name, err)
+			}
+		}
+		if output.MultiSourceTemplate != "" {
+			if _, err := ical.ParseMultiSourceTemplate("multiSourceTemplate", output.MultiSourceTemplate); err != nil {
+				return fmt.Errorf("output %s: invalid multiSourceTemplate: %w", output.Name, err)
+			}
+		}
+	}
+	
+	// Each feed needs its own file, otherwise merges overwrite each other
+	// and the store reloads the wrong events after a restart
+	outputPaths := make(map[string]string)
+	for _, output := range c.AllOutputs() {
+		name := output.Name
+		if name == "" {
+			name = "default"
+		}
+		path := filepath.Clean(output.Path)
+		if other, ok := outputPaths[path]; ok {
+			return fmt.Errorf("outputs %s and %s both write %s", other, name, path)
+		}
+		outputPaths[path] = name
+	}
+	
+	for _, hook := range c.Webhooks {
+		if !strings.HasPrefix(hook.URL, "http://") && !strings.HasPrefix(hook.URL, "https://") {
+			return fmt.Errorf("webhook %q: the URL must start with http:// or https://", hook.URL)
+		}
+		if hook.Output != "" && !outputNames[hook.Output] {
+			return fmt.Errorf("webhook %s: unknown output %q", hook.URL, hook.Output)
+		}
+		for _, source := range hook.Sources {
+			if !calendarNames[source] {
+				return fmt.Errorf("webhook %s: unknown calendar %q", hook.URL, source)
+			}
+		}
+		for _, changeType := range hook.Types {
+			if !changeTypes[changeType] {
+				return fmt.Errorf("webhook %s: invalid type %q (use added, removed, time-changed or details-changed)", hook.URL, changeType)
+			}
+		}
+		if hook.Retries != nil && *hook.Retries < 0 {
+			return fmt.Errorf("webhook %s: invalid retries %d", hook.URL, *hook.Retries)
+		}
+		if _, err := hook.TimeoutDuration(); err != nil {
+			return fmt.Errorf("webhook %s: %w", hook.URL, err)
+		}
+	}
+	
+	if email := c.Email; email != nil {
+		if email.Host == "" || email.From == "" || len(email.To) == 0 {
+			return fmt.Errorf("email needs a host, from and to")
+		}
+		if !emailSecurity[email.Security] {
+			return fmt.Errorf("invalid email security %q (use starttls, tls or none)", email.Security)
+		}
+		if email.Output != "" && !outputNames[email.Output] {
+			return fmt.Errorf("email: unknown output %q", email.Output)
+		}
+		if email.Port < 0 || email.DigestDays < 0 {
+			return fmt.Errorf("email: invalid port %d or digestDays %d", email.Port, email.DigestDays)
+		}
+		if _, err := email.DigestClock(); err != nil {
+			return fmt.Errorf("email: %w", err)
+		}
+		if _, err := email.ChangeWindowDuration(); err != nil {
+			return fmt.Errorf("email: %w", err)
+		}
+	}
+	
+	if reminders := c.Reminders; reminders != nil {
+		if reminders.Output != "" && !outputNames[reminders.Output] {
+			return fmt.Errorf("reminders: unknown output %q", reminders.Output)
+		}
+		if _, err := reminders.Leads(); err != nil {
+			return fmt.Errorf("reminders: %w", err)
+		}
+		for _, source := range reminders.Sources {
+			if !calendarNames[source] {
+				return fmt.Errorf("reminders: unknown calendar %q", source)
+			}
+		}
+		if len(reminders.Channels) == 0 {
+			return fmt.Errorf("reminders need at least one channel")
+		}
+		for _, channel := range reminders.Channels {
+			switch channel.Type {
+			case "webhook", "ntfy":
+				if !strings.HasPrefix(channel.URL, "http://") && !strings.HasPrefix(channel.URL, "https://") {
+					return fmt.Errorf("reminders: the %s URL %q must start with http:// or https://", channel.Type, channel.URL)
+				}
+			case "email":
+				if c.Email == nil {
+					return fmt.Errorf("reminders: the email channel needs the email settings")
+				}
+			default:
+				return fmt.Errorf("reminders: invalid channel type %q (use webhook, ntfy or email)", channel.Type)
+			}
+			if channel.Priority < 0 || channel.Priority > 5 {
+				return fmt.Errorf("reminders: invalid priority %d (use 1 to 5)", channel.Priority)
+			}
+		}
+	}
+	
+	return nil
+}
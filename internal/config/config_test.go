@@ -0,0 +1,137 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestValidate tests that invalid settings are reported when the config is loaded
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		// err is part of the expected error, "" for a valid config
+		err string
+	}{
+		{"valid transforms", Config{Calendars: []Calendar{{Name: "School", Transforms: []Transform{
+			{Op: "replace", Property: "summary", Pattern: "^FW:"},
+			{Op: "set", Property: "LOCATION", Value: "Main building"},
+			{Op: "remove", Property: "Description"},
+			{Op: "move", From: "DESCRIPTION", To: "LOCATION"},
+			{Op: "normalize-all-day"},
+		}}}}, ""},
+		{"set a dropped property", Config{Calendars: []Calendar{{Name: "School", Transforms: []Transform{
+			{Op: "set", Property: "URL", Value: "https://example.com"},
+		}}}}, "transform #1: set: property URL is not part of merged events"},
+		{"move to a dropped property", Config{Calendars: []Calendar{{Name: "School", Transforms: []Transform{
+			{Op: "remove", Property: "LOCATION"},
+			{Op: "move", From: "DESCRIPTION", To: "COMMENT"},
+		}}}}, "transform #2: move: property COMMENT"},
+		{"output named like the default output", Config{OutputPath: "/app/output/merged.ics", Outputs: []Output{{Name: "merged"}}},
+			"outputs default and merged both write /app/output/merged.ics"},
+		{"outputs with the same path", Config{OutputPath: "/app/output/merged.ics", Outputs: []Output{
+			{Name: "kids", Path: "/srv/feeds/family.ics"},
+			{Name: "family", Path: "/srv/feeds/../feeds/family.ics"},
+		}}, "outputs kids and family both write /srv/feeds/family.ics"},
+		{"output path of another output", Config{OutputPath: "/app/output/merged.ics", Outputs: []Output{
+			{Name: "kids"},
+			{Name: "public", Path: "/app/output/kids.ics"},
+		}}, "outputs kids and public both write"},
+		{"status policies in any case", Config{Cancelled: "Drop", Tentative: " MARK", Outputs: []Output{{Name: "kids", Cancelled: "Keep"}}}, ""},
+		{"invalid status policy", Config{Tentative: "hide"}, `tentative: invalid status policy "hide"`},
+		{"invalid output status policy", Config{Outputs: []Output{{Name: "kids", Cancelled: "remove"}}}, `output kids: cancelled: invalid status policy "remove"`},
+		{"unknown transform", Config{Calendars: []Calendar{{Name: "School", Transforms: []Transform{
+			{Op: "rename", Property: "SUMMARY"},
+		}}}}, `transform #1: unknown operation "rename"`},
+		{"invalid transform pattern", Config{Calendars: []Calendar{{Name: "School", Transforms: []Transform{
+			{Op: "replace", Property: "SUMMARY", Pattern: "("},
+		}}}}, "transform #1: replace: invalid pattern"},
+		{"move without from", Config{Calendars: []Calendar{{Name: "School", Transforms: []Transform{
+			{Op: "move", To: "LOCATION"},
+		}}}}, "move: from and to are required"},
+		{"set without property", Config{Calendars: []Calendar{{Name: "School", Transforms: []Transform{
+			{Op: "set", Value: "x"},
+		}}}}, "set: property is required"},
+		{"invalid tolerance", Config{Calendars: []Calendar{{Name: "School", Transforms: []Transform{
+			{Op: "normalize-all-day", Tolerance: "a bit"},
+		}}}}, `normalize-all-day: invalid tolerance "a bit"`},
+		{"output of an unknown calendar", Config{Calendars: []Calendar{{Name: "Arthur"}}, Outputs: []Output{
+			{Name: "kids", Sources: []string{"Hannah"}},
+		}}, `output kids: unknown calendar "Hannah"`},
+		{"duplicate output", Config{Outputs: []Output{{Name: "kids"}, {Name: "kids"}}}, `duplicate output name "kids"`},
+		{"invalid output name", Config{Outputs: []Output{{Name: "../kids"}}}, "invalid output name"},
+		{"valid templates", Config{MultiSourceTemplate: "[{{.Initials}}] {{.Summary}}", Calendars: []Calendar{
+			{Name: "Arthur", SummaryTemplate: "{{index .Calendars 0}}: {{.Summary}}"},
+		}, Outputs: []Output{{Name: "kids", SummaryTemplate: "{{.Summary}}", MultiSourceTemplate: "{{index .Calendars 1}}: {{.Summary}}"}}}, ""},
+		{"template syntax", Config{Calendars: []Calendar{{Name: "Arthur", SummaryTemplate: "{{.Summary"}}}, "invalid summaryTemplate for calendar Arthur"},
+		{"template fails to render", Config{Calendars: []Calendar{{Name: "Arthur", SummaryTemplate: "{{.Title}}"}}}, "template fails to render"},
+		{"output template fails to render", Config{Outputs: []Output{{Name: "kids", MultiSourceTemplate: "{{.Calendar.Name}}"}}}, "output kids: invalid multiSourceTemplate"},
+		{"valid rules", Config{Calendars: []Calendar{{Name: "Work", Rules: &Rules{
+			Include: []Rule{{Weekdays: []string{"Mon", "friday"}, StartAfter: "08:00", StartBefore: "18:30"}},
+			Exclude: []Rule{{Summary: "(?i)^lunch", MinDuration: "5m", MaxDuration: "8h"}},
+		}}}}, ""},
+		{"invalid summary pattern", Config{Calendars: []Calendar{{Name: "Work", Rules: &Rules{
+			Exclude: []Rule{{Name: "lunch", Summary: "("}},
+		}}}}, "calendar Work: rule lunch: invalid summary pattern"},
+		{"invalid duration", Config{Calendars: []Calendar{{Name: "Work", Rules: &Rules{
+			Include: []Rule{{MinDuration: "ten minutes"}},
+		}}}}, "rule include #1: invalid minDuration"},
+		{"invalid weekday", Config{Outputs: []Output{{Name: "kids", Rules: &Rules{
+			Exclude: []Rule{{}, {Weekdays: []string{"Funday"}}},
+		}}}}, "output kids: rule exclude #2: invalid weekday"},
+		{"invalid time of day", Config{Outputs: []Output{{Name: "kids", Rules: &Rules{
+			Exclude: []Rule{{StartBefore: "25:00"}},
+		}}}}, "invalid startBefore"},
+		{"privacy in any case", Config{Privacy: "Title-Only", Calendars: []Calendar{{Name: "Work", Privacy: "Busy-Only"}}, Outputs: []Output{
+			{Name: "public", Privacy: " BUSY-ONLY "},
+		}}, ""},
+		{"invalid calendar privacy", Config{Calendars: []Calendar{{Name: "Work", Privacy: "hidden"}}}, `calendar Work: invalid privacy level "hidden"`},
+		{"invalid output privacy", Config{Outputs: []Output{{Name: "public", Privacy: "busy"}}}, `output public: invalid privacy level "busy"`},
+	}
+	for _, tt := range tests {
+		err := tt.cfg.validate()
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: expected an error with %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+// TestAllOutputs tests the default output and the defaults of named outputs
+func TestAllOutputs(t *testing.T) {
+	cfg := &Config{
+		OutputPath:     "/app/output/merged.ics",
+		OutputTimezone: "Europe/Berlin",
+		Privacy:        "title-only",
+		Outputs: []Output{
+			{Name: "kids", Sources: []string{"Hannah"}},
+			{Name: "public", Path: "/srv/public.ics", Timezone: "UTC"},
+		},
+	}
+	outputs := cfg.AllOutputs()
+	if len(outputs) != 3 {
+		t.Fatalf("Expected 3 outputs, got %d", len(outputs))
+	}
+	for i, want := range []Output{
+		{Path: "/app/output/merged.ics", Timezone: "Europe/Berlin", Privacy: "title-only"},
+		{Name: "kids", Path: "/app/output/kids.ics", Timezone: "Europe/Berlin"},
+		{Name: "public", Path: "/srv/public.ics", Timezone: "UTC"},
+	} {
+		got := outputs[i]
+		if got.Name != want.Name || got.Path != want.Path || got.Timezone != want.Timezone || got.Privacy != want.Privacy {
+			t.Errorf("Output %d: got %+v, want %+v", i, got, want)
+		}
+	}
+	if sources := cfg.SourcesOf(outputs[1]); len(sources) != 1 || sources[0] != "Hannah" {
+		t.Errorf("Unexpected sources of kids: %v", sources)
+	}
+
+	if output, ok := cfg.FindOutput("kids"); !ok || output.Path != "/app/output/kids.ics" {
+		t.Errorf("FindOutput(kids) = %+v, %v", output, ok)
+	}
+	if _, ok := cfg.FindOutput("unknown"); ok {
+		t.Error("Expected no output named unknown")
+	}
+}
@@ -0,0 +1,171 @@
+package conflict
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/config"
+	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arran4/golang-ical"
+)
+
+// DefaultDays is how far ahead conflicts are searched when not configured
+const DefaultDays = 30
+
+// Event is one side of a conflict
+type Event struct {
+	UID     string    `json:"uid"`
+	Summary string    `json:"summary"`
+	Source  string    `json:"source"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+}
+
+// Conflict is a pair of overlapping events, Start and End delimit the overlap
+type Conflict struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Events [2]Event  `json:"events"`
+}
+
+// Detector finds overlapping timed events across and within calendars
+type Detector struct {
+	pairs [][2]string
+	days  int
+	loc   *time.Location
+}
+
+// New creates a detector from the config. Floating times are interpreted in loc.
+func New(cfg *config.Conflicts, loc *time.Location) *Detector {
+	d := &Detector{days: DefaultDays, loc: loc}
+	if cfg == nil {
+		return d
+	}
+	if cfg.Days > 0 {
+		d.days = cfg.Days
+	}
+	for _, pair := range cfg.Pairs {
+		if len(pair) == 2 {
+			d.pairs = append(d.pairs, [2]string{pair[0], pair[1]})
+		}
+	}
+	return d
+}
+
+// conflicting reports whether events of the two calendars can conflict
+func (d *Detector) conflicting(a, b string) bool {
+	if len(d.pairs) == 0 {
+		return true
+	}
+	matches := func(pattern, name string) bool {
+		return pattern == "*" || pattern == name
+	}
+	for _, pair := range d.pairs {
+		if (matches(pair[0], a) && matches(pair[1], b)) || (matches(pair[0], b) && matches(pair[1], a)) {
+			return true
+		}
+	}
+	return false
+}
+
+// occurrence is an expanded event with the calendar it came from
+type occurrence struct {
+	ical.Occurrence
+	source string
+	event  Event
+}
+
+// Detect returns the conflicts between now and the configured number of days
+// ahead, ordered by the start of the overlap. Recurring events are expanded.
+// All-day, transparent and cancelled events never conflict, and the same
+// event found in two calendars is not a conflict with itself. Titles are
+// reported as far as privacy, the level of the outputs, allows.
+func (d *Detector) Detect(sources []ical.Source, privacy ical.PrivacyLevel, now time.Time) []Conflict {
+	from := now
+	to := now.AddDate(0, 0, d.days)
+
+	var occurrences []occurrence
+	for _, source := range sources {
+		for _, o := range ical.ExpandCalendar(source.Calendar, from, to, d.loc) {
+			if o.AllDay || strings.EqualFold(ical.PropertyValue(o.Event, ics.ComponentPropertyTransp), "TRANSPARENT") {
+				continue
+			}
+			occurrences = append(occurrences, occurrence{
+				Occurrence: o,
+				source:     source.Name,
+				event: Event{
+					UID:     ical.PropertyValue(o.Event, ics.ComponentPropertyUniqueId),
+					Summary: publishedSummary(o.Event, source, privacy),
+					Source:  source.Name,
+					Start:   o.Start,
+					End:     o.End,
+				},
+			})
+		}
+	}
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		return occurrences[i].Start.Before(occurrences[j].Start)
+	})
+
+	// Sweep over the sorted occurrences, only later ones starting before
+	// the end of an occurrence can overlap it
+	var conflicts []Conflict
+	for i, a := range occurrences {
+		for _, b := range occurrences[i+1:] {
+			if !b.Start.Before(a.End) {
+				break
+			}
+			if a.event.UID == b.event.UID && a.Start.Equal(b.Start) {
+				continue
+			}
+			if !d.conflicting(a.source, b.source) {
+				continue
+			}
+			end := a.End
+			if b.End.Before(end) {
+				end = b.End
+			}
+			conflicts = append(conflicts, Conflict{Start: b.Start, End: end, Events: [2]Event{a.event, b.event}})
+		}
+	}
+
+	sort.SliceStable(conflicts, func(i, j int) bool {
+		return conflicts[i].Start.Before(conflicts[j].Start)
+	})
+	return conflicts
+}
+
+// publishedSummary returns the title of an event as far as the privacy
+// levels of the calendar and the outputs allow
+func publishedSummary(event *ics.VEvent, source ical.Source, privacy ical.PrivacyLevel) string {
+	class := strings.ToUpper(strings.TrimSpace(ical.PropertyValue(event, ics.ComponentPropertyClass)))
+	if source.Privacy.Stricter(privacy) == ical.PrivacyBusyOnly || class == "PRIVATE" || class == "CONFIDENTIAL" {
+		return ical.BusySummary
+	}
+	return ical.PropertyValue(event, ics.ComponentPropertySummary)
+}
+
+// Calendar returns a calendar with one "Conflict" marker event per conflict,
+// with times written as floating times in loc
+func Calendar(conflicts []Conflict, loc *time.Location) *ics.Calendar {
+	cal := ics.NewCalendar()
+	cal.SetMethod(ics.MethodPublish)
+	cal.SetProductId("-//ical_merger//GO")
+
+	for _, c := range conflicts {
+		a, b := c.Events[0], c.Events[1]
+		uid := fmt.Sprintf("conflict-%s-%s-%s", a.UID, b.UID, c.Start.UTC().Format("20060102T150405Z"))
+
+		event := cal.AddEvent(uid)
+		event.SetProperty(ics.ComponentPropertySummary, fmt.Sprintf("Conflict: [%s] %s / [%s] %s", a.Source, a.Summary, b.Source, b.Summary))
+		event.SetProperty(ics.ComponentPropertyDtStart, c.Start.In(loc).Format("20060102T150405"))
+		event.SetProperty(ics.ComponentPropertyDtEnd, c.End.In(loc).Format("20060102T150405"))
+		event.SetProperty(ics.ComponentPropertyTransp, "TRANSPARENT")
+		event.SetProperty(ics.ComponentPropertyRelatedTo, a.UID)
+		event.AddProperty(ics.ComponentPropertyRelatedTo, b.UID)
+		event.AddProperty(ics.ComponentPropertyCategories, "Conflict")
+	}
+	return cal
+}
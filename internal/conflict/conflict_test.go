@@ -0,0 +1,101 @@
+package conflict
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/config"
+	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arran4/golang-ical"
+)
+
+// mustParse parses a test calendar or fails the test
+func mustParse(t *testing.T, data string) *ics.Calendar {
+	t.Helper()
+	cal, err := ics.ParseCalendar(strings.NewReader(strings.ReplaceAll(data, "\n", "\r\n")))
+	if err != nil {
+		t.Fatalf("Failed to parse test calendar: %v", err)
+	}
+	return cal
+}
+
+// TestDetect tests conflicts across calendars, double bookings, recurring
+// events and the pair rules
+func TestDetect(t *testing.T) {
+	arthur := mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:car-a
+SUMMARY:Car to work
+DTSTART:20250106T080000Z
+DTEND:20250106T170000Z
+RRULE:FREQ=WEEKLY;COUNT=2
+END:VEVENT
+BEGIN:VEVENT
+UID:meeting
+SUMMARY:Meeting
+DTSTART:20250106T090000Z
+DTEND:20250106T100000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:focus
+SUMMARY:Focus time
+TRANSP:TRANSPARENT
+DTSTART:20250106T090000Z
+DTEND:20250106T120000Z
+END:VEVENT
+END:VCALENDAR
+`)
+	hannah := mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:car-h
+SUMMARY:Car to the lake
+DTSTART:20250113T150000Z
+DTEND:20250113T190000Z
+END:VEVENT
+END:VCALENDAR
+`)
+	sources := []ical.Source{{Name: "Arthur", Calendar: arthur}, {Name: "Hannah", Calendar: hannah}}
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	describe := func(conflicts []Conflict) string {
+		var lines []string
+		for _, c := range conflicts {
+			lines = append(lines, c.Start.Format("01-02 15:04")+" "+c.Events[0].UID+"/"+c.Events[1].UID)
+		}
+		return strings.Join(lines, ",")
+	}
+
+	all := New(&config.Conflicts{}, time.UTC).Detect(sources, ical.PrivacyFull, now)
+	if got, want := describe(all), "01-06 09:00 car-a/meeting,01-13 15:00 car-a/car-h"; got != want {
+		t.Errorf("Unexpected conflicts: got %s, want %s", got, want)
+	}
+
+	acrossOnly := New(&config.Conflicts{Pairs: [][]string{{"Arthur", "Hannah"}}}, time.UTC).Detect(sources, ical.PrivacyFull, now)
+	if got, want := describe(acrossOnly), "01-13 15:00 car-a/car-h"; got != want {
+		t.Errorf("Unexpected conflicts for pair rule: got %s, want %s", got, want)
+	}
+	if end := acrossOnly[0].End.Format("15:04"); end != "17:00" {
+		t.Errorf("Overlap should end at 17:00, got %s", end)
+	}
+	if got := acrossOnly[0].Events[1].Summary; got != "Car to the lake" {
+		t.Errorf("Expected the title at full privacy, got %q", got)
+	}
+
+	// Busy-only outputs hide the titles of all calendars
+	busy := New(&config.Conflicts{}, time.UTC).Detect(sources, ical.PrivacyBusyOnly, now)
+	for _, c := range busy {
+		for _, event := range c.Events {
+			if event.Summary != ical.BusySummary {
+				t.Errorf("Expected %q for %s at busy-only privacy, got %q", ical.BusySummary, event.UID, event.Summary)
+			}
+		}
+	}
+	if len(busy) != len(all) {
+		t.Errorf("Privacy must not change the conflicts found, got %d, want %d", len(busy), len(all))
+	}
+}
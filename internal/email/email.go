@@ -0,0 +1,217 @@
+package email
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/agenda"
+	"github.com/arthur/ical_merger/internal/config"
+	"github.com/arthur/ical_merger/internal/history"
+	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arthur/ical_merger/internal/store"
+)
+
+// Notifier sends the daily agenda and the change emails of a feed
+type Notifier struct {
+	cfg    config.Email
+	output config.Output
+	loc    *time.Location
+	store  *store.Store
+
+	digestClock  int
+	changeWindow time.Duration
+
+	// deliver sends a complete message, replaced in tests
+	deliver func(msg []byte) error
+
+	mu sync.Mutex
+	// lastDigest is the date (2006-01-02) of the last agenda sent
+	lastDigest string
+}
+
+// New creates the notifier of the config, nil if email is not configured.
+// The agenda of today is not sent if its time has already passed.
+func New(cfg *config.Config, events *store.Store, now time.Time) *Notifier {
+	if cfg.Email == nil {
+		return nil
+	}
+	output, ok := cfg.FindOutput(cfg.Email.Output)
+	if !ok {
+		log.Printf("Email: unknown output %q, emails disabled", cfg.Email.Output)
+		return nil
+	}
+	loc, err := time.LoadLocation(output.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	n := &Notifier{cfg: *cfg.Email, output: output, loc: loc, store: events}
+	n.deliver = n.send
+	if n.digestClock, err = n.cfg.DigestClock(); err != nil {
+		log.Printf("Email: %v, agenda disabled", err)
+		n.cfg.DisableDigest = true
+	}
+	if n.changeWindow, err = n.cfg.ChangeWindowDuration(); err != nil {
+		log.Printf("Email: %v, change emails disabled", err)
+		n.cfg.DisableChanges = true
+	}
+
+	local := now.In(loc)
+	if local.Hour()*60+local.Minute() >= n.digestClock {
+		n.lastDigest = local.Format("2006-01-02")
+	}
+	return n
+}
+
+// Tick sends the daily agenda once its time has come. It is called
+// periodically by the main loop.
+func (n *Notifier) Tick(now time.Time) {
+	if n == nil || n.cfg.DisableDigest {
+		return
+	}
+	local := now.In(n.loc)
+	date := local.Format("2006-01-02")
+
+	n.mu.Lock()
+	due := date != n.lastDigest && local.Hour()*60+local.Minute() >= n.digestClock
+	if due {
+		n.lastDigest = date
+	}
+	n.mu.Unlock()
+
+	if due {
+		go func() {
+			if err := n.SendDigest(now); err != nil {
+				log.Printf("Email: error sending the agenda: %v", err)
+			}
+		}()
+	}
+}
+
+// SendDigest sends the agenda of the days starting with the day of now
+func (n *Notifier) SendDigest(now time.Time) error {
+	feed, err := n.store.Lookup(n.output)
+	if err != nil {
+		return err
+	}
+
+	local := now.In(n.loc)
+	from := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, n.loc)
+	days := n.cfg.DigestDays
+	if days <= 0 {
+		days = 1
+	}
+	to := from.AddDate(0, 0, days)
+
+	// The info event written for empty feeds is not part of the agenda
+	var events []agenda.Event
+	for _, event := range agenda.FromOccurrences(feed.Occurrences(store.Query{From: from, To: to}), local, n.loc) {
+		if !ical.IsInfoEvent(event.UID) {
+			events = append(events, event)
+		}
+	}
+
+	subject := "Agenda for " + local.Format("Monday, Jan 2")
+	if days > 1 {
+		subject = fmt.Sprintf("Agenda for %s to %s", local.Format("Jan 2"), to.AddDate(0, 0, -1).Format("Jan 2"))
+	}
+	data := digestData{Subject: subject, Days: agenda.Days(events, local), Count: len(events)}
+
+	log.Printf("Email: sending the agenda with %d events to %v", len(events), n.cfg.To)
+	return n.render(subject, "digest", data, now)
+}
+
+// NotifyChanges emails the changes to events starting within the change
+// window in the background. All changes of a merge go into one email.
+func (n *Notifier) NotifyChanges(changes []history.Change, now time.Time) {
+	if n == nil || n.cfg.DisableChanges {
+		return
+	}
+
+	var upcoming []changeView
+	for _, change := range changes {
+		if change.Output != n.output.Name {
+			continue
+		}
+		// A moved event matters if it was or is now coming up
+		relevant := false
+		for _, event := range []*history.Event{change.Before, change.After} {
+			if event != nil && event.End.After(now) && event.Start.Before(now.Add(n.changeWindow)) {
+				relevant = true
+			}
+		}
+		if relevant {
+			upcoming = append(upcoming, n.viewChange(change))
+		}
+	}
+	if len(upcoming) == 0 {
+		return
+	}
+
+	subject := fmt.Sprintf("Calendar update: %s", upcoming[0].Summary)
+	if len(upcoming) > 1 {
+		subject = fmt.Sprintf("Calendar update: %d upcoming events changed", len(upcoming))
+	}
+	go func() {
+		log.Printf("Email: sending %d upcoming changes to %v", len(upcoming), n.cfg.To)
+		if err := n.render(subject, "changes", changesData{Subject: subject, Changes: upcoming}, now); err != nil {
+			log.Printf("Email: error sending the change email: %v", err)
+		}
+	}()
+}
+
+// SendReminder emails a reminder of an event, when describes its start
+// like "in 15 minutes, at 3:00 PM"
+func (n *Notifier) SendReminder(summary, when, location string, now time.Time) error {
+	if n == nil {
+		return fmt.Errorf("email is not configured")
+	}
+	subject := "Reminder: " + summary
+	return n.render(subject, "reminder", reminderData{Subject: subject, Summary: summary, When: when, Location: location}, now)
+}
+
+// changeView is a change as shown in the change email
+type changeView struct {
+	Type    string
+	Summary string
+	Before  string
+	After   string
+	Fields  []string
+}
+
+// viewChange formats a change for the templates
+func (n *Notifier) viewChange(change history.Change) changeView {
+	view := changeView{Summary: change.Event().Summary, Fields: change.Fields}
+	switch change.Type {
+	case history.Added:
+		view.Type = "New"
+	case history.Removed:
+		view.Type = "Removed"
+	case history.TimeChanged:
+		view.Type = "Rescheduled"
+	default:
+		view.Type = "Updated"
+	}
+	// The old time is only worth showing if it changed
+	if change.Before != nil && (change.After == nil || change.Type == history.TimeChanged) {
+		view.Before = n.formatWhen(change.Before)
+	}
+	if change.After != nil {
+		view.After = n.formatWhen(change.After)
+	}
+	return view
+}
+
+// formatWhen formats the time of an event like the agenda does
+func (n *Notifier) formatWhen(event *history.Event) string {
+	start, end := event.Start.In(n.loc), event.End.In(n.loc)
+	if event.AllDay {
+		return start.Format("Monday, Jan 2") + " (all day)"
+	}
+	if start.Format("2006-01-02") == end.Format("2006-01-02") {
+		return start.Format("Monday, Jan 2 3:04 PM") + " - " + end.Format("3:04 PM")
+	}
+	return start.Format("Monday, Jan 2 3:04 PM") + " - " + end.Format("Monday, Jan 2 3:04 PM")
+}
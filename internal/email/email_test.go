@@ -0,0 +1,127 @@
+package email
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/config"
+	"github.com/arthur/ical_merger/internal/history"
+	"github.com/arthur/ical_merger/internal/store"
+)
+
+// readMessage parses a sent message into its subject and its plain-text and HTML bodies
+func readMessage(t *testing.T, msg []byte) (subject string, bodies map[string]string) {
+	t.Helper()
+	parsed, err := mail.ReadMessage(strings.NewReader(string(msg)))
+	if err != nil {
+		t.Fatalf("Invalid message: %v", err)
+	}
+	subject, _ = new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	_, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("Invalid content type: %v", err)
+	}
+
+	bodies = make(map[string]string)
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Invalid part: %v", err)
+		}
+		content, _ := io.ReadAll(part)
+		mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		bodies[mediaType] = string(content)
+	}
+	return subject, bodies
+}
+
+// TestEmails tests the agenda, which expands recurring events, and the change
+// email, which only covers events coming up within the change window
+func TestEmails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "merged.ics")
+	if err := os.WriteFile(path, []byte(strings.ReplaceAll(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:standup
+SUMMARY:[Arthur] Standup
+DTSTART:20250106T090000Z
+DTEND:20250106T093000Z
+RRULE:FREQ=DAILY
+END:VEVENT
+BEGIN:VEVENT
+UID:school
+SUMMARY:[Hannah] Parent <evening>
+LOCATION:School
+DTSTART:20250108T190000Z
+DTEND:20250108T210000Z
+END:VEVENT
+END:VCALENDAR
+`, "\n", "\r\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		OutputPath:     path,
+		OutputTimezone: "UTC",
+		Email:          &config.Email{Host: "localhost", From: "Calendar <calendar@example.com>", To: []string{"family@example.com"}},
+	}
+	now := time.Date(2025, 1, 8, 6, 0, 0, 0, time.UTC)
+	events := store.New()
+	events.Load(cfg.AllOutputs())
+	n := New(cfg, events, now)
+	sent := make(chan []byte, 1)
+	n.deliver = func(msg []byte) error {
+		sent <- msg
+		return nil
+	}
+
+	if err := n.SendDigest(now); err != nil {
+		t.Fatalf("Failed to send the agenda: %v", err)
+	}
+	subject, bodies := readMessage(t, <-sent)
+	if subject != "Agenda for Wednesday, Jan 8" {
+		t.Errorf("Unexpected agenda subject %q", subject)
+	}
+	if text := bodies["text/plain"]; !strings.Contains(text, "9:00 AM - 9:30 AM  [Arthur] Standup") ||
+		!strings.Contains(text, "7:00 PM - 9:00 PM  [Hannah] Parent <evening> (School)") {
+		t.Errorf("Unexpected agenda text:\n%s", text)
+	}
+	if html := bodies["text/html"]; !strings.Contains(html, "Parent &lt;evening&gt;") {
+		t.Errorf("Agenda HTML is not escaped:\n%s", html)
+	}
+
+	evening := time.Date(2025, 1, 8, 19, 0, 0, 0, time.UTC)
+	n.NotifyChanges([]history.Change{
+		{Type: history.TimeChanged, UID: "school",
+			Before: &history.Event{Summary: "[Hannah] Parent evening", Start: evening, End: evening.Add(2 * time.Hour)},
+			After:  &history.Event{Summary: "[Hannah] Parent evening", Start: evening.Add(24 * time.Hour), End: evening.Add(26 * time.Hour)}},
+		{Type: history.Added, UID: "trip", After: &history.Event{Summary: "Trip", Start: evening.AddDate(0, 1, 0), End: evening.AddDate(0, 1, 1)}},
+		{Type: history.Added, UID: "other", Output: "kids", After: &history.Event{Summary: "Other", Start: evening, End: evening.Add(time.Hour)}},
+	}, now)
+
+	select {
+	case msg := <-sent:
+		subject, bodies := readMessage(t, msg)
+		if subject != "Calendar update: [Hannah] Parent evening" {
+			t.Errorf("Unexpected change subject %q", subject)
+		}
+		if text := bodies["text/plain"]; !strings.Contains(text, "was: Wednesday, Jan 8 7:00 PM - 9:00 PM") ||
+			!strings.Contains(text, "now: Thursday, Jan 9 7:00 PM - 9:00 PM") || strings.Contains(text, "Trip") {
+			t.Errorf("Unexpected change text:\n%s", text)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No change email sent")
+	}
+}
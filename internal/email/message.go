@@ -0,0 +1,233 @@
+package email
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/agenda"
+)
+
+// digestData is passed to the agenda templates
+type digestData struct {
+	Subject string
+	Days    []agenda.Day
+	Count   int
+}
+
+// changesData is passed to the change templates
+type changesData struct {
+	Subject string
+	Changes []changeView
+}
+
+// reminderData is passed to the reminder templates
+type reminderData struct {
+	Subject  string
+	Summary  string
+	When     string
+	Location string
+}
+
+// textTemplates are the plain-text bodies
+var textTemplates = texttemplate.Must(texttemplate.New("text").Parse(`{{define "digest"}}{{.Subject}}
+{{if not .Days}}
+Nothing scheduled.
+{{end}}{{range .Days}}
+{{.DateFmt}}
+{{range .Events}}  {{if .AllDay}}All day{{else}}{{.StartStr}} - {{.EndStr}}{{end}}  {{.Summary}}{{if .Location}} ({{.Location}}){{end}}{{if .Cancelled}} [cancelled]{{end}}
+{{end}}{{end}}{{end}}
+
+{{- define "changes"}}{{.Subject}}
+{{range .Changes}}
+{{.Type}}: {{.Summary}}
+{{if .Before}}  was: {{.Before}}
+{{end}}{{if .After}}  now: {{.After}}
+{{end}}{{if .Fields}}  changed: {{range $i, $f := .Fields}}{{if $i}}, {{end}}{{$f}}{{end}}
+{{end}}{{end}}{{end}}
+
+{{- define "reminder"}}{{.Summary}}
+
+{{.When}}{{if .Location}}
+{{.Location}}{{end}}
+{{end}}`))
+
+// htmlTemplates are the HTML bodies, the events get escaped
+var htmlTemplates = htmltemplate.Must(htmltemplate.New("html").Parse(`{{define "digest"}}<!DOCTYPE html>
+<html><body style="font-family: sans-serif">
+<h2>{{.Subject}}</h2>
+{{if not .Days}}<p>Nothing scheduled.</p>{{end}}
+{{range .Days}}<h3>{{.DateFmt}}</h3>
+<table cellpadding="4">
+{{range .Events}}<tr>
+<td style="white-space: nowrap; vertical-align: top">{{if .AllDay}}All day{{else}}{{.StartStr}} - {{.EndStr}}{{end}}</td>
+<td{{if .Cancelled}} style="text-decoration: line-through"{{end}}>{{if .Color}}<span style="color: {{.Color}}">&#9632;</span> {{end}}<strong>{{.Summary}}</strong>{{if .Location}}<br><small>{{.Location}}</small>{{end}}</td>
+</tr>
+{{end}}</table>
+{{end}}</body></html>
+{{end}}
+
+{{- define "changes"}}<!DOCTYPE html>
+<html><body style="font-family: sans-serif">
+<h2>{{.Subject}}</h2>
+{{range .Changes}}<p><strong>{{.Type}}: {{.Summary}}</strong><br>
+{{if .Before}}{{if .After}}<span style="text-decoration: line-through">{{.Before}}</span>{{else}}{{.Before}}{{end}}<br>{{end}}
+{{if .After}}{{.After}}<br>{{end}}
+{{if .Fields}}<small>Changed: {{range $i, $f := .Fields}}{{if $i}}, {{end}}{{$f}}{{end}}</small>{{end}}</p>
+{{end}}</body></html>
+{{end}}
+
+{{- define "reminder"}}<!DOCTYPE html>
+<html><body style="font-family: sans-serif">
+<h2>{{.Summary}}</h2>
+<p>{{.When}}{{if .Location}}<br><small>{{.Location}}</small>{{end}}</p>
+</body></html>
+{{end}}`))
+
+// render fills the plain-text and HTML templates of a kind and sends the email
+func (n *Notifier) render(subject, kind string, data interface{}, now time.Time) error {
+	var text, html bytes.Buffer
+	if err := textTemplates.ExecuteTemplate(&text, kind, data); err != nil {
+		return err
+	}
+	if err := htmlTemplates.ExecuteTemplate(&html, kind, data); err != nil {
+		return err
+	}
+	msg, err := buildMessage(n.cfg.From, n.cfg.To, subject, text.String(), html.String(), now)
+	if err != nil {
+		return err
+	}
+	return n.deliver(msg)
+}
+
+// buildMessage builds a multipart/alternative email with a plain-text and
+// an HTML body
+func buildMessage(from string, to []string, subject, text, html string, now time.Time) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	domain := "ical-merger"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = strings.Trim(from[at+1:], "> ")
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%d.ical-merger@%s>\r\n", now.UnixNano(), domain)
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", parts.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// send delivers a message over SMTP with the configured security and login
+func (n *Notifier) send(msg []byte) error {
+	port := n.cfg.Port
+	if port == 0 {
+		port = 587
+		if n.cfg.Security == "tls" {
+			port = 465
+		}
+	}
+	addr := net.JoinHostPort(n.cfg.Host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: n.cfg.Host}
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+
+	var conn net.Conn
+	var err error
+	if n.cfg.Security == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Minute))
+
+	client, err := smtp.NewClient(conn, n.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	// STARTTLS is required unless security is explicitly "none"
+	if n.cfg.Security == "" || n.cfg.Security == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not support STARTTLS (set security to \"none\" for local servers)", addr)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if n.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, n.cfg.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(addressOf(n.cfg.From)); err != nil {
+		return err
+	}
+	for _, to := range n.cfg.To {
+		if err := client.Rcpt(addressOf(to)); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// addressOf returns the bare address of "Name <address>"
+func addressOf(value string) string {
+	if start, end := strings.LastIndex(value, "<"), strings.LastIndex(value, ">"); start >= 0 && end > start {
+		return value[start+1 : end]
+	}
+	return strings.TrimSpace(value)
+}
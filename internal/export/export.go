@@ -0,0 +1,130 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arran4/golang-ical"
+)
+
+// Columns are the columns of the CSV export, in their default order
+var Columns = []string{"source", "summary", "start", "end", "duration", "location", "categories", "status"}
+
+// ParseColumns reads a comma-separated list of columns, all columns if
+// value is empty
+func ParseColumns(value string) ([]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return Columns, nil
+	}
+	var columns []string
+	for _, column := range strings.Split(value, ",") {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if column == "" {
+			continue
+		}
+		known := false
+		for _, c := range Columns {
+			known = known || c == column
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown column %q (use %s)", column, strings.Join(Columns, ", "))
+		}
+		columns = append(columns, column)
+	}
+	if len(columns) == 0 {
+		return Columns, nil
+	}
+	return columns, nil
+}
+
+// WriteCSV writes one row per occurrence with a header row. Times are
+// converted to loc. All-day events have dates only, with the last day as
+// the end, and no duration.
+func WriteCSV(w io.Writer, occurrences []ical.Occurrence, columns []string, loc *time.Location) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+	for _, o := range occurrences {
+		// The info event of empty calendars is not a real event
+		if ical.IsInfoEvent(ical.PropertyValue(o.Event, ics.ComponentPropertyUniqueId)) {
+			continue
+		}
+		row := make([]string, len(columns))
+		for i, column := range columns {
+			row[i] = field(o, column, loc)
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// field returns the value of a column for an occurrence
+func field(o ical.Occurrence, column string, loc *time.Location) string {
+	switch column {
+	case "source":
+		var sources []string
+		for _, prop := range o.Event.GetProperties(ical.PropertySource) {
+			sources = append(sources, prop.Value)
+		}
+		return text(strings.Join(sources, ", "))
+	case "summary":
+		return text(ical.PropertyValue(o.Event, ics.ComponentPropertySummary))
+	case "start":
+		if o.AllDay {
+			return o.Start.Format("2006-01-02")
+		}
+		return o.Start.In(loc).Format("2006-01-02 15:04")
+	case "end":
+		if o.AllDay {
+			// The end of all-day events is exclusive, show the last day
+			end := o.End.AddDate(0, 0, -1)
+			if end.Before(o.Start) {
+				end = o.Start
+			}
+			return end.Format("2006-01-02")
+		}
+		return o.End.In(loc).Format("2006-01-02 15:04")
+	case "duration":
+		if o.AllDay {
+			return ""
+		}
+		// Hours and minutes, which spreadsheets can add up
+		minutes := int(o.End.Sub(o.Start).Round(time.Minute).Minutes())
+		return fmt.Sprintf("%d:%02d", minutes/60, minutes%60)
+	case "location":
+		return text(ical.PropertyValue(o.Event, ics.ComponentPropertyLocation))
+	case "categories":
+		var categories []string
+		seen := make(map[string]bool)
+		for _, prop := range o.Event.GetProperties(ics.ComponentPropertyCategories) {
+			for _, category := range strings.Split(prop.Value, ",") {
+				category = strings.TrimSpace(category)
+				if category != "" && !seen[strings.ToLower(category)] {
+					seen[strings.ToLower(category)] = true
+					categories = append(categories, category)
+				}
+			}
+		}
+		return text(strings.Join(categories, ", "))
+	case "status":
+		return strings.ToLower(ical.PropertyValue(o.Event, ics.ComponentPropertyStatus))
+	}
+	return ""
+}
+
+// text guards free text from the calendars against being run as a formula
+// when the file is opened in a spreadsheet
+func text(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
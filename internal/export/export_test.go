@@ -0,0 +1,86 @@
+package export
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arran4/golang-ical"
+)
+
+// TestWriteCSV tests the rows of timed, all-day and recurring events, the
+// timezone conversion and the choice of columns
+func TestWriteCSV(t *testing.T) {
+	cal, err := ics.ParseCalendar(strings.NewReader(strings.ReplaceAll(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:standup
+SUMMARY:[Arthur] Standup
+LOCATION:Office, 2nd floor
+CATEGORIES:Work,Meetings
+X-ICALMERGER-SOURCE:Arthur
+DTSTART:20250106T080000Z
+DTEND:20250106T081500Z
+RRULE:FREQ=DAILY;COUNT=2
+END:VEVENT
+BEGIN:VEVENT
+UID:dinner
+SUMMARY:=HYPERLINK("x")
+STATUS:TENTATIVE
+X-ICALMERGER-SOURCE:Arthur
+X-ICALMERGER-SOURCE:Hannah
+DTSTART:20250107T180000Z
+DTEND:20250107T193000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:trip
+SUMMARY:[Hannah] Trip
+X-ICALMERGER-SOURCE:Hannah
+DTSTART;VALUE=DATE:20250108
+DTEND;VALUE=DATE:20250110
+END:VEVENT
+END:VCALENDAR
+`, "\n", "\r\n")))
+	if err != nil {
+		t.Fatalf("Failed to parse test calendar: %v", err)
+	}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("Timezone data not available: %v", err)
+	}
+	// The info event of empty calendars is left out
+	cal.AddVEvent(ical.NewInfoEvent(time.Date(2025, 1, 12, 12, 0, 0, 0, time.UTC)))
+	from := time.Date(2025, 1, 6, 0, 0, 0, 0, berlin)
+	occurrences := ical.ExpandCalendar(cal, from, from.AddDate(0, 0, 7), berlin)
+
+	var b strings.Builder
+	if err := WriteCSV(&b, occurrences, Columns, berlin); err != nil {
+		t.Fatal(err)
+	}
+	want := `source,summary,start,end,duration,location,categories,status
+Arthur,[Arthur] Standup,2025-01-06 09:00,2025-01-06 09:15,0:15,"Office, 2nd floor","Work, Meetings",
+Arthur,[Arthur] Standup,2025-01-07 09:00,2025-01-07 09:15,0:15,"Office, 2nd floor","Work, Meetings",
+"Arthur, Hannah","'=HYPERLINK(""x"")",2025-01-07 19:00,2025-01-07 20:30,1:30,,,tentative
+Hannah,[Hannah] Trip,2025-01-08,2025-01-09,,,,
+`
+	if got := b.String(); got != want {
+		t.Errorf("Unexpected CSV:\n%s\nwant:\n%s", got, want)
+	}
+
+	columns, err := ParseColumns(" Summary, duration ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	if err := WriteCSV(&b, occurrences[3:], columns, berlin); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != "summary,duration\n[Hannah] Trip,\n" {
+		t.Errorf("Unexpected CSV with selected columns:\n%s", got)
+	}
+	if _, err := ParseColumns("summary,attendees"); err == nil {
+		t.Error("Expected an error for an unknown column")
+	}
+}
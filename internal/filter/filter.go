@@ -0,0 +1,247 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/config"
+	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arran4/golang-ical"
+)
+
+// Filter drops events from a calendar according to include/exclude rules
+type Filter struct {
+	include []*rule
+	exclude []*rule
+	loc     *time.Location
+}
+
+// rule is the compiled form of a config.Rule
+type rule struct {
+	name        string
+	summary     *regexp.Regexp
+	categories  map[string]bool
+	status      map[string]bool
+	transp      map[string]bool
+	class       map[string]bool
+	minDuration time.Duration
+	maxDuration time.Duration
+	weekdays    map[time.Weekday]bool
+	startAfter  int // minutes after midnight, -1 if unset
+	startBefore int // minutes after midnight, -1 if unset
+	allDay      *bool
+}
+
+// New compiles the rules of a calendar. Weekdays and times of day are
+// evaluated in loc. A nil rules block yields a filter that keeps everything.
+func New(rules *config.Rules, loc *time.Location) (*Filter, error) {
+	f := &Filter{loc: loc}
+	if rules == nil {
+		return f, nil
+	}
+
+	for i, r := range rules.Include {
+		compiled, err := compileRule(r, fmt.Sprintf("include #%d", i+1))
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, compiled)
+	}
+	for i, r := range rules.Exclude {
+		compiled, err := compileRule(r, fmt.Sprintf("exclude #%d", i+1))
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, compiled)
+	}
+
+	return f, nil
+}
+
+// Apply removes the events that don't pass the rules from cal and returns
+// the number of dropped events per reason
+func (f *Filter) Apply(cal *ics.Calendar) map[string]int {
+	dropped := make(map[string]int)
+	if len(f.include) == 0 && len(f.exclude) == 0 {
+		return dropped
+	}
+
+	kept := cal.Components[:0]
+	for _, component := range cal.Components {
+		event, ok := component.(*ics.VEvent)
+		if !ok {
+			kept = append(kept, component)
+			continue
+		}
+
+		if reason := f.dropReason(event); reason != "" {
+			dropped[reason]++
+			continue
+		}
+		kept = append(kept, component)
+	}
+	cal.Components = kept
+
+	return dropped
+}
+
+// dropReason returns why an event should be dropped, or "" to keep it
+func (f *Filter) dropReason(event *ics.VEvent) string {
+	if len(f.include) > 0 {
+		included := false
+		for _, r := range f.include {
+			if r.matches(event, f.loc) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return "not included"
+		}
+	}
+
+	for _, r := range f.exclude {
+		if r.matches(event, f.loc) {
+			return r.name
+		}
+	}
+
+	return ""
+}
+
+// compileRule validates a config rule and prepares it for matching
+func compileRule(r config.Rule, defaultName string) (*rule, error) {
+	compiled := &rule{
+		name:       r.Name,
+		categories: lowerSet(r.Categories),
+		status:     upperSet(r.Status),
+		transp:     upperSet(r.Transp),
+		class:      upperSet(r.Class),
+		allDay:     r.AllDay,
+	}
+	if compiled.name == "" {
+		compiled.name = defaultName
+	}
+
+	var err error
+	if compiled.summary, err = r.SummaryRegexp(); err != nil {
+		return nil, fmt.Errorf("rule %s: %w", compiled.name, err)
+	}
+	if compiled.minDuration, compiled.maxDuration, err = r.Durations(); err != nil {
+		return nil, fmt.Errorf("rule %s: %w", compiled.name, err)
+	}
+	if compiled.startAfter, compiled.startBefore, err = r.StartTimes(); err != nil {
+		return nil, fmt.Errorf("rule %s: %w", compiled.name, err)
+	}
+	if compiled.weekdays, err = r.Days(); err != nil {
+		return nil, fmt.Errorf("rule %s: %w", compiled.name, err)
+	}
+
+	return compiled, nil
+}
+
+// matches reports whether all conditions of the rule hold for the event
+func (r *rule) matches(event *ics.VEvent, loc *time.Location) bool {
+	if r.summary != nil {
+		summary := ""
+		if prop := event.GetProperty(ics.ComponentPropertySummary); prop != nil {
+			summary = prop.Value
+		}
+		if !r.summary.MatchString(summary) {
+			return false
+		}
+	}
+
+	if len(r.categories) > 0 && !hasCategory(event, r.categories) {
+		return false
+	}
+	if len(r.status) > 0 && !r.status[upperValue(event, ics.ComponentPropertyStatus)] {
+		return false
+	}
+	if len(r.transp) > 0 && !r.transp[upperValue(event, ics.ComponentPropertyTransp)] {
+		return false
+	}
+	if len(r.class) > 0 && !r.class[upperValue(event, ics.ComponentPropertyClass)] {
+		return false
+	}
+
+	// Only evaluate times when the rule needs them
+	if r.minDuration == 0 && r.maxDuration == 0 && r.weekdays == nil &&
+		r.startAfter < 0 && r.startBefore < 0 && r.allDay == nil {
+		return true
+	}
+
+	start, end, allDay, err := ical.EventTimes(event, loc)
+	if err != nil {
+		return false
+	}
+	if !allDay {
+		start = start.In(loc)
+	}
+
+	if r.allDay != nil && *r.allDay != allDay {
+		return false
+	}
+	if r.minDuration > 0 && end.Sub(start) < r.minDuration {
+		return false
+	}
+	if r.maxDuration > 0 && end.Sub(start) > r.maxDuration {
+		return false
+	}
+	if r.weekdays != nil && !r.weekdays[start.Weekday()] {
+		return false
+	}
+	if r.startAfter >= 0 || r.startBefore >= 0 {
+		// Time of day conditions never match all-day events
+		if allDay {
+			return false
+		}
+		minutes := start.Hour()*60 + start.Minute()
+		if r.startAfter >= 0 && minutes < r.startAfter {
+			return false
+		}
+		if r.startBefore >= 0 && minutes >= r.startBefore {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasCategory reports whether the event has any of the given (lowercased) categories
+func hasCategory(event *ics.VEvent, categories map[string]bool) bool {
+	for _, prop := range event.GetProperties(ics.ComponentPropertyCategories) {
+		for _, category := range strings.Split(prop.Value, ",") {
+			if categories[strings.ToLower(strings.TrimSpace(category))] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upperValue returns the uppercased value of a property, or "" if missing
+func upperValue(event *ics.VEvent, prop ics.ComponentProperty) string {
+	if p := event.GetProperty(prop); p != nil {
+		return strings.ToUpper(strings.TrimSpace(p.Value))
+	}
+	return ""
+}
+
+func lowerSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[strings.ToLower(strings.TrimSpace(v))] = true
+	}
+	return set
+}
+
+func upperSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[strings.ToUpper(strings.TrimSpace(v))] = true
+	}
+	return set
+}
@@ -0,0 +1,89 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/config"
+	"github.com/arran4/golang-ical"
+)
+
+const testCalendar = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//ical_merger//TEST//EN
+BEGIN:VEVENT
+UID:busy
+SUMMARY:Busy
+DTSTART:20250106T090000Z
+DTEND:20250106T100000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:cancelled
+SUMMARY:Team sync
+STATUS:CANCELLED
+DTSTART:20250106T110000Z
+DTEND:20250106T113000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:weekend
+SUMMARY:Hike
+DTSTART;TZID=Europe/Berlin:20250111T080000
+DTEND;TZID=Europe/Berlin:20250111T160000
+END:VEVENT
+BEGIN:VEVENT
+UID:evening
+SUMMARY:Concert
+DTSTART;TZID=Europe/Berlin:20250107T200000
+DTEND;TZID=Europe/Berlin:20250107T220000
+END:VEVENT
+END:VCALENDAR
+`
+
+// TestFilterApply tests include and exclude rules and the drop counts
+func TestFilterApply(t *testing.T) {
+	cal, err := ics.ParseCalendar(strings.NewReader(strings.ReplaceAll(testCalendar, "\n", "\r\n")))
+	if err != nil {
+		t.Fatalf("Failed to parse test calendar: %v", err)
+	}
+
+	loc, _ := time.LoadLocation("Europe/Berlin")
+	f, err := New(&config.Rules{
+		Include: []config.Rule{{Weekdays: []string{"Mon", "Tue", "Wed", "Thu", "Fri"}}},
+		Exclude: []config.Rule{
+			{Name: "placeholders", Summary: "^Busy$"},
+			{Status: []string{"cancelled"}},
+			{StartAfter: "19:00"},
+		},
+	}, loc)
+	if err != nil {
+		t.Fatalf("Failed to compile rules: %v", err)
+	}
+
+	dropped := f.Apply(cal)
+
+	if n := len(cal.Events()); n != 0 {
+		t.Errorf("Expected all events to be dropped, %d left", n)
+	}
+	expected := map[string]int{"placeholders": 1, "exclude #2": 1, "exclude #3": 1, "not included": 1}
+	for reason, n := range expected {
+		if dropped[reason] != n {
+			t.Errorf("Expected %d events dropped by %q, got %d", n, reason, dropped[reason])
+		}
+	}
+}
+
+// TestNewRejectsInvalidRules tests that broken rules are reported
+func TestNewRejectsInvalidRules(t *testing.T) {
+	invalid := []config.Rule{
+		{Summary: "("},
+		{MinDuration: "ten minutes"},
+		{Weekdays: []string{"Funday"}},
+		{StartBefore: "25:00"},
+	}
+	for _, r := range invalid {
+		if _, err := New(&config.Rules{Exclude: []config.Rule{r}}, time.UTC); err == nil {
+			t.Errorf("Expected an error for rule %+v", r)
+		}
+	}
+}
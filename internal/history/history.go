@@ -0,0 +1,221 @@
+package history
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arran4/golang-ical"
+)
+
+// DefaultSize is the number of changes kept when no size is configured
+const DefaultSize = 1000
+
+// Change types
+const (
+	Added          = "added"
+	Removed        = "removed"
+	TimeChanged    = "time-changed"
+	DetailsChanged = "details-changed"
+)
+
+// Event is the state of an event before or after a change
+type Event struct {
+	Summary     string    `json:"summary"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	AllDay      bool      `json:"all_day"`
+	Location    string    `json:"location,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	Sources     []string  `json:"sources,omitempty"`
+}
+
+// Change is a difference between two merged calendars
+type Change struct {
+	Type         string    `json:"type"`
+	Output       string    `json:"output"`
+	UID          string    `json:"uid"`
+	RecurrenceID string    `json:"recurrence_id,omitempty"`
+	DetectedAt   time.Time `json:"detected_at"`
+	// Fields lists the changed properties of time-changed and details-changed events
+	Fields []string `json:"fields,omitempty"`
+	Before *Event   `json:"before,omitempty"`
+	After  *Event   `json:"after,omitempty"`
+}
+
+// Event returns the current state of the changed event, or the last one for removed events
+func (c *Change) Event() *Event {
+	if c.After != nil {
+		return c.After
+	}
+	return c.Before
+}
+
+// Diff compares two merged calendars by UID and RECURRENCE-ID. Times are
+// compared as instants, floating times are interpreted in loc. The changes
+// are ordered by event start.
+func Diff(before, after *ics.Calendar, loc *time.Location) []Change {
+	old := snapshot(before, loc)
+	current := snapshot(after, loc)
+	now := time.Now()
+
+	var changes []Change
+	for key, event := range current {
+		previous, ok := old[key]
+		if !ok {
+			changes = append(changes, Change{Type: Added, UID: key.uid, RecurrenceID: key.recurrenceID, DetectedAt: now, After: event})
+			continue
+		}
+
+		var timeFields, detailFields []string
+		if !previous.Start.Equal(event.Start) {
+			timeFields = append(timeFields, "start")
+		}
+		if !previous.End.Equal(event.End) {
+			timeFields = append(timeFields, "end")
+		}
+		if previous.AllDay != event.AllDay {
+			timeFields = append(timeFields, "all_day")
+		}
+		for field, values := range map[string][2]string{
+			"summary":     {previous.Summary, event.Summary},
+			"location":    {previous.Location, event.Location},
+			"description": {previous.Description, event.Description},
+			"status":      {previous.Status, event.Status},
+			"sources":     {strings.Join(previous.Sources, ","), strings.Join(event.Sources, ",")},
+		} {
+			if values[0] != values[1] {
+				detailFields = append(detailFields, field)
+			}
+		}
+		sort.Strings(detailFields)
+
+		switch {
+		case len(timeFields) > 0:
+			changes = append(changes, Change{Type: TimeChanged, UID: key.uid, RecurrenceID: key.recurrenceID, DetectedAt: now,
+				Fields: append(timeFields, detailFields...), Before: previous, After: event})
+		case len(detailFields) > 0:
+			changes = append(changes, Change{Type: DetailsChanged, UID: key.uid, RecurrenceID: key.recurrenceID, DetectedAt: now,
+				Fields: detailFields, Before: previous, After: event})
+		}
+	}
+	for key, event := range old {
+		if _, ok := current[key]; !ok {
+			changes = append(changes, Change{Type: Removed, UID: key.uid, RecurrenceID: key.recurrenceID, DetectedAt: now, Before: event})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i].Event(), changes[j].Event()
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		if changes[i].UID != changes[j].UID {
+			return changes[i].UID < changes[j].UID
+		}
+		return changes[i].RecurrenceID < changes[j].RecurrenceID
+	})
+	return changes
+}
+
+// eventKey identifies an event or an overridden occurrence
+type eventKey struct {
+	uid          string
+	recurrenceID string
+}
+
+// snapshot indexes the events of a calendar. The placeholder event written
+// for empty calendars is left out, its UID changes on every merge.
+func snapshot(cal *ics.Calendar, loc *time.Location) map[eventKey]*Event {
+	events := make(map[eventKey]*Event)
+	if cal == nil {
+		return events
+	}
+	for _, event := range cal.Events() {
+		uid := ical.PropertyValue(event, ics.ComponentPropertyUniqueId)
+		if uid == "" || ical.IsInfoEvent(uid) {
+			continue
+		}
+		start, end, allDay, err := ical.EventTimes(event, loc)
+		if err != nil {
+			continue
+		}
+
+		var sources []string
+		for _, prop := range event.GetProperties(ical.PropertySource) {
+			sources = append(sources, prop.Value)
+		}
+
+		// Compare recurrence IDs as instants too, their format may differ between writes
+		recurrenceID := ical.PropertyValue(event, ics.ComponentPropertyRecurrenceId)
+		if prop := event.GetProperty(ics.ComponentPropertyRecurrenceId); prop != nil {
+			overridden := &ics.VEvent{}
+			overridden.SetProperty(ics.ComponentPropertyDtStart, prop.Value, params(prop)...)
+			if t, _, _, err := ical.EventTimes(overridden, loc); err == nil {
+				recurrenceID = t.UTC().Format("20060102T150405Z")
+			}
+		}
+
+		events[eventKey{uid: uid, recurrenceID: recurrenceID}] = &Event{
+			Summary:     ical.PropertyValue(event, ics.ComponentPropertySummary),
+			Start:       start,
+			End:         end,
+			AllDay:      allDay,
+			Location:    ical.PropertyValue(event, ics.ComponentPropertyLocation),
+			Description: ical.PropertyValue(event, ics.ComponentPropertyDescription),
+			Status:      strings.ToLower(ical.PropertyValue(event, ics.ComponentPropertyStatus)),
+			Sources:     sources,
+		}
+	}
+	return events
+}
+
+// History keeps the most recent changes of all outputs
+type History struct {
+	mu      sync.RWMutex
+	size    int
+	changes []Change
+}
+
+// New creates a history keeping at most size changes (DefaultSize if size <= 0)
+func New(size int) *History {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &History{size: size}
+}
+
+// Add records changes, dropping the oldest ones beyond the size limit
+func (h *History) Add(changes []Change) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.changes = append(h.changes, changes...)
+	if excess := len(h.changes) - h.size; excess > 0 {
+		h.changes = append([]Change(nil), h.changes[excess:]...)
+	}
+}
+
+// Since returns the changes of an output detected after since, oldest first
+func (h *History) Since(output string, since time.Time) []Change {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var result []Change
+	for _, change := range h.changes {
+		if change.Output == output && change.DetectedAt.After(since) {
+			result = append(result, change)
+		}
+	}
+	return result
+}
+
+// params returns the parameters of a property
+func params(prop *ics.IANAProperty) []ics.PropertyParameter {
+	var result []ics.PropertyParameter
+	for key, values := range prop.ICalParameters {
+		result = append(result, &ics.KeyValues{Key: key, Value: values})
+	}
+	return result
+}
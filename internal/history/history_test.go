@@ -0,0 +1,125 @@
+package history
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arran4/golang-ical"
+)
+
+// parse parses a test calendar written with \n line endings
+func parse(t *testing.T, events string) *ics.Calendar {
+	t.Helper()
+	cal, err := ics.ParseCalendar(strings.NewReader(strings.ReplaceAll("BEGIN:VCALENDAR\nVERSION:2.0\nPRODID:-//TEST//EN\n"+events+"END:VCALENDAR\n", "\n", "\r\n")))
+	if err != nil {
+		t.Fatalf("Failed to parse test calendar: %v", err)
+	}
+	return cal
+}
+
+// TestDiff tests the classification of changes, including overrides whose
+// RECURRENCE-ID is written differently between syncs
+func TestDiff(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("Timezone data not available: %v", err)
+	}
+
+	before := parse(t, `BEGIN:VEVENT
+UID:parent-evening
+SUMMARY:Parent evening
+DTSTART;TZID=Europe/Berlin:20250114T190000
+DTEND;TZID=Europe/Berlin:20250114T210000
+END:VEVENT
+BEGIN:VEVENT
+UID:football
+SUMMARY:Football
+DTSTART;TZID=Europe/Berlin:20250110T170000
+DTEND;TZID=Europe/Berlin:20250110T180000
+RRULE:FREQ=WEEKLY
+END:VEVENT
+BEGIN:VEVENT
+UID:football
+SUMMARY:Football
+LOCATION:Field 1
+RECURRENCE-ID;TZID=Europe/Berlin:20250117T170000
+DTSTART;TZID=Europe/Berlin:20250117T170000
+DTEND;TZID=Europe/Berlin:20250117T180000
+END:VEVENT
+BEGIN:VEVENT
+UID:dentist
+SUMMARY:Dentist
+DTSTART;TZID=Europe/Berlin:20250112T090000
+DTEND;TZID=Europe/Berlin:20250112T100000
+END:VEVENT
+BEGIN:VEVENT
+UID:dummy-event-20250101000000
+SUMMARY:Calendar Merger Info
+DTSTART:20250101T000000Z
+END:VEVENT
+`)
+	after := parse(t, `BEGIN:VEVENT
+UID:parent-evening
+SUMMARY:Parent evening
+DTSTART;TZID=Europe/Berlin:20250115T190000
+DTEND;TZID=Europe/Berlin:20250115T210000
+END:VEVENT
+BEGIN:VEVENT
+UID:football
+SUMMARY:Football
+DTSTART;TZID=Europe/Berlin:20250110T170000
+DTEND;TZID=Europe/Berlin:20250110T180000
+RRULE:FREQ=WEEKLY
+END:VEVENT
+BEGIN:VEVENT
+UID:football
+SUMMARY:Football
+LOCATION:Field 2
+RECURRENCE-ID:20250117T160000Z
+DTSTART;TZID=Europe/Berlin:20250117T170000
+DTEND;TZID=Europe/Berlin:20250117T180000
+END:VEVENT
+BEGIN:VEVENT
+UID:concert
+SUMMARY:Concert
+DTSTART;TZID=Europe/Berlin:20250118T200000
+DTEND;TZID=Europe/Berlin:20250118T220000
+END:VEVENT
+BEGIN:VEVENT
+UID:dummy-event-20250102000000
+SUMMARY:Calendar Merger Info
+DTSTART:20250102T000000Z
+END:VEVENT
+`)
+
+	var got []string
+	for _, change := range Diff(before, after, berlin) {
+		got = append(got, change.Type+" "+change.UID+" "+change.RecurrenceID+" "+strings.Join(change.Fields, ","))
+	}
+	want := []string{
+		"removed dentist  ",
+		"time-changed parent-evening  start,end",
+		"details-changed football 20250117T160000Z location",
+		"added concert  ",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected changes:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// TestHistorySize tests that the history drops the oldest changes
+func TestHistorySize(t *testing.T) {
+	h := New(2)
+	start := time.Now()
+	h.Add([]Change{{UID: "a", DetectedAt: start}, {UID: "b", DetectedAt: start.Add(time.Second)}})
+	h.Add([]Change{{UID: "c", DetectedAt: start.Add(2 * time.Second)}, {UID: "other", Output: "kids", DetectedAt: start.Add(2 * time.Second)}})
+
+	changes := h.Since("", time.Time{})
+	if len(changes) != 1 || changes[0].UID != "c" {
+		t.Errorf("Unexpected changes of the default output: %+v", changes)
+	}
+	if changes := h.Since("kids", start.Add(time.Second)); len(changes) != 1 {
+		t.Errorf("Unexpected changes of the kids output: %+v", changes)
+	}
+}
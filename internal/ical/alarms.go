@@ -0,0 +1,130 @@
+package ical
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arran4/golang-ical"
+)
+
+// Alarm is a default reminder added to merged events
+type Alarm struct {
+	// AllDay selects all-day events, otherwise the alarm applies to timed events
+	AllDay bool
+	// Trigger is the offset from the event start, negative for before the start.
+	// For all-day events the start is midnight, so -15h is 9:00 the day before.
+	Trigger time.Duration
+}
+
+// addAlarms adds the source alarms (for calendars that keep them) or, for
+// events without any, the default alarms of the calendars and the output
+func addAlarms(newEvent *ics.VEvent, event *Event, sourcesByName map[string]Source, defaults []Alarm, privacy PrivacyLevel) {
+	summary := PropertyValue(newEvent, ics.ComponentPropertySummary)
+
+	// Keep the original alarms of the first calendar that wants them
+	for _, calID := range event.CalendarIDs {
+		if !sourcesByName[calID].KeepAlarms {
+			continue
+		}
+		for _, alarm := range event.OriginalEvent.Alarms() {
+			if copied := copyAlarm(alarm, summary, privacy); copied != nil {
+				newEvent.AddVAlarm(copied)
+			}
+		}
+		break
+	}
+	if len(newEvent.Alarms()) > 0 {
+		return
+	}
+
+	allDay := false
+	if start := event.OriginalEvent.GetProperty(ics.ComponentPropertyDtStart); start != nil {
+		_, allDay, _ = parseDateProperty(start, time.UTC)
+	}
+
+	var alarms []Alarm
+	for _, calID := range event.CalendarIDs {
+		alarms = append(alarms, sourcesByName[calID].Alarms...)
+	}
+	alarms = append(alarms, defaults...)
+
+	// Several calendars may define the same reminder, add it once
+	added := make(map[time.Duration]bool)
+	for _, alarm := range alarms {
+		if alarm.AllDay != allDay || added[alarm.Trigger] {
+			continue
+		}
+		added[alarm.Trigger] = true
+
+		valarm := newEvent.AddAlarm()
+		valarm.SetAction(ics.ActionDisplay)
+		valarm.SetTrigger(FormatDuration(alarm.Trigger))
+		valarm.SetProperty(ics.ComponentPropertyDescription, summary)
+	}
+}
+
+// copyAlarm copies a display or audio alarm of a source event. Email alarms
+// are dropped, they would notify the original attendees. Descriptions of
+// masked events are replaced by the published summary.
+func copyAlarm(alarm *ics.VAlarm, summary string, privacy PrivacyLevel) *ics.VAlarm {
+	action := strings.ToUpper(PropertyValue(alarm, ics.ComponentPropertyAction))
+	if action != string(ics.ActionDisplay) && action != string(ics.ActionAudio) {
+		return nil
+	}
+	trigger := alarm.GetProperty(ics.ComponentPropertyTrigger)
+	if trigger == nil {
+		return nil
+	}
+
+	copied := &ics.VAlarm{}
+	copied.SetAction(ics.Action(action))
+	copied.Properties = append(copied.Properties, *trigger)
+	for _, prop := range []ics.ComponentProperty{ics.ComponentPropertyDuration, ics.ComponentProperty(ics.PropertyRepeat)} {
+		if p := alarm.GetProperty(prop); p != nil {
+			copied.Properties = append(copied.Properties, *p)
+		}
+	}
+
+	if action == string(ics.ActionDisplay) {
+		description := PropertyValue(alarm, ics.ComponentPropertyDescription)
+		if description == "" || privacy != PrivacyFull {
+			description = summary
+		}
+		copied.SetProperty(ics.ComponentPropertyDescription, description)
+	}
+
+	return copied
+}
+
+// FormatDuration formats a duration as an RFC 5545 DURATION value, e.g. "-PT30M"
+func FormatDuration(d time.Duration) string {
+	var b strings.Builder
+	if d < 0 {
+		b.WriteString("-")
+		d = -d
+	}
+	b.WriteString("P")
+
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	if days > 0 {
+		b.WriteString(strconv.Itoa(int(days)) + "D")
+	}
+	if d == 0 && days > 0 {
+		return b.String()
+	}
+
+	b.WriteString("T")
+	hours, minutes, seconds := d/time.Hour, (d%time.Hour)/time.Minute, (d%time.Minute)/time.Second
+	if hours > 0 {
+		b.WriteString(strconv.Itoa(int(hours)) + "H")
+	}
+	if minutes > 0 {
+		b.WriteString(strconv.Itoa(int(minutes)) + "M")
+	}
+	if seconds > 0 || (hours == 0 && minutes == 0) {
+		b.WriteString(strconv.Itoa(int(seconds)) + "S")
+	}
+	return b.String()
+}
@@ -0,0 +1,153 @@
+package ical
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/arran4/golang-ical"
+)
+
+// BusyPeriod is a time range in which the calendar owners are not available
+type BusyPeriod struct {
+	Start time.Time
+	End   time.Time
+	Type  ics.FreeBusyTimeType
+}
+
+// BusyPeriods returns the busy periods of the occurrences, clipped to
+// [from, to). Transparent events are free time, tentative events are
+// BUSY-TENTATIVE and overlapping periods of the same type are joined.
+func BusyPeriods(occurrences []Occurrence, from, to time.Time) []BusyPeriod {
+	byType := make(map[ics.FreeBusyTimeType][]BusyPeriod)
+	for _, o := range occurrences {
+		if strings.EqualFold(PropertyValue(o.Event, ics.ComponentPropertyTransp), "TRANSPARENT") {
+			continue
+		}
+		fbType := ics.FreeBusyTimeTypeBusy
+		if strings.EqualFold(PropertyValue(o.Event, ics.ComponentPropertyStatus), "TENTATIVE") {
+			fbType = ics.FreeBusyTimeTypeBusyTentative
+		}
+
+		start, end := o.Start, o.End
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if !start.Before(end) {
+			continue
+		}
+		byType[fbType] = append(byType[fbType], BusyPeriod{Start: start, End: end, Type: fbType})
+	}
+
+	var periods []BusyPeriod
+	for _, typed := range byType {
+		periods = append(periods, joinPeriods(typed)...)
+	}
+	sort.Slice(periods, func(i, j int) bool {
+		if !periods[i].Start.Equal(periods[j].Start) {
+			return periods[i].Start.Before(periods[j].Start)
+		}
+		return periods[i].Type < periods[j].Type
+	})
+	return periods
+}
+
+// joinPeriods merges overlapping and adjacent periods
+func joinPeriods(periods []BusyPeriod) []BusyPeriod {
+	sort.Slice(periods, func(i, j int) bool { return periods[i].Start.Before(periods[j].Start) })
+
+	var joined []BusyPeriod
+	for _, p := range periods {
+		if n := len(joined); n > 0 && !p.Start.After(joined[n-1].End) {
+			if p.End.After(joined[n-1].End) {
+				joined[n-1].End = p.End
+			}
+			continue
+		}
+		joined = append(joined, p)
+	}
+	return joined
+}
+
+// NewFreeBusy returns a VFREEBUSY component for [from, to) listing the busy
+// periods in UTC, one FREEBUSY property per period
+func NewFreeBusy(uid string, from, to time.Time, periods []BusyPeriod) *ics.VBusy {
+	busy := ics.NewBusy(uid)
+	busy.SetDtStampTime(time.Now())
+	busy.SetProperty(ics.ComponentPropertyDtStart, from.UTC().Format("20060102T150405Z"))
+	busy.SetProperty(ics.ComponentPropertyDtEnd, to.UTC().Format("20060102T150405Z"))
+
+	for _, p := range periods {
+		value := fmt.Sprintf("%s/%s", p.Start.UTC().Format("20060102T150405Z"), p.End.UTC().Format("20060102T150405Z"))
+		busy.AddProperty(ics.ComponentPropertyFreebusy, value, &ics.KeyValues{Key: "FBTYPE", Value: []string{string(p.Type)}})
+	}
+	return busy
+}
+
+// FreeBusyRequest is the period and parties of an iTIP VFREEBUSY request
+type FreeBusyRequest struct {
+	UID       string
+	Start     time.Time
+	End       time.Time
+	Organizer *ics.IANAProperty
+	Attendees []*ics.IANAProperty
+}
+
+// ParseFreeBusyRequest reads the first VFREEBUSY of an iTIP REQUEST
+func ParseFreeBusyRequest(cal *ics.Calendar) (*FreeBusyRequest, error) {
+	busys := cal.Busys()
+	if len(busys) == 0 {
+		return nil, fmt.Errorf("request contains no VFREEBUSY")
+	}
+	busy := busys[0]
+
+	startProp := busy.GetProperty(ics.ComponentPropertyDtStart)
+	endProp := busy.GetProperty(ics.ComponentPropertyDtEnd)
+	if startProp == nil || endProp == nil {
+		return nil, fmt.Errorf("VFREEBUSY request needs DTSTART and DTEND")
+	}
+	start, _, err := parseDateProperty(startProp, time.UTC)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DTSTART: %w", err)
+	}
+	end, _, err := parseDateProperty(endProp, time.UTC)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DTEND: %w", err)
+	}
+	if !start.Before(end) {
+		return nil, fmt.Errorf("DTEND must be after DTSTART")
+	}
+
+	return &FreeBusyRequest{
+		UID:       PropertyValue(busy, ics.ComponentPropertyUniqueId),
+		Start:     start,
+		End:       end,
+		Organizer: busy.GetProperty(ics.ComponentPropertyOrganizer),
+		Attendees: busy.GetProperties(ics.ComponentPropertyAttendee),
+	}, nil
+}
+
+// FreeBusyReply answers a request with a METHOD:REPLY calendar
+func FreeBusyReply(request *FreeBusyRequest, periods []BusyPeriod) *ics.Calendar {
+	reply := ics.NewCalendar()
+	reply.SetMethod(ics.MethodReply)
+	reply.SetProductId("-//ical_merger//GO")
+
+	uid := request.UID
+	if uid == "" {
+		uid = fmt.Sprintf("freebusy-%d", time.Now().UnixNano())
+	}
+	busy := NewFreeBusy(uid, request.Start, request.End, periods)
+	if request.Organizer != nil {
+		busy.AddProperty(ics.ComponentPropertyOrganizer, request.Organizer.Value, propertyParams(request.Organizer)...)
+	}
+	for _, attendee := range request.Attendees {
+		busy.AddProperty(ics.ComponentPropertyAttendee, attendee.Value, propertyParams(attendee)...)
+	}
+	reply.AddVBusy(busy)
+	return reply
+}
@@ -0,0 +1,156 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arran4/golang-ical"
+)
+
+// TestBusyPeriods tests which events block time and how periods are joined
+// and clipped
+func TestBusyPeriods(t *testing.T) {
+	cal := mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:standup
+SUMMARY:Standup
+DTSTART:20250106T080000Z
+DTEND:20250106T090000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:review
+SUMMARY:Review
+DTSTART:20250106T083000Z
+DTEND:20250106T100000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:lunch
+SUMMARY:Lunch
+DTSTART:20250106T100000Z
+DTEND:20250106T110000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:focus
+SUMMARY:Focus time
+TRANSP:TRANSPARENT
+DTSTART:20250106T120000Z
+DTEND:20250106T150000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:call
+SUMMARY:Call
+STATUS:CANCELLED
+DTSTART:20250106T130000Z
+DTEND:20250106T140000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:maybe
+SUMMARY:Maybe
+STATUS:TENTATIVE
+DTSTART:20250106T143000Z
+DTEND:20250106T153000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:late
+SUMMARY:Late
+DTSTART:20250106T170000Z
+DTEND:20250106T190000Z
+END:VEVENT
+END:VCALENDAR
+`)
+	from := time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 1, 6, 18, 0, 0, 0, time.UTC)
+	periods := BusyPeriods(ExpandCalendar(cal, from, to, time.UTC), from, to)
+
+	var got []string
+	for _, p := range periods {
+		got = append(got, p.Start.Format("15:04")+"-"+p.End.Format("15:04")+" "+string(p.Type))
+	}
+	// Overlapping and adjacent events are one period, the transparent and
+	// the cancelled event are free time and the last event is clipped
+	want := "08:00-11:00 BUSY, 14:30-15:30 BUSY-TENTATIVE, 17:00-18:00 BUSY"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("Unexpected busy periods:\n%s\nwant:\n%s", strings.Join(got, ", "), want)
+	}
+}
+
+// TestFreeBusyReply tests that requests are answered for their period with
+// their organizer and attendees
+func TestFreeBusyReply(t *testing.T) {
+	request, err := ParseFreeBusyRequest(mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+METHOD:REQUEST
+BEGIN:VFREEBUSY
+UID:request-1
+DTSTAMP:20250101T000000Z
+DTSTART:20250106T000000Z
+DTEND:20250107T000000Z
+ORGANIZER;CN=Jane Doe:mailto:jane@example.com
+ATTENDEE:mailto:arthur@example.com
+END:VFREEBUSY
+END:VCALENDAR
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.UID != "request-1" || request.Start.Format(time.RFC3339) != "2025-01-06T00:00:00Z" || request.End.Format(time.RFC3339) != "2025-01-07T00:00:00Z" {
+		t.Errorf("Unexpected request %+v", request)
+	}
+
+	periods := []BusyPeriod{{
+		Start: time.Date(2025, 1, 6, 8, 0, 0, 0, time.UTC),
+		End:   time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC),
+		Type:  ics.FreeBusyTimeTypeBusy,
+	}}
+	reply := FreeBusyReply(request, periods).Serialize()
+	for _, want := range []string{
+		"METHOD:REPLY",
+		"UID:request-1",
+		"DTSTART:20250106T000000Z",
+		"DTEND:20250107T000000Z",
+		"ORGANIZER;CN=Jane Doe:mailto:jane@example.com",
+		"ATTENDEE:mailto:arthur@example.com",
+		"FREEBUSY;FBTYPE=BUSY:20250106T080000Z/20250106T090000Z",
+	} {
+		if !strings.Contains(reply, want) {
+			t.Errorf("Expected %s in the reply:\n%s", want, reply)
+		}
+	}
+
+	// Without ORGANIZER the reply has none either
+	request, err = ParseFreeBusyRequest(mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+METHOD:REQUEST
+BEGIN:VFREEBUSY
+DTSTAMP:20250101T000000Z
+DTSTART;VALUE=DATE:20250106
+DTEND;VALUE=DATE:20250108
+END:VFREEBUSY
+END:VCALENDAR
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Organizer != nil || request.End.Sub(request.Start) != 48*time.Hour {
+		t.Errorf("Unexpected request %+v", request)
+	}
+	reply = FreeBusyReply(request, nil).Serialize()
+	if strings.Contains(reply, "ORGANIZER") || !strings.Contains(reply, "UID:freebusy-") {
+		t.Errorf("Unexpected reply to a request without organizer:\n%s", reply)
+	}
+
+	for _, invalid := range []string{
+		"BEGIN:VCALENDAR\nVERSION:2.0\nPRODID:-//TEST//EN\nEND:VCALENDAR\n",
+		"BEGIN:VCALENDAR\nVERSION:2.0\nPRODID:-//TEST//EN\nBEGIN:VFREEBUSY\nDTSTART:20250106T000000Z\nEND:VFREEBUSY\nEND:VCALENDAR\n",
+		"BEGIN:VCALENDAR\nVERSION:2.0\nPRODID:-//TEST//EN\nBEGIN:VFREEBUSY\nDTSTART:20250107T000000Z\nDTEND:20250106T000000Z\nEND:VFREEBUSY\nEND:VCALENDAR\n",
+	} {
+		if _, err := ParseFreeBusyRequest(mustParse(t, invalid)); err == nil {
+			t.Errorf("Expected an error for:\n%s", invalid)
+		}
+	}
+}
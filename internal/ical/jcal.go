@@ -0,0 +1,325 @@
+package ical
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/arran4/golang-ical"
+)
+
+// JCalMediaType is the media type of jCal (RFC 7265)
+const JCalMediaType = "application/calendar+json"
+
+// ToJCal converts a calendar to jCal, the JSON representation of
+// iCalendar: ["vcalendar", [properties...], [components...]]
+func ToJCal(cal *ics.Calendar) ([]byte, error) {
+	props := make([]any, 0, len(cal.CalendarProperties))
+	for _, p := range cal.CalendarProperties {
+		props = append(props, jcalProperty(exportProperty(p.BaseProperty)))
+	}
+	return json.Marshal([]any{"vcalendar", props, jcalComponents(cal.Components)})
+}
+
+// jcalComponents converts components to jCal arrays
+func jcalComponents(components []ics.Component) []any {
+	result := make([]any, 0, len(components))
+	for _, c := range components {
+		props := make([]any, 0)
+		for _, p := range c.UnknownPropertiesIANAProperties() {
+			props = append(props, jcalProperty(exportProperty(p.BaseProperty)))
+		}
+		result = append(result, []any{componentName(c), props, jcalComponents(c.SubComponents())})
+	}
+	return result
+}
+
+// jcalProperty converts a property to [name, {params}, type, values...].
+// Numbers and booleans become JSON values, GEO an array of two floats.
+func jcalProperty(p property) []any {
+	params := make(map[string]any, len(p.params))
+	for name, values := range p.params {
+		if len(values) == 1 {
+			params[name] = values[0]
+		} else {
+			params[name] = values
+		}
+	}
+	prop := []any{p.name, params, p.typ}
+
+	if p.typ == "recur" {
+		return append(prop, jcalRecur(p.recur))
+	}
+	for _, value := range p.values {
+		switch p.typ {
+		case "integer":
+			if _, err := strconv.Atoi(value); err == nil {
+				prop = append(prop, json.Number(value))
+				continue
+			}
+		case "float":
+			var numbers []any
+			for _, part := range strings.Split(value, ";") {
+				if _, err := strconv.ParseFloat(part, 64); err != nil {
+					numbers = nil
+					break
+				}
+				numbers = append(numbers, json.Number(part))
+			}
+			if len(numbers) > 1 {
+				prop = append(prop, numbers)
+				continue
+			}
+			if len(numbers) == 1 {
+				prop = append(prop, numbers[0])
+				continue
+			}
+		case "boolean":
+			if b, err := strconv.ParseBool(value); err == nil {
+				prop = append(prop, b)
+				continue
+			}
+		}
+		prop = append(prop, value)
+	}
+	return prop
+}
+
+// jcalRecur is a recur value, written as a JSON object keeping the order
+// of the parts
+type jcalRecur []recurPart
+
+// MarshalJSON writes the parts as {"freq": "WEEKLY", "byday": ["MO", "TU"]},
+// single values as scalars and the numeric parts as integers
+func (r jcalRecur) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, part := range r {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(part.name)
+		b.Write(name)
+		b.WriteByte(':')
+
+		values := make([]any, len(part.values))
+		for j, value := range part.values {
+			values[j] = value
+			if _, err := strconv.Atoi(value); err == nil && recurIntegers[part.name] {
+				values[j] = json.Number(value)
+			}
+		}
+		var value []byte
+		var err error
+		if len(values) == 1 {
+			value, err = json.Marshal(values[0])
+		} else {
+			value, err = json.Marshal(values)
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// ParseJCal reads a jCal calendar. A list of calendars is merged into one.
+func ParseJCal(r io.Reader) (*ics.Calendar, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	value, err := decodeJSON(decoder)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jCal: %w", err)
+	}
+	data, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("invalid jCal: expected an array")
+	}
+
+	calendars := []any{data}
+	if len(data) > 0 {
+		if _, wrapped := data[0].([]any); wrapped {
+			calendars = data
+		}
+	}
+
+	cal := &ics.Calendar{}
+	for i, data := range calendars {
+		name, props, components, err := jcalComponent(data)
+		if err != nil {
+			return nil, err
+		}
+		if name != "vcalendar" {
+			return nil, fmt.Errorf("invalid jCal: expected vcalendar, got %s", name)
+		}
+		// Properties of the first calendar, components of all
+		if i == 0 {
+			for _, p := range props {
+				cal.CalendarProperties = append(cal.CalendarProperties, ics.CalendarProperty{BaseProperty: p})
+			}
+		}
+		cal.Components = append(cal.Components, components...)
+	}
+	return cal, nil
+}
+
+// jcalComponent reads a [name, [properties...], [components...]] array
+func jcalComponent(data any) (string, []ics.BaseProperty, []ics.Component, error) {
+	parts, ok := data.([]any)
+	if !ok || len(parts) != 3 {
+		return "", nil, nil, fmt.Errorf("invalid jCal: components are arrays of name, properties and components")
+	}
+	name, ok := parts[0].(string)
+	rawProps, okProps := parts[1].([]any)
+	rawComponents, okComponents := parts[2].([]any)
+	if !ok || !okProps || !okComponents {
+		return "", nil, nil, fmt.Errorf("invalid jCal: components are arrays of name, properties and components")
+	}
+
+	var props []ics.BaseProperty
+	for _, raw := range rawProps {
+		p, err := parseJCalProperty(raw)
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		props = append(props, p)
+	}
+
+	var components []ics.Component
+	for _, raw := range rawComponents {
+		subName, subProps, subComponents, err := jcalComponent(raw)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		component, base := newComponent(subName)
+		for _, p := range subProps {
+			base.Properties = append(base.Properties, ics.IANAProperty{BaseProperty: p})
+		}
+		base.Components = subComponents
+		components = append(components, component)
+	}
+	return strings.ToLower(name), props, components, nil
+}
+
+// parseJCalProperty reads a [name, {params}, type, values...] array
+func parseJCalProperty(data any) (ics.BaseProperty, error) {
+	parts, ok := data.([]any)
+	if !ok || len(parts) < 3 {
+		return ics.BaseProperty{}, fmt.Errorf("invalid jCal property %v", data)
+	}
+	name, okName := parts[0].(string)
+	params, okParams := parts[1].(jsonObject)
+	typ, okType := parts[2].(string)
+	if !okName || !okParams || !okType {
+		return ics.BaseProperty{}, fmt.Errorf("invalid jCal property %v", data)
+	}
+
+	prop := property{name: name, params: make(map[string][]string), typ: strings.ToLower(typ)}
+	for _, param := range params {
+		if values, ok := param.value.([]any); ok {
+			for _, v := range values {
+				prop.params[param.key] = append(prop.params[param.key], jcalString(v))
+			}
+			continue
+		}
+		prop.params[param.key] = []string{jcalString(param.value)}
+	}
+
+	for _, value := range parts[3:] {
+		if rule, ok := value.(jsonObject); ok && prop.typ == "recur" {
+			for _, member := range rule {
+				part := recurPart{name: strings.ToLower(member.key)}
+				if values, ok := member.value.([]any); ok {
+					for _, v := range values {
+						part.values = append(part.values, jcalString(v))
+					}
+				} else {
+					part.values = []string{jcalString(member.value)}
+				}
+				prop.recur = append(prop.recur, part)
+			}
+			continue
+		}
+		prop.values = append(prop.values, jcalString(value))
+	}
+	return prop.base(), nil
+}
+
+// jcalString converts a JSON value to its iCalendar text. Arrays are
+// structured values like GEO, their parts are separated by semicolons.
+func jcalString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v))
+	case []any:
+		parts := make([]string, len(v))
+		for i, part := range v {
+			parts[i] = jcalString(part)
+		}
+		return strings.Join(parts, ";")
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// jsonObject is a decoded JSON object with its members in order, so recur
+// values keep the order of their parts
+type jsonObject []jsonMember
+
+// jsonMember is a member of a JSON object
+type jsonMember struct {
+	key   string
+	value any
+}
+
+// decodeJSON decodes the next JSON value like json.Decoder.Decode into an
+// any, but objects as jsonObject
+func decodeJSON(decoder *json.Decoder) (any, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return token, nil
+	}
+	switch delim {
+	case '[':
+		values := make([]any, 0)
+		for decoder.More() {
+			value, err := decodeJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		_, err = decoder.Token()
+		return values, err
+	case '{':
+		object := make(jsonObject, 0)
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, jsonMember{key: key.(string), value: value})
+		}
+		_, err = decoder.Token()
+		return object, err
+	}
+	return nil, fmt.Errorf("unexpected %v", delim)
+}
@@ -0,0 +1,94 @@
+package ical
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/arran4/golang-ical"
+)
+
+const roundTripCalendar = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VTIMEZONE
+TZID:Europe/Berlin
+BEGIN:STANDARD
+DTSTART:19701025T030000
+TZOFFSETFROM:+0200
+TZOFFSETTO:+0100
+RRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU
+END:STANDARD
+END:VTIMEZONE
+BEGIN:VEVENT
+UID:standup
+SUMMARY:Standup\, daily
+DTSTART;TZID=Europe/Berlin:20250106T090000
+DTEND;TZID=Europe/Berlin:20250106T091500
+RRULE:FREQ=WEEKLY;UNTIL=20250131T235959Z;INTERVAL=2;BYDAY=MO,WE
+EXDATE;TZID=Europe/Berlin:20250108T090000,20250120T090000
+ATTENDEE;CN=Jane Doe;PARTSTAT=ACCEPTED:mailto:jane@example.com
+GEO:52.52;13.405
+SEQUENCE:2
+X-ICALMERGER-SOURCE:Arthur
+BEGIN:VALARM
+ACTION:DISPLAY
+TRIGGER:-PT15M
+END:VALARM
+END:VEVENT
+BEGIN:VEVENT
+UID:holiday
+SUMMARY:Holiday
+DTSTART;VALUE=DATE:20250110
+END:VEVENT
+END:VCALENDAR
+`
+
+// TestJCal tests the jCal representation of properties and that a calendar
+// survives the conversion to jCal and back
+func TestJCal(t *testing.T) {
+	cal, err := ics.ParseCalendar(strings.NewReader(strings.ReplaceAll(roundTripCalendar, "\n", "\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ToJCal(cal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`["tzoffsetfrom",{},"utc-offset","+02:00"]`,
+		`["rrule",{},"recur",{"freq":"YEARLY","bymonth":10,"byday":"-1SU"}]`,
+		`["summary",{},"text","Standup, daily"]`,
+		`["dtstart",{"tzid":"Europe/Berlin"},"date-time","2025-01-06T09:00:00"]`,
+		`["rrule",{},"recur",{"freq":"WEEKLY","until":"2025-01-31T23:59:59Z","interval":2,"byday":["MO","WE"]}]`,
+		`["exdate",{"tzid":"Europe/Berlin"},"date-time","2025-01-08T09:00:00","2025-01-20T09:00:00"]`,
+		`["attendee",{"cn":"Jane Doe","partstat":"ACCEPTED"},"cal-address","mailto:jane@example.com"]`,
+		`["geo",{},"float",[52.52,13.405]]`,
+		`["sequence",{},"integer",2]`,
+		`["x-icalmerger-source",{},"unknown","Arthur"]`,
+		`["valarm",[["action",{},"text","DISPLAY"],["trigger",{},"duration","-PT15M"]],[]]`,
+		`["dtstart",{},"date","2025-01-10"]`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected %s in the jCal output", want)
+		}
+	}
+	if !json.Valid(data) {
+		t.Fatal("Expected valid JSON")
+	}
+
+	parsed, err := ParseJCal(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("Failed to parse the jCal output: %v", err)
+	}
+	if got, want := parsed.Serialize(), cal.Serialize(); got != want {
+		t.Errorf("Round trip changed the calendar:\n%s\nwant:\n%s", got, want)
+	}
+
+	for _, invalid := range []string{`{}`, `["vevent",[],[]]`, `["vcalendar",[["summary"]],[]]`} {
+		if _, err := ParseJCal(strings.NewReader(invalid)); err == nil {
+			t.Errorf("Expected an error for %s", invalid)
+		}
+	}
+}
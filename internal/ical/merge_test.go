@@ -0,0 +1,697 @@
+package ical
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arran4/golang-ical"
+)
+
+// mustParse parses a test calendar or fails the test
+func mustParse(t *testing.T, data string) *ics.Calendar {
+	t.Helper()
+	cal, err := ics.ParseCalendar(strings.NewReader(strings.ReplaceAll(data, "\n", "\r\n")))
+	if err != nil {
+		t.Fatalf("Failed to parse test calendar: %v", err)
+	}
+	return cal
+}
+
+// TestMergeCalendarsStableOrder tests that merged events are ordered by start
+// time, then UID, and that the output does not change between runs.
+func TestMergeCalendarsStableOrder(t *testing.T) {
+	home := mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//ical_merger//TEST//EN
+BEGIN:VEVENT
+UID:b-event
+SUMMARY:Second
+DTSTART:20250101T120000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:c-event
+SUMMARY:Third
+DTSTART;TZID=Europe/Berlin:20250101T140000
+END:VEVENT
+END:VCALENDAR
+`)
+	work := mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//ical_merger//TEST//EN
+BEGIN:VEVENT
+UID:a-event
+SUMMARY:Second too
+DTSTART:20250101T120000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:d-event
+SUMMARY:First
+DTSTART:20241231
+END:VEVENT
+END:VCALENDAR
+`)
+	sources := []Source{{Name: "Home", Calendar: home}, {Name: "Work", Calendar: work}}
+
+	merged := MergeCalendars(sources, MergeOptions{})
+	var uids []string
+	for _, event := range merged.Events() {
+		uids = append(uids, event.GetProperty(ics.ComponentPropertyUniqueId).Value)
+	}
+
+	expected := "d-event,a-event,b-event,c-event"
+	if got := strings.Join(uids, ","); got != expected {
+		t.Errorf("Unexpected event order: got %s, want %s", got, expected)
+	}
+
+	first := merged.Serialize()
+	for i := 0; i < 10; i++ {
+		if again := MergeCalendars(sources, MergeOptions{}).Serialize(); again != first {
+			t.Fatalf("Merged output changed between runs")
+		}
+	}
+}
+
+// TestCompareEventsUnparseable tests that events with a start that cannot
+// be parsed sort after all others, whatever order they come in
+func TestCompareEventsUnparseable(t *testing.T) {
+	cal := mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//ical_merger//TEST//EN
+BEGIN:VEVENT
+UID:valid-late
+DTSTART:20250301T120000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:bad-tomorrow
+DTSTART:tomorrow
+END:VEVENT
+BEGIN:VEVENT
+UID:valid-early
+DTSTART;VALUE=DATE:20250101
+END:VEVENT
+BEGIN:VEVENT
+UID:bad-number
+DTSTART:0000-bad
+END:VEVENT
+BEGIN:VEVENT
+UID:no-start
+END:VEVENT
+END:VCALENDAR
+`)
+	events := cal.Events()
+	expected := "valid-early,valid-late,no-start,bad-number,bad-tomorrow"
+
+	// Every permutation sorts the same, which needs a transitive order
+	var permute func(int)
+	permute = func(k int) {
+		if k == len(events) {
+			sorted := append([]*ics.VEvent(nil), events...)
+			sort.SliceStable(sorted, func(i, j int) bool { return compareEvents(sorted[i], sorted[j]) < 0 })
+			var uids []string
+			for _, event := range sorted {
+				uids = append(uids, event.Id())
+			}
+			if got := strings.Join(uids, ","); got != expected {
+				t.Fatalf("Unexpected event order: got %s, want %s", got, expected)
+			}
+			return
+		}
+		for i := k; i < len(events); i++ {
+			events[k], events[i] = events[i], events[k]
+			permute(k + 1)
+			events[k], events[i] = events[i], events[k]
+		}
+	}
+	permute(0)
+}
+
+// TestMergeCalendarsSummaryTemplates tests per-source templates, disabled
+// prefixes and the combined label for events found in several calendars.
+// Events a calendar has twice are labeled with that calendar only.
+func TestMergeCalendarsSummaryTemplates(t *testing.T) {
+	shared := `BEGIN:VEVENT
+UID:shared
+SUMMARY:Dinner
+DTSTART:20250101T180000Z
+END:VEVENT
+`
+	arthur := mustParse(t, "BEGIN:VCALENDAR\nVERSION:2.0\nPRODID:-//TEST//EN\n"+shared+`BEGIN:VEVENT
+UID:arthur-only
+SUMMARY:Gym
+DTSTART:20250101T080000Z
+END:VEVENT
+END:VCALENDAR
+`)
+	hannah := mustParse(t, "BEGIN:VCALENDAR\nVERSION:2.0\nPRODID:-//TEST//EN\n"+shared+`BEGIN:VEVENT
+UID:hannah-only
+SUMMARY:Yoga
+DTSTART:20250101T090000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:hannah-twice
+SUMMARY:Piano
+DTSTART:20250101T160000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:hannah-twice
+SUMMARY:Piano
+DTSTART:20250101T160000Z
+END:VEVENT
+END:VCALENDAR
+`)
+	home := mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:home-only
+SUMMARY:Bins
+DTSTART:20250101T070000Z
+END:VEVENT
+END:VCALENDAR
+`)
+
+	merged := MergeCalendars([]Source{
+		{Name: "Arthur", Calendar: arthur, SummaryTemplate: "{{.Initials}}: {{.Summary}}"},
+		{Name: "Hannah", Calendar: hannah},
+		{Name: "Home", Calendar: home, DisablePrefix: true},
+	}, MergeOptions{MultiSourceTemplate: "[{{.Initials}}] {{.Summary}}"})
+
+	expected := map[string]string{
+		"home-only":    "Bins",
+		"arthur-only":  "A: Gym",
+		"hannah-only":  "[Hannah] Yoga",
+		"hannah-twice": "[Hannah] Piano",
+		"shared":       "[A+H] Dinner",
+	}
+	for _, event := range merged.Events() {
+		uid := event.GetProperty(ics.ComponentPropertyUniqueId).Value
+		summary := event.GetProperty(ics.ComponentPropertySummary).Value
+		if summary != expected[uid] {
+			t.Errorf("Unexpected summary for %s: got %q, want %q", uid, summary, expected[uid])
+		}
+		if uid == "hannah-twice" && len(event.GetProperties(PropertySource)) != 1 {
+			t.Errorf("Expected Hannah once as the source of %s", uid)
+		}
+	}
+}
+
+// TestMergeCalendarsPrivacy tests per-source privacy levels and automatic
+// masking of CLASS:PRIVATE events.
+func TestMergeCalendarsPrivacy(t *testing.T) {
+	work := mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:review
+SUMMARY:Salary review
+DESCRIPTION:Bring numbers
+LOCATION:Room 4
+CATEGORIES:HR
+ATTENDEE;CN=Boss:mailto:boss@example.com
+DTSTART:20250101T100000Z
+END:VEVENT
+END:VCALENDAR
+`)
+	home := mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:doctor
+SUMMARY:Doctor
+CLASS:PRIVATE
+LOCATION:Clinic
+DTSTART:20250101T120000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:party
+SUMMARY:Party
+LOCATION:Garden
+ATTENDEE;CN=Jane Doe:mailto:jane@example.com
+ATTENDEE:mailto:max@example.com
+DTSTART:20250101T180000Z
+END:VEVENT
+END:VCALENDAR
+`)
+
+	shared := mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:party
+SUMMARY:Party
+ATTENDEE:MAILTO:JANE@example.com
+DTSTART:20250101T180000Z
+END:VEVENT
+END:VCALENDAR
+`)
+
+	sources := []Source{
+		{Name: "Work", Calendar: work, Privacy: PrivacyTitleOnly, DisablePrefix: true},
+		{Name: "Home", Calendar: home, Categories: []string{"Family"}},
+		{Name: "Shared", Calendar: shared},
+	}
+	merged := MergeCalendars(sources, MergeOptions{})
+
+	for _, event := range merged.Events() {
+		uid := event.GetProperty(ics.ComponentPropertyUniqueId).Value
+		// Attendees are only indexed, never published
+		if event.GetProperty(ics.ComponentPropertyAttendee) != nil {
+			t.Errorf("Event %s has attendees in the merged calendar", uid)
+		}
+		summary := event.GetProperty(ics.ComponentPropertySummary).Value
+		hasLocation := event.GetProperty(ics.ComponentPropertyLocation) != nil
+
+		switch uid {
+		case "review":
+			if summary != "Salary review" || hasLocation || event.GetProperty(ics.ComponentPropertyDescription) != nil {
+				t.Errorf("Title-only event not masked: %q, location %v", summary, hasLocation)
+			}
+			if event.GetProperty(ics.ComponentPropertyCategories) != nil {
+				t.Errorf("Title-only event kept its categories")
+			}
+		case "doctor":
+			if summary != "[Home] Busy" || hasLocation {
+				t.Errorf("Private event not masked: %q, location %v", summary, hasLocation)
+			}
+		case "party":
+			if summary != "Party" || !hasLocation {
+				t.Errorf("Full event was masked: %q, location %v", summary, hasLocation)
+			}
+		}
+	}
+
+	// Only events published at full privacy have their attendees indexed,
+	// once per address
+	attendees := Attendees(sources, PrivacyFull)
+	var addresses []string
+	for _, attendee := range attendees[EventKey{UID: "party"}] {
+		addresses = append(addresses, attendee.Value)
+	}
+	if got := strings.Join(addresses, ","); len(attendees) != 1 || got != "mailto:jane@example.com,mailto:max@example.com" {
+		t.Errorf("Unexpected attendees %v: %s", attendees, got)
+	}
+	if attendees := Attendees(sources, PrivacyTitleOnly); len(attendees) != 0 {
+		t.Errorf("Expected no attendees for a title-only output, got %v", attendees)
+	}
+}
+
+// TestMergeCalendarsAlarms tests default alarms and the passthrough of source alarms.
+func TestMergeCalendarsAlarms(t *testing.T) {
+	family := mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:dentist
+SUMMARY:Dentist
+DTSTART:20250101T100000Z
+BEGIN:VALARM
+ACTION:DISPLAY
+TRIGGER:-PT2H
+DESCRIPTION:Dentist soon
+END:VALARM
+BEGIN:VALARM
+ACTION:EMAIL
+TRIGGER:-P1D
+END:VALARM
+END:VEVENT
+BEGIN:VEVENT
+UID:holiday
+SUMMARY:Holiday
+DTSTART;VALUE=DATE:20250102
+END:VEVENT
+BEGIN:VEVENT
+UID:lunch
+SUMMARY:Lunch
+DTSTART:20250103T120000Z
+END:VEVENT
+END:VCALENDAR
+`)
+
+	merged := MergeCalendars([]Source{
+		{Name: "Family", Calendar: family, KeepAlarms: true, Alarms: []Alarm{{Trigger: -15 * time.Minute}}},
+	}, MergeOptions{Alarms: []Alarm{{Trigger: -30 * time.Minute}, {AllDay: true, Trigger: -6 * time.Hour}}})
+
+	expected := map[string]string{
+		"dentist": "-PT2H",
+		"holiday": "-PT6H",
+		"lunch":   "-PT15M,-PT30M",
+	}
+	for _, event := range merged.Events() {
+		uid := event.GetProperty(ics.ComponentPropertyUniqueId).Value
+		var triggers []string
+		for _, alarm := range event.Alarms() {
+			triggers = append(triggers, PropertyValue(alarm, ics.ComponentPropertyTrigger))
+		}
+		if got := strings.Join(triggers, ","); got != expected[uid] {
+			t.Errorf("Unexpected alarms for %s: got %s, want %s", uid, got, expected[uid])
+		}
+	}
+}
+
+// TestMergeCalendarsStatusPolicy tests the cancelled and tentative policies
+// and that cancelled overrides remove their occurrence from the series.
+func TestMergeCalendarsStatusPolicy(t *testing.T) {
+	cal := mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:standup
+SUMMARY:Standup
+DTSTART:20250106T090000Z
+RRULE:FREQ=DAILY;COUNT=5
+EXDATE:20250107T090000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:standup
+RECURRENCE-ID:20250108T090000Z
+SUMMARY:Standup
+STATUS:CANCELLED
+DTSTART:20250108T090000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:party
+SUMMARY:Party
+STATUS:CANCELLED
+DTSTART:20250110T180000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:lunch
+SUMMARY:Lunch
+STATUS:TENTATIVE
+DTSTART:20250110T120000Z
+END:VEVENT
+END:VCALENDAR
+`)
+	sources := []Source{{Name: "Work", Calendar: cal, DisablePrefix: true}}
+
+	summaries := func(merged *ics.Calendar) map[string]string {
+		result := make(map[string]string)
+		for _, event := range merged.Events() {
+			result[PropertyValue(event, ics.ComponentPropertyUniqueId)] = PropertyValue(event, ics.ComponentPropertySummary)
+		}
+		return result
+	}
+
+	merged := MergeCalendars(sources, MergeOptions{Cancelled: StatusDrop, Tentative: StatusMark})
+	if got := len(merged.Events()); got != 2 {
+		t.Fatalf("Expected the series and the tentative event, got %d events", got)
+	}
+	var exdates []string
+	for _, prop := range merged.Events()[0].GetProperties(ics.ComponentPropertyExdate) {
+		exdates = append(exdates, prop.Value)
+	}
+	if got := strings.Join(exdates, ","); got != "20250107T090000Z,20250108T090000Z" {
+		t.Errorf("Unexpected EXDATEs: %s", got)
+	}
+	if got := summaries(merged)["lunch"]; got != TentativeMarker+"Lunch" {
+		t.Errorf("Tentative event not marked: %q", got)
+	}
+
+	merged = MergeCalendars(sources, MergeOptions{Cancelled: StatusMark})
+	got := summaries(merged)
+	if got["party"] != CancelledMarker+"Party" || got["lunch"] != "Lunch" {
+		t.Errorf("Unexpected summaries with marked cancellations: %v", got)
+	}
+}
+
+// TestMergeCalendarsTravel tests travel buffers around located events and
+// the per-location durations.
+func TestMergeCalendarsTravel(t *testing.T) {
+	cal := mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:dentist
+SUMMARY:Dentist
+LOCATION:Main Street 5
+DTSTART;TZID=Europe/Berlin:20250101T100000
+DTEND;TZID=Europe/Berlin:20250101T110000
+END:VEVENT
+BEGIN:VEVENT
+UID:call
+SUMMARY:Call
+LOCATION:Zoom
+DTSTART:20250101T140000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:reading
+SUMMARY:Reading
+DTSTART:20250101T160000Z
+END:VEVENT
+END:VCALENDAR
+`)
+	travel := &Travel{
+		Before:    20 * time.Minute,
+		After:     15 * time.Minute,
+		Locations: []TravelLocation{{Pattern: regexp.MustCompile("(?i)zoom")}},
+	}
+	merged := MergeCalendars([]Source{{Name: "Home", Calendar: cal, Travel: travel}}, MergeOptions{})
+
+	var got []string
+	for _, event := range merged.Events() {
+		if event.GetProperty(PropertyTravel) == nil {
+			continue
+		}
+		got = append(got, strings.Join([]string{
+			PropertyValue(event, ics.ComponentPropertyUniqueId),
+			PropertyValue(event, ics.ComponentPropertySummary),
+			PropertyValue(event, ics.ComponentPropertyDtStart),
+			PropertyValue(event, ics.ComponentPropertyDtEnd),
+			PropertyValue(event, ics.ComponentPropertyRelatedTo),
+			PropertyValue(event, ics.ComponentPropertyTransp),
+		}, " "))
+	}
+
+	expected := []string{
+		"dentist-travel-before [Home] Travel 20250101T094000 20250101T100000 dentist OPAQUE",
+		"dentist-travel-after [Home] Travel 20250101T110000 20250101T111500 dentist OPAQUE",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected travel buffers:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+// TestMergeCalendarsTransp tests that TRANSP is kept, so free/busy treats
+// transparent events as free time in the merged calendar too
+func TestMergeCalendarsTransp(t *testing.T) {
+	cal := mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:focus
+SUMMARY:Focus time
+TRANSP:TRANSPARENT
+DTSTART:20250106T120000Z
+DTEND:20250106T150000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:meeting
+SUMMARY:Meeting
+TRANSP:OPAQUE
+DTSTART:20250106T160000Z
+DTEND:20250106T170000Z
+END:VEVENT
+END:VCALENDAR
+`)
+	merged := MergeCalendars([]Source{{Name: "Arthur", Calendar: cal, Privacy: PrivacyBusyOnly}}, MergeOptions{})
+
+	transp := make(map[string]string)
+	for _, event := range merged.Events() {
+		transp[event.Id()] = PropertyValue(event, ics.ComponentPropertyTransp)
+	}
+	if transp["focus"] != "TRANSPARENT" || transp["meeting"] != "OPAQUE" {
+		t.Errorf("TRANSP not kept: %v", transp)
+	}
+
+	from := time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC)
+	periods := BusyPeriods(ExpandCalendar(merged, from, from.AddDate(0, 0, 1), time.UTC), from, from.AddDate(0, 0, 1))
+	if len(periods) != 1 || periods[0].Start.Hour() != 16 {
+		t.Errorf("Expected only the meeting to be busy, got %v", periods)
+	}
+}
+
+// TestMergeCalendarsDecoration tests the COLOR, CATEGORIES and
+// X-ICALMERGER-SOURCE properties added for the calendars of an event
+func TestMergeCalendarsDecoration(t *testing.T) {
+	shared := `BEGIN:VEVENT
+UID:party
+SUMMARY:Party
+CATEGORIES:Family,birthday
+DTSTART:20250110T180000Z
+DTEND:20250110T220000Z
+END:VEVENT
+`
+	arthur := mustParse(t, "BEGIN:VCALENDAR\nVERSION:2.0\nPRODID:-//TEST//EN\n"+shared+`BEGIN:VEVENT
+UID:standup
+SUMMARY:Standup
+CATEGORIES:Meetings
+DTSTART:20250106T080000Z
+DTEND:20250106T081500Z
+END:VEVENT
+END:VCALENDAR
+`)
+	hannah := mustParse(t, "BEGIN:VCALENDAR\nVERSION:2.0\nPRODID:-//TEST//EN\n"+shared+"END:VCALENDAR\n")
+	work := mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:review
+SUMMARY:Review
+CATEGORIES:Confidential
+DTSTART:20250107T090000Z
+DTEND:20250107T100000Z
+END:VEVENT
+END:VCALENDAR
+`)
+	merged := MergeCalendars([]Source{
+		{Name: "Arthur", Calendar: arthur, Color: "steelblue", Categories: []string{"family", "Arthur"}},
+		{Name: "Hannah", Calendar: hannah, Color: "tomato", Categories: []string{"Hannah"}},
+		{Name: "Work", Calendar: work, Privacy: PrivacyBusyOnly, Color: "gray", Categories: []string{"Work"}},
+	}, MergeOptions{})
+
+	type decoration struct {
+		color      string
+		categories []string
+		sources    []string
+	}
+	got := make(map[string]decoration)
+	for _, event := range merged.Events() {
+		var d decoration
+		d.color = PropertyValue(event, ics.ComponentPropertyColor)
+		for _, prop := range event.GetProperties(ics.ComponentPropertyCategories) {
+			d.categories = append(d.categories, prop.Value)
+		}
+		for _, prop := range event.GetProperties(PropertySource) {
+			d.sources = append(d.sources, prop.Value)
+		}
+		got[event.Id()] = d
+	}
+
+	want := map[string]decoration{
+		// The first calendar's color wins and categories are deduplicated
+		// case-insensitively with the original ones
+		"party":   {"steelblue", []string{"Family", "birthday", "Arthur", "Hannah"}, []string{"Arthur", "Hannah"}},
+		"standup": {"steelblue", []string{"Meetings", "family", "Arthur"}, []string{"Arthur"}},
+		// Masked events lose their original categories but keep the source ones
+		"review": {"gray", []string{"Work"}, []string{"Work"}},
+	}
+	for uid, w := range want {
+		g := got[uid]
+		if g.color != w.color || strings.Join(g.categories, ",") != strings.Join(w.categories, ",") || strings.Join(g.sources, ",") != strings.Join(w.sources, ",") {
+			t.Errorf("%s: got %+v, want %+v", uid, g, w)
+		}
+	}
+}
+
+// TestMergeCalendarsOverrides tests that moved occurrences keep their
+// RECURRENCE-ID, so they replace their occurrence of the series instead of
+// showing up next to it
+func TestMergeCalendarsOverrides(t *testing.T) {
+	cal := mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:standup
+SUMMARY:Standup
+DTSTART:20250106T080000Z
+DTEND:20250106T081500Z
+RRULE:FREQ=DAILY;COUNT=3
+END:VEVENT
+BEGIN:VEVENT
+UID:standup
+RECURRENCE-ID:20250107T080000Z
+SUMMARY:Late standup
+DTSTART:20250107T100000Z
+DTEND:20250107T101500Z
+END:VEVENT
+END:VCALENDAR
+`)
+	merged := MergeCalendars([]Source{{Name: "Arthur", Calendar: cal}}, MergeOptions{})
+
+	var recurrenceIDs []string
+	for _, event := range merged.Events() {
+		recurrenceIDs = append(recurrenceIDs, PropertyValue(event, ics.ComponentPropertyRecurrenceId))
+	}
+	if strings.Join(recurrenceIDs, ",") != ",20250107T080000Z" {
+		t.Errorf("Unexpected RECURRENCE-IDs %q", recurrenceIDs)
+	}
+
+	from := time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC)
+	var got []string
+	for _, o := range ExpandCalendar(merged, from, from.AddDate(0, 0, 7), time.UTC) {
+		got = append(got, o.Start.Format("Jan 2 15:04")+" "+PropertyValue(o.Event, ics.ComponentPropertySummary))
+	}
+	want := "Jan 6 08:00 [Arthur] Standup, Jan 7 10:00 [Arthur] Late standup, Jan 8 08:00 [Arthur] Standup"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("Unexpected occurrences:\n%s\nwant:\n%s", strings.Join(got, ", "), want)
+	}
+}
+
+// TestMergeCalendarsTravelOverrides tests that the travel buffers of a
+// series skip its moved occurrences, which get buffers of their own or
+// none without a location
+func TestMergeCalendarsTravelOverrides(t *testing.T) {
+	cal := mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:training
+SUMMARY:Training
+LOCATION:Gym
+DTSTART;TZID=Europe/Berlin:20250106T180000
+DTEND;TZID=Europe/Berlin:20250106T190000
+RRULE:FREQ=DAILY;COUNT=3
+END:VEVENT
+BEGIN:VEVENT
+UID:training
+RECURRENCE-ID;TZID=Europe/Berlin:20250107T180000
+SUMMARY:Training
+LOCATION:Gym
+DTSTART;TZID=Europe/Berlin:20250107T200000
+DTEND;TZID=Europe/Berlin:20250107T210000
+END:VEVENT
+BEGIN:VEVENT
+UID:training
+RECURRENCE-ID;TZID=Europe/Berlin:20250108T180000
+SUMMARY:Online training
+DTSTART;TZID=Europe/Berlin:20250108T180000
+DTEND;TZID=Europe/Berlin:20250108T190000
+END:VEVENT
+END:VCALENDAR
+`)
+	travel := &Travel{Before: 30 * time.Minute, After: 15 * time.Minute}
+	merged := MergeCalendars([]Source{{Name: "Hannah", Calendar: cal, Travel: travel}}, MergeOptions{})
+
+	for _, event := range merged.Events() {
+		if PropertyValue(event, ics.ComponentPropertyUniqueId) != "training-travel-before" {
+			continue
+		}
+		var exdates []string
+		for _, prop := range event.GetProperties(ics.ComponentPropertyExdate) {
+			exdates = append(exdates, prop.Value)
+		}
+		if got := strings.Join(exdates, ","); got != "20250107T173000,20250108T173000" {
+			t.Errorf("Unexpected EXDATEs of the series buffer: %s", got)
+		}
+	}
+
+	// The merged times are floating, read in the output timezone
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2025, 1, 6, 0, 0, 0, 0, berlin)
+	var got []string
+	for _, o := range ExpandCalendar(merged, from, from.AddDate(0, 0, 7), berlin) {
+		if side := PropertyValue(o.Event, PropertyTravel); side != "" {
+			got = append(got, o.Start.Format("Jan 2 15:04")+"-"+o.End.Format("15:04")+" "+side)
+		}
+	}
+	want := "Jan 6 17:30-18:00 before, Jan 6 19:00-19:15 after, Jan 7 19:30-20:00 before, Jan 7 21:00-21:15 after"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("Unexpected travel buffers:\n%s\nwant:\n%s", strings.Join(got, ", "), want)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
@@ -40,6 +42,16 @@ func FetchCalendar(source string) (*ics.Calendar, error) {
 		}
 	}
 
+	// jCal sources are JSON arrays, xCal sources XML documents
+	if trimmed := bytes.TrimLeft(calData, "\ufeff \t\r\n"); len(trimmed) > 0 {
+		switch trimmed[0] {
+		case '[':
+			return ParseJCal(bytes.NewReader(trimmed))
+		case '<':
+			return ParseXCal(bytes.NewReader(trimmed))
+		}
+	}
+
 	// Preprocess iCal data to handle Apple Calendar specifics
 	calDataStr := preprocessAppleCalendar(string(calData))
 	
@@ -494,8 +506,114 @@ type Event struct {
 	OriginalEvent *ics.VEvent
 }
 
+// Source is a named calendar taking part in a merge. Sources are merged in
+// the order they are given, which is the order of the configuration file.
+type Source struct {
+	Name     string
+	Calendar *ics.Calendar
+	
+	// SummaryTemplate rewrites the SUMMARY of single-source events,
+	// DefaultSummaryTemplate is used when empty
+	SummaryTemplate string
+	// Initials is the short label used in templates, defaults to the first letter of Name
+	Initials string
+	// DisablePrefix keeps the original SUMMARY for this source's events
+	DisablePrefix bool
+	
+	// Color is emitted as the RFC 7986 COLOR of the source's events
+	Color string
+	// Categories are added to the CATEGORIES of the source's events
+	Categories []string
+	
+	// Privacy masks the details of the source's events
+	Privacy PrivacyLevel
+	
+	// KeepAlarms copies the source's own VALARMs into the merged calendar
+	KeepAlarms bool
+	// Alarms are added to the source's events that have no alarm of their own
+	Alarms []Alarm
+	
+	// Travel adds travel buffers around the source's located events, nil disables them
+	Travel *Travel
+}
+
+// PropertySource names the calendar(s) an event was merged from
+const PropertySource = ics.ComponentProperty("X-ICALMERGER-SOURCE")
+
+// MergeOptions controls how the merged calendar is built
+type MergeOptions struct {
+	// MultiSourceTemplate rewrites the SUMMARY of events found in several
+	// calendars, e.g. "[{{.Initials}}] {{.Summary}}". Empty keeps the original title.
+	MultiSourceTemplate string
+	
+	// Privacy is the minimum privacy level applied to all events
+	Privacy PrivacyLevel
+	
+	// Alarms are added to all events that have no alarm of their own
+	Alarms []Alarm
+	
+	// Cancelled and Tentative control how events with that STATUS are
+	// published, empty means StatusKeep
+	Cancelled StatusPolicy
+	Tentative StatusPolicy
+}
+
+// MergedProperties are the properties of source events that MergeCalendars
+// carries over or reads: CLASS decides the privacy of an event and ATTENDEE
+// is indexed for search (see Attendees). Other properties don't make it into
+// the merged calendar.
+var MergedProperties = []ics.ComponentProperty{
+	ics.ComponentPropertySummary,
+	ics.ComponentPropertyDtStart,
+	ics.ComponentPropertyDtEnd,
+	ics.ComponentPropertyLocation,
+	ics.ComponentPropertyDescription,
+	ics.ComponentPropertyStatus,
+	ics.ComponentPropertyTransp,
+	ics.ComponentPropertyRecurrenceId,
+	ics.ComponentPropertyRrule,
+	ics.ComponentPropertyExdate,
+	ics.ComponentPropertyCategories,
+	ics.ComponentPropertyClass,
+	ics.ComponentPropertyAttendee,
+}
+
+// IsMergedProperty reports whether a property of source events is one of
+// MergedProperties, ignoring case
+func IsMergedProperty(name string) bool {
+	for _, prop := range MergedProperties {
+		if strings.EqualFold(string(prop), strings.TrimSpace(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// InfoEventPrefix starts the UID of the info event written to merged
+// calendars without events. It is not a real event and its UID changes on
+// every merge, so history, search, stats, exports and emails skip it.
+const InfoEventPrefix = "dummy-event-"
+
+// NewInfoEvent creates the info event of a merged calendar without events
+func NewInfoEvent(now time.Time) *ics.VEvent {
+	event := ics.NewEvent(InfoEventPrefix + now.Format("20060102150405"))
+	event.SetProperty(ics.ComponentPropertySummary, "Calendar Merger Info")
+	event.SetProperty(ics.ComponentPropertyDescription, "No valid events were found in any of the source calendars")
+	event.SetProperty(ics.ComponentPropertyDtStart, now.Format("20060102T150405Z"))
+	event.SetProperty(ics.ComponentPropertyDtEnd, now.Add(time.Hour).Format("20060102T150405Z"))
+	// The info event must not block free/busy time
+	event.SetProperty(ics.ComponentPropertyTransp, "TRANSPARENT")
+	return event
+}
+
+// IsInfoEvent reports whether a UID belongs to the info event of a merged
+// calendar without events (see NewInfoEvent)
+func IsInfoEvent(uid string) bool {
+	return strings.HasPrefix(uid, InfoEventPrefix)
+}
+
 // MergeCalendars combines multiple calendars into one, handling duplicates
-func MergeCalendars(calendars map[string]*ics.Calendar) *ics.Calendar {
+func MergeCalendars(sources []Source, opts MergeOptions) *ics.Calendar {
 	merged := ics.NewCalendar()
 	merged.SetMethod(ics.MethodPublish)
 	merged.SetProductId("-//ical_merger//GO")
@@ -504,12 +622,34 @@ func MergeCalendars(calendars map[string]*ics.Calendar) *ics.Calendar {
 	// METHOD is already set above with SetMethod
 	// We're using the golang-ical library, which has limitations with custom properties
 	
+	// Compile the summary templates once for all events
+	summaries := newSummaryRenderer(sources, opts.MultiSourceTemplate)
+	
+	sourcesByName := make(map[string]Source, len(sources))
+	for _, source := range sources {
+		sourcesByName[source.Name] = source
+	}
+	
 	// Track events by a composite key to properly identify duplicates
 	eventMap := make(map[string]*Event)
+	// Remember the order in which keys were first seen so ties stay stable
+	var eventKeys []string
+	// Cancelled occurrences of recurring events by UID, excluded from the series below
+	cancelledOccurrences := make(map[string][]*ics.IANAProperty)
+	// RECURRENCE-IDs of the moved occurrences by UID, their travel buffers are their own
+	overriddenOccurrences := make(map[string][]*ics.IANAProperty)
 	
 	// First pass: identify duplicates by UID + start date
-	for calID, cal := range calendars {
-		for _, event := range cal.Events() {
+	for _, source := range sources {
+		calID := source.Name
+		for _, event := range source.Calendar.Events() {
+			// A cancelled override removes its occurrence instead of showing up itself
+			if isCancelledOverride(event) {
+				uid := PropertyValue(event, ics.ComponentPropertyUniqueId)
+				cancelledOccurrences[uid] = append(cancelledOccurrences[uid], event.GetProperty(ics.ComponentPropertyRecurrenceId))
+				continue
+			}
+			
 			// Get summary (required)
 			summaryProp := event.GetProperty(ics.ComponentPropertySummary)
 			if summaryProp == nil {
@@ -538,8 +678,12 @@ func MergeCalendars(calendars map[string]*ics.Calendar) *ics.Calendar {
 			compositeKey := uid + ":" + dtstart
 			
 			if existing, ok := eventMap[compositeKey]; ok {
-				// This is a duplicate event (same UID and start date), add calendar ID to the list
-				existing.CalendarIDs = append(existing.CalendarIDs, calID)
+				// This is a duplicate event (same UID and start date), add calendar ID to the list.
+				// A calendar that has the event twice is named once, so the
+				// summary doesn't read "[A+A]".
+				if !slices.Contains(existing.CalendarIDs, calID) {
+					existing.CalendarIDs = append(existing.CalendarIDs, calID)
+				}
 			} else {
 				// New event
 				eventMap[compositeKey] = &Event{
@@ -548,12 +692,32 @@ func MergeCalendars(calendars map[string]*ics.Calendar) *ics.Calendar {
 					CalendarIDs:   []string{calID},
 					OriginalEvent: event,
 				}
+				eventKeys = append(eventKeys, compositeKey)
+				if recurrenceID := event.GetProperty(ics.ComponentPropertyRecurrenceId); recurrenceID != nil {
+					overriddenOccurrences[uid] = append(overriddenOccurrences[uid], recurrenceID)
+				}
 			}
 		}
 	}
 	
+	// Sort events by start time, then UID, then RECURRENCE-ID so that the
+	// serialized calendar is identical between syncs
+	events := make([]*Event, 0, len(eventKeys))
+	for _, key := range eventKeys {
+		events = append(events, eventMap[key])
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return compareEvents(events[i].OriginalEvent, events[j].OriginalEvent) < 0
+	})
+	
 	// Second pass: add events to merged calendar with modified summaries if needed
-	for _, event := range eventMap {
+	for _, event := range events {
+		// Drop cancelled or tentative events if the output asks for it
+		policy, marker := statusPolicy(event.OriginalEvent, opts)
+		if policy == StatusDrop {
+			continue
+		}
+		
 		// Create a new event with the same UID
 		newEvent := ics.NewEvent(event.UID)
 		
@@ -576,32 +740,100 @@ func MergeCalendars(calendars map[string]*ics.Calendar) *ics.Calendar {
 		if status := event.OriginalEvent.GetProperty(ics.ComponentPropertyStatus); status != nil {
 			newEvent.SetProperty(ics.ComponentPropertyStatus, status.Value)
 		}
-		
+		if transp := event.OriginalEvent.GetProperty(ics.ComponentPropertyTransp); transp != nil {
+			newEvent.SetProperty(ics.ComponentPropertyTransp, transp.Value)
+		}
+		// Overrides keep their RECURRENCE-ID so they replace their occurrence
+		// instead of showing up next to it
+		if recurrenceID := event.OriginalEvent.GetProperty(ics.ComponentPropertyRecurrenceId); recurrenceID != nil {
+			newEvent.SetProperty(ics.ComponentPropertyRecurrenceId, recurrenceID.Value, propertyParams(recurrenceID)...)
+		}
+
 		// RRULE needs special handling to be properly formatted for Ruby clients
 		if rrule := event.OriginalEvent.GetProperty("RRULE"); rrule != nil {
 			// The RRULE format should be: RRULE:FREQ=WEEKLY;UNTIL=20250617T120000Z;INTERVAL=1;BYDAY=TU;WKST=SU
 			// This format is expected by the Ruby icalendar gem
 			newEvent.SetProperty("RRULE", rrule.Value)
+			
+			// Keep the excluded dates and add the cancelled occurrences
+			for _, exdate := range event.OriginalEvent.GetProperties(ics.ComponentPropertyExdate) {
+				newEvent.AddProperty(ics.ComponentPropertyExdate, exdate.Value, propertyParams(exdate)...)
+			}
+			addExdates(newEvent, cancelledOccurrences[event.UID])
 		}
 		
-		// If the event appears in only one calendar, prepend the calendar name
-		if len(event.CalendarIDs) == 1 {
-			summaryProp := newEvent.GetProperty(ics.ComponentPropertySummary)
-			if summaryProp != nil {
-				originalSummary := summaryProp.Value
-				newSummary := "[" + event.CalendarIDs[0] + "] " + originalSummary
-				
-				// Update the summary with SetProperty
-				newEvent.SetProperty(ics.ComponentPropertySummary, newSummary)
+		// Strip details the output or any of the calendars must not publish
+		privacy := eventPrivacy(event, sourcesByName, opts.Privacy)
+		maskEvent(newEvent, privacy)
+		
+		// Carry over the original categories, then tag the event with its sources
+		decorateEvent(newEvent, event, sourcesByName, privacy == PrivacyFull)
+		
+		// Rewrite the summary with the source template (by default the
+		// calendar name is prepended to events from a single calendar)
+		summaryProp := newEvent.GetProperty(ics.ComponentPropertySummary)
+		if summaryProp != nil {
+			newSummary := summaries.render(summaryProp.Value, event.CalendarIDs)
+			if policy == StatusMark {
+				newSummary = marker + newSummary
 			}
+			
+			// Update the summary with SetProperty
+			newEvent.SetProperty(ics.ComponentPropertySummary, newSummary)
 		}
 		
+		// Add reminders once the final summary is known
+		addAlarms(newEvent, event, sourcesByName, opts.Alarms, privacy)
+		
 		merged.AddVEvent(newEvent)
+		
+		// Block the time needed to get to and from the event
+		for _, buffer := range travelBuffers(newEvent, event, sourcesByName, overriddenOccurrences[event.UID]) {
+			buffer.SetProperty(ics.ComponentPropertySummary, summaries.render(TravelSummary, event.CalendarIDs))
+			merged.AddVEvent(buffer)
+		}
 	}
 	
 	return merged
 }
 
+// decorateEvent adds the CATEGORIES, COLOR and X-ICALMERGER-SOURCE
+// properties for the calendars an event was found in
+func decorateEvent(newEvent *ics.VEvent, event *Event, sourcesByName map[string]Source, keepOriginalCategories bool) {
+	seen := make(map[string]bool)
+	addCategory := func(category string) {
+		category = strings.TrimSpace(category)
+		if category == "" || seen[strings.ToLower(category)] {
+			return
+		}
+		seen[strings.ToLower(category)] = true
+		newEvent.AddProperty(ics.ComponentPropertyCategories, category)
+	}
+	
+	// Original categories are details too, masked events only get the calendar categories
+	if keepOriginalCategories {
+		for _, prop := range event.OriginalEvent.GetProperties(ics.ComponentPropertyCategories) {
+			for _, category := range strings.Split(prop.Value, ",") {
+				addCategory(category)
+			}
+		}
+	}
+	
+	for _, calID := range event.CalendarIDs {
+		source := sourcesByName[calID]
+		for _, category := range source.Categories {
+			addCategory(category)
+		}
+		
+		// The first calendar with a color wins for multi-source events
+		if source.Color != "" && newEvent.GetProperty(ics.ComponentPropertyColor) == nil {
+			newEvent.SetProperty(ics.ComponentPropertyColor, source.Color)
+		}
+		
+		newEvent.AddProperty(PropertySource, calID)
+	}
+}
+
 // ParseCalendar parses an iCalendar string into a calendar object
 func ParseCalendar(reader io.Reader) (*ics.Calendar, error) {
 	return ics.ParseCalendar(reader)
@@ -629,6 +861,14 @@ func FilterCalendarByDateRange(cal *ics.Calendar, daysBack, daysForward int) *ic
 	
 	// Map to collect events by ID to eliminate duplicates
 	eventsByUID := make(map[string]*ics.VEvent)
+	// Keep the input order so filtered output is as stable as the merged file
+	var uidOrder []string
+	storeEvent := func(uid string, event *ics.VEvent) {
+		if _, seen := eventsByUID[uid]; !seen {
+			uidOrder = append(uidOrder, uid)
+		}
+		eventsByUID[uid] = event
+	}
 	
 	// Process each event and determine if it falls within the date range
 	for _, event := range cal.Events() {
@@ -693,7 +933,7 @@ func FilterCalendarByDateRange(cal *ics.Calendar, daysBack, daysForward int) *ic
 				if strings.Contains(dtStartValue, "202503") {
 					log.Printf("Special case: Including event '%s' despite date parsing error", summary)
 					fixEventProperties(event) // Fix any malformed properties
-					eventsByUID[uid] = event  // Store by UID to eliminate duplicates
+					storeEvent(uid, event) // Store by UID to eliminate duplicates
 				}
 			}
 			continue
@@ -719,21 +959,21 @@ func FilterCalendarByDateRange(cal *ics.Calendar, daysBack, daysForward int) *ic
 			// Fix any malformed properties
 			fixEventProperties(event)
 			// Store by UID to eliminate duplicates
-			eventsByUID[uid] = event
+			storeEvent(uid, event)
 		} else {
 			// Special case for March 3, 2025 events that might be missed due to timezone issues
 			targetDay := time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC)
 			if eventDay.Equal(targetDay) {
 				log.Printf("Special handling: Including March 3, 2025 event '%s'", summary)
 				fixEventProperties(event)
-				eventsByUID[uid] = event
+				storeEvent(uid, event)
 			}
 		}
 	}
 	
 	// Add all the filtered events to the calendar
-	for _, event := range eventsByUID {
-		filtered.AddVEvent(event)
+	for _, uid := range uidOrder {
+		filtered.AddVEvent(eventsByUID[uid])
 	}
 	
 	log.Printf("Filtering complete. Calendar contains %d events", len(eventsByUID))
@@ -757,6 +997,69 @@ func parseTimedDate(dateStr string) (time.Time, error) {
 	return time.Time{}, fmt.Errorf("could not parse date: %s", dateStr)
 }
 
+// compareEvents orders two events by start time, then UID, then RECURRENCE-ID.
+// Events whose start cannot be parsed come last, ordered by the raw value,
+// so the order stays consistent when they are mixed with valid events.
+func compareEvents(a, b *ics.VEvent) int {
+	timeA, rawA, okA := sortStart(a)
+	timeB, rawB, okB := sortStart(b)
+	switch {
+	case okA && okB:
+		if timeA.Before(timeB) {
+			return -1
+		}
+		if timeA.After(timeB) {
+			return 1
+		}
+	case okA:
+		return -1
+	case okB:
+		return 1
+	default:
+		if c := strings.Compare(rawA, rawB); c != 0 {
+			return c
+		}
+	}
+	
+	if c := strings.Compare(PropertyValue(a, ics.ComponentPropertyUniqueId), PropertyValue(b, ics.ComponentPropertyUniqueId)); c != 0 {
+		return c
+	}
+	return strings.Compare(PropertyValue(a, ics.ComponentPropertyRecurrenceId), PropertyValue(b, ics.ComponentPropertyRecurrenceId))
+}
+
+// sortStart returns the start time of an event and its raw DTSTART value,
+// ok is false if the event has no DTSTART or it cannot be parsed
+func sortStart(event *ics.VEvent) (start time.Time, raw string, ok bool) {
+	prop := event.GetProperty(ics.ComponentPropertyDtStart)
+	if prop == nil {
+		return time.Time{}, "", false
+	}
+	start, _, err := parseDateProperty(prop, time.UTC)
+	return start, prop.Value, err == nil
+}
+
+// propertyGetter is implemented by all components (events, alarms, ...)
+type propertyGetter interface {
+	GetProperty(ics.ComponentProperty) *ics.IANAProperty
+}
+
+// PropertyValue returns the value of a property or an empty string if it is missing
+func PropertyValue(component propertyGetter, prop ics.ComponentProperty) string {
+	if p := component.GetProperty(prop); p != nil {
+		return p.Value
+	}
+	return ""
+}
+
+// NormalizeCalendar fixes malformed properties of all events in place.
+// Calendars shared between readers are normalized once, so that filtering
+// them later never changes an event while another reader uses it.
+func NormalizeCalendar(cal *ics.Calendar) {
+	for _, event := range cal.Events() {
+		fixEventProperties(event)
+	}
+}
+
 // fixEventProperties corrects common iCal property formatting issues
 func fixEventProperties(event *ics.VEvent) {
 	// Fix DTEND or DTSTART with malformed TZID format
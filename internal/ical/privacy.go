@@ -0,0 +1,140 @@
+package ical
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/arran4/golang-ical"
+)
+
+// PrivacyLevel controls how much of an event is published
+type PrivacyLevel string
+
+const (
+	// PrivacyFull publishes events unchanged
+	PrivacyFull PrivacyLevel = "full"
+	// PrivacyTitleOnly keeps the title and times but strips all details
+	PrivacyTitleOnly PrivacyLevel = "title-only"
+	// PrivacyBusyOnly only shows that the time is taken
+	PrivacyBusyOnly PrivacyLevel = "busy-only"
+)
+
+// BusySummary replaces the title of busy-only events
+const BusySummary = "Busy"
+
+// privacyRank orders the levels from least to most restrictive
+var privacyRank = map[PrivacyLevel]int{
+	PrivacyFull:      0,
+	PrivacyTitleOnly: 1,
+	PrivacyBusyOnly:  2,
+}
+
+// ParsePrivacyLevel parses a privacy level from the config, "" means full
+func ParsePrivacyLevel(value string) (PrivacyLevel, error) {
+	if value == "" {
+		return PrivacyFull, nil
+	}
+	level := PrivacyLevel(strings.ToLower(strings.TrimSpace(value)))
+	if _, ok := privacyRank[level]; !ok {
+		return PrivacyBusyOnly, fmt.Errorf("invalid privacy level %q (use full, title-only or busy-only)", value)
+	}
+	return level, nil
+}
+
+// Stricter returns the more restrictive of two privacy levels
+func (p PrivacyLevel) Stricter(other PrivacyLevel) PrivacyLevel {
+	if privacyRank[other] > privacyRank[p] {
+		return other
+	}
+	return p
+}
+
+// eventPrivacy returns the level to apply to a merged event: the strictest of
+// the output level, the levels of all its calendars and its own CLASS.
+// CLASS:PRIVATE and CLASS:CONFIDENTIAL events are always busy-only.
+func eventPrivacy(event *Event, sourcesByName map[string]Source, outputLevel PrivacyLevel) PrivacyLevel {
+	level := PrivacyFull.Stricter(outputLevel)
+	for _, calID := range event.CalendarIDs {
+		level = level.Stricter(sourcesByName[calID].Privacy)
+	}
+
+	if class := event.OriginalEvent.GetProperty(ics.ComponentPropertyClass); class != nil {
+		switch strings.ToUpper(strings.TrimSpace(class.Value)) {
+		case "PRIVATE", "CONFIDENTIAL":
+			level = level.Stricter(PrivacyBusyOnly)
+		}
+	}
+
+	return level
+}
+
+// maskEvent strips the details of an event according to the privacy level
+func maskEvent(event *ics.VEvent, level PrivacyLevel) {
+	if level == PrivacyFull || level == "" {
+		return
+	}
+
+	for _, prop := range []ics.ComponentProperty{
+		ics.ComponentPropertyDescription,
+		ics.ComponentPropertyLocation,
+		ics.ComponentPropertyAttendee,
+		ics.ComponentPropertyOrganizer,
+		ics.ComponentPropertyUrl,
+		ics.ComponentPropertyGeo,
+		ics.ComponentPropertyComment,
+		ics.ComponentPropertyAttach,
+		ics.ComponentPropertyContact,
+	} {
+		event.RemoveProperty(prop)
+	}
+
+	if level == PrivacyBusyOnly {
+		event.SetProperty(ics.ComponentPropertySummary, BusySummary)
+	}
+}
+
+// EventKey identifies a merged event, or an override of one of its
+// occurrences, by its UID and RECURRENCE-ID as written
+type EventKey struct {
+	UID          string
+	RecurrenceID string
+}
+
+// Attendees collects the ATTENDEE properties of the events of the sources,
+// so the merged events can be searched by who takes part without
+// publishing the attendees in the merged calendar. Events that are not
+// published at full privacy, by the output, any of their calendars or their
+// CLASS, are left out. Attendees found in several calendars are listed once.
+func Attendees(sources []Source, outputLevel PrivacyLevel) map[EventKey][]*ics.IANAProperty {
+	attendees := make(map[EventKey][]*ics.IANAProperty)
+	hidden := make(map[EventKey]bool)
+	seen := make(map[EventKey]map[string]bool)
+	for _, source := range sources {
+		for _, event := range source.Calendar.Events() {
+			key := EventKey{
+				UID:          PropertyValue(event, ics.ComponentPropertyUniqueId),
+				RecurrenceID: PropertyValue(event, ics.ComponentPropertyRecurrenceId),
+			}
+			level := eventPrivacy(&Event{CalendarIDs: []string{source.Name}, OriginalEvent: event},
+				map[string]Source{source.Name: source}, outputLevel)
+			if level != PrivacyFull {
+				hidden[key] = true
+				continue
+			}
+			for _, attendee := range event.GetProperties(ics.ComponentPropertyAttendee) {
+				address := strings.ToLower(attendee.Value)
+				if seen[key] == nil {
+					seen[key] = make(map[string]bool)
+				}
+				if !seen[key][address] {
+					seen[key][address] = true
+					attendees[key] = append(attendees[key], attendee)
+				}
+			}
+		}
+	}
+	for key := range hidden {
+		delete(attendees, key)
+	}
+	return attendees
+}
@@ -0,0 +1,414 @@
+package ical
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arran4/golang-ical"
+)
+
+// Occurrence is a single instance of an event, recurring events have one per repetition
+type Occurrence struct {
+	Event  *ics.VEvent
+	Start  time.Time
+	End    time.Time
+	AllDay bool
+}
+
+// maxRecurrenceIterations stops runaway rules that never produce an instance
+const maxRecurrenceIterations = 100000
+
+// ExpandCalendar returns the occurrences of all events of a calendar that
+// overlap [from, to), ordered by start time. Recurring events are expanded,
+// EXDATEs are skipped and overrides (RECURRENCE-ID) replace their occurrence.
+// Cancelled events and occurrences are left out. Floating times are
+// interpreted in loc.
+func ExpandCalendar(cal *ics.Calendar, from, to time.Time, loc *time.Location) []Occurrence {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	// Overrides replace the occurrence of their series with the same start
+	overridden := make(map[string]bool)
+	for _, event := range cal.Events() {
+		if prop := event.GetProperty(ics.ComponentPropertyRecurrenceId); prop != nil {
+			if recurrenceID, _, err := parseDateProperty(prop, loc); err == nil {
+				overridden[occurrenceKey(PropertyValue(event, ics.ComponentPropertyUniqueId), recurrenceID)] = true
+			}
+		}
+	}
+
+	var occurrences []Occurrence
+	for _, event := range cal.Events() {
+		if strings.EqualFold(PropertyValue(event, ics.ComponentPropertyStatus), "CANCELLED") {
+			continue
+		}
+		uid := PropertyValue(event, ics.ComponentPropertyUniqueId)
+		isOverride := event.GetProperty(ics.ComponentPropertyRecurrenceId) != nil
+
+		for _, occurrence := range ExpandEvent(event, from, to, loc) {
+			if !isOverride && overridden[occurrenceKey(uid, occurrence.Start)] {
+				continue
+			}
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		return occurrences[i].Start.Before(occurrences[j].Start)
+	})
+	return occurrences
+}
+
+// occurrenceKey identifies an occurrence of a series by UID and start
+func occurrenceKey(uid string, start time.Time) string {
+	return uid + "@" + strconv.FormatInt(start.Unix(), 10)
+}
+
+// ExpandEvent returns the occurrences of a single event that overlap
+// [from, to). Events without an RRULE have at most one occurrence.
+func ExpandEvent(event *ics.VEvent, from, to time.Time, loc *time.Location) []Occurrence {
+	start, end, allDay, err := EventTimes(event, loc)
+	if err != nil {
+		return nil
+	}
+	duration := end.Sub(start)
+
+	overlaps := func(s time.Time) bool {
+		return s.Before(to) && s.Add(duration).After(from)
+	}
+
+	rruleProp := event.GetProperty(ics.ComponentPropertyRrule)
+	if rruleProp == nil || event.GetProperty(ics.ComponentPropertyRecurrenceId) != nil {
+		if overlaps(start) {
+			return []Occurrence{{Event: event, Start: start, End: end, AllDay: allDay}}
+		}
+		return nil
+	}
+
+	rule, err := ParseRecurrenceRule(rruleProp.Value, start.Location())
+	if err != nil {
+		// An unreadable rule still shows the first instance
+		if overlaps(start) {
+			return []Occurrence{{Event: event, Start: start, End: end, AllDay: allDay}}
+		}
+		return nil
+	}
+
+	excluded := make(map[int64]bool)
+	for _, prop := range event.GetProperties(ics.ComponentPropertyExdate) {
+		for _, value := range strings.Split(prop.Value, ",") {
+			exdate := &ics.IANAProperty{BaseProperty: ics.BaseProperty{Value: value, ICalParameters: prop.ICalParameters}}
+			if t, _, err := parseDateProperty(exdate, start.Location()); err == nil {
+				excluded[t.Unix()] = true
+			}
+		}
+	}
+
+	var occurrences []Occurrence
+	for _, s := range rule.Starts(start, to) {
+		if excluded[s.Unix()] || !overlaps(s) {
+			continue
+		}
+		occurrences = append(occurrences, Occurrence{Event: event, Start: s, End: s.Add(duration), AllDay: allDay})
+	}
+	return occurrences
+}
+
+// RecurrenceRule is a parsed RRULE. BYHOUR, BYMINUTE, BYSECOND, BYWEEKNO and
+// BYYEARDAY are not supported, instances keep the time of day of DTSTART.
+// Yearly BYDAY rules apply within the BYMONTH months (or the DTSTART month).
+type RecurrenceRule struct {
+	Freq       string
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []int
+	BySetPos   []int
+	WeekStart  time.Weekday
+}
+
+// WeekdayNum is a BYDAY entry like "MO" or "-1SU", N is 0 for every such weekday
+type WeekdayNum struct {
+	N       int
+	Weekday time.Weekday
+}
+
+// weekdayCodes maps the RFC 5545 weekday codes to time.Weekday
+var weekdayCodes = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// ParseRecurrenceRule parses an RRULE value. A floating or date UNTIL is
+// interpreted in loc, the location of DTSTART.
+func ParseRecurrenceRule(value string, loc *time.Location) (*RecurrenceRule, error) {
+	rule := &RecurrenceRule{Interval: 1, WeekStart: time.Monday}
+	for _, part := range strings.Split(value, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, val := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+
+		var err error
+		switch key {
+		case "FREQ":
+			rule.Freq = val
+		case "INTERVAL":
+			rule.Interval, err = strconv.Atoi(val)
+		case "COUNT":
+			rule.Count, err = strconv.Atoi(val)
+		case "UNTIL":
+			until := &ics.IANAProperty{BaseProperty: ics.BaseProperty{Value: val}}
+			rule.Until, _, err = parseDateProperty(until, loc)
+		case "BYDAY":
+			for _, day := range strings.Split(val, ",") {
+				if len(day) < 2 {
+					return nil, fmt.Errorf("invalid BYDAY %q", day)
+				}
+				weekday, ok := weekdayCodes[day[len(day)-2:]]
+				if !ok {
+					return nil, fmt.Errorf("invalid BYDAY %q", day)
+				}
+				n := 0
+				if prefix := day[:len(day)-2]; prefix != "" {
+					if n, err = strconv.Atoi(prefix); err != nil {
+						return nil, fmt.Errorf("invalid BYDAY %q", day)
+					}
+				}
+				rule.ByDay = append(rule.ByDay, WeekdayNum{N: n, Weekday: weekday})
+			}
+		case "BYMONTHDAY":
+			rule.ByMonthDay, err = parseIntList(val)
+		case "BYMONTH":
+			rule.ByMonth, err = parseIntList(val)
+		case "BYSETPOS":
+			rule.BySetPos, err = parseIntList(val)
+		case "WKST":
+			weekday, ok := weekdayCodes[val]
+			if !ok {
+				return nil, fmt.Errorf("invalid WKST %q", val)
+			}
+			rule.WeekStart = weekday
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", key, val, err)
+		}
+	}
+
+	switch rule.Freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	default:
+		return nil, fmt.Errorf("unsupported FREQ %q", rule.Freq)
+	}
+	if rule.Interval < 1 {
+		rule.Interval = 1
+	}
+	return rule, nil
+}
+
+// parseIntList parses a comma separated list of integers
+func parseIntList(value string) ([]int, error) {
+	var result []int
+	for _, part := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+// Starts returns the instance starts of the rule from dtstart (always the
+// first instance) until before. Instances keep the wall clock time of dtstart
+// in its location, so they follow daylight saving time.
+func (r *RecurrenceRule) Starts(dtstart, before time.Time) []time.Time {
+	starts := []time.Time{dtstart}
+	count := 1
+
+	for period := 0; period < maxRecurrenceIterations; period++ {
+		periodStart, candidates := r.periodCandidates(dtstart, period)
+		if !periodStart.Before(before) || (!r.Until.IsZero() && periodStart.After(r.Until)) {
+			break
+		}
+
+		for _, candidate := range candidates {
+			if !candidate.After(dtstart) {
+				continue
+			}
+			if (r.Count > 0 && count >= r.Count) || (!r.Until.IsZero() && candidate.After(r.Until)) {
+				return starts
+			}
+			if !candidate.Before(before) {
+				return starts
+			}
+			starts = append(starts, candidate)
+			count++
+		}
+	}
+	return starts
+}
+
+// periodCandidates returns the start of one period (day, week, month or
+// year) of the rule and the sorted instance starts within it. The first
+// period contains dtstart.
+func (r *RecurrenceRule) periodCandidates(dtstart time.Time, period int) (time.Time, []time.Time) {
+	y, m, d := dtstart.Date()
+	hh, mm, ss := dtstart.Clock()
+	loc := dtstart.Location()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, hh, mm, ss, 0, loc)
+	}
+	step := period * r.Interval
+
+	var periodStart time.Time
+	var days []time.Time
+	switch r.Freq {
+	case "DAILY":
+		day := at(y, m, d+step)
+		periodStart = day
+		if r.matchesMonth(day) && r.matchesMonthDay(day) && r.matchesWeekday(day) {
+			days = append(days, day)
+		}
+	case "WEEKLY":
+		// Start of the week containing dtstart, then move by whole weeks
+		offset := (int(dtstart.Weekday()) - int(r.WeekStart) + 7) % 7
+		weekStart := at(y, m, d-offset+7*step)
+		periodStart = weekStart
+		for i := 0; i < 7; i++ {
+			day := at(weekStart.Year(), weekStart.Month(), weekStart.Day()+i)
+			if len(r.ByDay) == 0 && day.Weekday() != dtstart.Weekday() {
+				continue
+			}
+			if r.matchesWeekday(day) && r.matchesMonth(day) {
+				days = append(days, day)
+			}
+		}
+	case "MONTHLY":
+		first := at(y, m+time.Month(step), 1)
+		periodStart = first
+		if r.matchesMonth(first) {
+			days = r.monthDays(first, d)
+		}
+	case "YEARLY":
+		year := y + step
+		periodStart = at(year, time.January, 1)
+		months := r.ByMonth
+		if len(months) == 0 {
+			months = []int{int(m)}
+		}
+		for _, month := range months {
+			days = append(days, r.monthDays(at(year, time.Month(month), 1), d)...)
+		}
+	}
+
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return periodStart, r.applySetPos(days)
+}
+
+// monthDays returns the instances within the month starting at first. Without
+// BYMONTHDAY and BYDAY the day of month of DTSTART is used, months that are too
+// short are skipped.
+func (r *RecurrenceRule) monthDays(first time.Time, dtstartDay int) []time.Time {
+	daysInMonth := time.Date(first.Year(), first.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	var days []time.Time
+	for day := 1; day <= daysInMonth; day++ {
+		t := first.AddDate(0, 0, day-1)
+		switch {
+		case len(r.ByMonthDay) == 0 && len(r.ByDay) == 0:
+			if day != dtstartDay {
+				continue
+			}
+		case len(r.ByMonthDay) > 0 && !r.matchesMonthDay(t):
+			continue
+		case len(r.ByDay) > 0 && !r.matchesWeekdayInMonth(t, daysInMonth):
+			continue
+		}
+		days = append(days, t)
+	}
+	return days
+}
+
+// matchesMonth checks BYMONTH
+func (r *RecurrenceRule) matchesMonth(t time.Time) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+	for _, month := range r.ByMonth {
+		if time.Month(month) == t.Month() {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesMonthDay checks BYMONTHDAY, negative days count from the end of the month
+func (r *RecurrenceRule) matchesMonthDay(t time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	daysInMonth := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, day := range r.ByMonthDay {
+		if day == t.Day() || (day < 0 && daysInMonth+day+1 == t.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesWeekday checks BYDAY ignoring ordinals
+func (r *RecurrenceRule) matchesWeekday(t time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, day := range r.ByDay {
+		if day.Weekday == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesWeekdayInMonth checks BYDAY including ordinals like 2TU or -1SU
+func (r *RecurrenceRule) matchesWeekdayInMonth(t time.Time, daysInMonth int) bool {
+	for _, day := range r.ByDay {
+		if day.Weekday != t.Weekday() {
+			continue
+		}
+		switch {
+		case day.N == 0:
+			return true
+		case day.N > 0 && (t.Day()-1)/7+1 == day.N:
+			return true
+		case day.N < 0 && (daysInMonth-t.Day())/7+1 == -day.N:
+			return true
+		}
+	}
+	return false
+}
+
+// applySetPos keeps the BYSETPOS positions of the period's instances
+func (r *RecurrenceRule) applySetPos(days []time.Time) []time.Time {
+	if len(r.BySetPos) == 0 {
+		return days
+	}
+	var result []time.Time
+	for _, pos := range r.BySetPos {
+		i := pos - 1
+		if pos < 0 {
+			i = len(days) + pos
+		}
+		if i >= 0 && i < len(days) {
+			result = append(result, days[i])
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Before(result[j]) })
+	return result
+}
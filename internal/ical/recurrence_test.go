@@ -0,0 +1,125 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRecurrenceRuleStarts tests the expansion of common RRULEs
+func TestRecurrenceRuleStarts(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("Timezone data not available: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		rrule    string
+		dtstart  time.Time
+		before   time.Time
+		expected string
+	}{
+		{
+			name:     "daily count",
+			rrule:    "FREQ=DAILY;COUNT=3",
+			dtstart:  time.Date(2025, 1, 30, 9, 0, 0, 0, time.UTC),
+			before:   time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			expected: "2025-01-30 09:00,2025-01-31 09:00,2025-02-01 09:00",
+		},
+		{
+			name:     "weekly by day with interval",
+			rrule:    "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;UNTIL=20250122T235959Z",
+			dtstart:  time.Date(2025, 1, 6, 18, 0, 0, 0, time.UTC),
+			before:   time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			expected: "2025-01-06 18:00,2025-01-08 18:00,2025-01-20 18:00,2025-01-22 18:00",
+		},
+		{
+			name:     "monthly last sunday",
+			rrule:    "FREQ=MONTHLY;BYDAY=-1SU",
+			dtstart:  time.Date(2025, 1, 26, 10, 0, 0, 0, time.UTC),
+			before:   time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC),
+			expected: "2025-01-26 10:00,2025-02-23 10:00,2025-03-30 10:00",
+		},
+		{
+			name:     "monthly skips short months",
+			rrule:    "FREQ=MONTHLY;COUNT=3",
+			dtstart:  time.Date(2025, 1, 31, 8, 0, 0, 0, time.UTC),
+			before:   time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			expected: "2025-01-31 08:00,2025-03-31 08:00,2025-05-31 08:00",
+		},
+		{
+			name:     "yearly by month",
+			rrule:    "FREQ=YEARLY;BYMONTH=3,9",
+			dtstart:  time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+			before:   time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC),
+			expected: "2025-03-01 12:00,2025-09-01 12:00,2026-03-01 12:00",
+		},
+		{
+			name:     "daily keeps wall clock over DST",
+			rrule:    "FREQ=DAILY;COUNT=2",
+			dtstart:  time.Date(2025, 3, 29, 9, 0, 0, 0, berlin),
+			before:   time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			expected: "2025-03-29 09:00,2025-03-30 09:00",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := ParseRecurrenceRule(test.rrule, test.dtstart.Location())
+			if err != nil {
+				t.Fatalf("Failed to parse rule: %v", err)
+			}
+			var got []string
+			for _, start := range rule.Starts(test.dtstart, test.before) {
+				got = append(got, start.Format("2006-01-02 15:04"))
+			}
+			if strings.Join(got, ",") != test.expected {
+				t.Errorf("Unexpected starts: got %s, want %s", strings.Join(got, ","), test.expected)
+			}
+		})
+	}
+}
+
+// TestExpandCalendarOverrides tests EXDATEs, moved and cancelled occurrences
+func TestExpandCalendarOverrides(t *testing.T) {
+	cal := mustParse(t, `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:standup
+SUMMARY:Standup
+DTSTART:20250106T090000Z
+DTEND:20250106T091500Z
+RRULE:FREQ=DAILY;COUNT=5
+EXDATE:20250107T090000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:standup
+RECURRENCE-ID:20250108T090000Z
+SUMMARY:Standup
+DTSTART:20250108T100000Z
+DTEND:20250108T101500Z
+END:VEVENT
+BEGIN:VEVENT
+UID:standup
+RECURRENCE-ID:20250109T090000Z
+SUMMARY:Standup
+STATUS:CANCELLED
+DTSTART:20250109T090000Z
+END:VEVENT
+END:VCALENDAR
+`)
+
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	var got []string
+	for _, occurrence := range ExpandCalendar(cal, from, to, time.UTC) {
+		got = append(got, occurrence.Start.Format("02 15:04")+"-"+occurrence.End.Format("15:04"))
+	}
+
+	expected := "06 09:00-09:15,08 10:00-10:15,10 09:00-09:15"
+	if strings.Join(got, ",") != expected {
+		t.Errorf("Unexpected occurrences: got %s, want %s", strings.Join(got, ","), expected)
+	}
+}
@@ -3,12 +3,14 @@ package ical
 import (
 	"fmt"
 	"strings"
+	"unicode/utf8"
 )
 
 // RubyCompatibilityFixer ensures the calendar output is compatible with the Ruby iCalendar parser
 func RubyCompatibilityFixer(icalData string, timezone string) string {
-	// 1. Normalize line endings
-	lines := strings.Split(strings.ReplaceAll(strings.ReplaceAll(icalData, "\r\n", "\n"), "\r", "\n"), "\n")
+	// 1. Normalize line endings and unfold continuation lines, so folded
+	// properties stay together while events are rebuilt
+	lines := unfoldLines(strings.Split(strings.ReplaceAll(strings.ReplaceAll(icalData, "\r\n", "\n"), "\r", "\n"), "\n"))
 	
 	// 2. Initialize a new calendar with all required elements
 	var output []string
@@ -65,7 +67,42 @@ func RubyCompatibilityFixer(icalData string, timezone string) string {
 	// 5. End the calendar
 	output = append(output, "END:VCALENDAR")
 	
-	return strings.Join(output, "\n")
+	// 6. Fold long lines again as required by RFC 5545
+	var folded []string
+	for _, line := range output {
+		folded = append(folded, foldLine(line)...)
+	}
+	
+	return strings.Join(folded, "\n")
+}
+
+// unfoldLines joins continuation lines (starting with a space or tab) to the previous line
+func unfoldLines(lines []string) []string {
+	var unfolded []string
+	for _, line := range lines {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(unfolded) > 0 {
+			unfolded[len(unfolded)-1] += line[1:]
+			continue
+		}
+		unfolded = append(unfolded, line)
+	}
+	return unfolded
+}
+
+// foldLine splits a line into chunks of at most 75 octets, without breaking
+// UTF-8 characters. Continuation lines start with a space.
+func foldLine(line string) []string {
+	const maxOctets = 75
+	var lines []string
+	for len(line) > maxOctets {
+		cut := maxOctets
+		for cut > 1 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		lines = append(lines, line[:cut])
+		line = " " + line[cut:]
+	}
+	return append(lines, line)
 }
 
 // fixEvent processes a single event and returns it in Ruby-compatible format
@@ -84,7 +121,21 @@ func fixEvent(event []string, timezone string) []string {
 	// Other properties to keep
 	var otherProps []string
 	
-	for _, line := range event {
+	// Nested components like VALARM are kept verbatim
+	var nested []string
+	nestedDepth := 0
+	
+	for _, line := range event[1:] {
+		if nestedDepth > 0 || (strings.HasPrefix(line, "BEGIN:") && line != "BEGIN:VEVENT") {
+			if strings.HasPrefix(line, "BEGIN:") {
+				nestedDepth++
+			} else if strings.HasPrefix(line, "END:") {
+				nestedDepth--
+			}
+			nested = append(nested, line)
+			continue
+		}
+		
 		if strings.HasPrefix(line, "UID:") {
 			uid = strings.TrimPrefix(line, "UID:")
 		} else if strings.HasPrefix(line, "SUMMARY:") {
@@ -213,6 +264,9 @@ func fixEvent(event []string, timezone string) []string {
 	// Add other properties
 	fixedEvent = append(fixedEvent, otherProps...)
 	
+	// Add nested components (alarms) last
+	fixedEvent = append(fixedEvent, nested...)
+	
 	fixedEvent = append(fixedEvent, "END:VEVENT")
 	return fixedEvent
 }
\ No newline at end of file
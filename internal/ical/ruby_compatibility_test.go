@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
 // TestRubyCompatibilityFixer tests that the RubyCompatibilityFixer function produces
@@ -112,4 +113,140 @@ end
 	if !strings.Contains(string(output), "OK") {
 		t.Errorf("Validation output does not contain OK: %s", output)
 	}
-}
\ No newline at end of file
+}
+
+// TestRubyCompatibilityFixerUnchanged tests that feeds without folded or
+// long lines are fixed exactly as before lines were unfolded and folded
+func TestRubyCompatibilityFixerUnchanged(t *testing.T) {
+	input := `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//ical_merger//GO
+METHOD:PUBLISH
+BEGIN:VEVENT
+UID:standup
+SUMMARY:[Arthur] Standup
+DTSTART:20250106T080000
+DTEND:20250106T081500
+LOCATION:Office
+RRULE:FREQ=WEEKLY;UNTIL=20250331T080000Z;BYDAY=MO,WE,FR
+EXDATE;TZID=Europe/Berlin:20250108T080000
+CATEGORIES:Work
+X-ICALMERGER-SOURCE:Arthur
+COLOR:steelblue
+END:VEVENT
+BEGIN:VEVENT
+UID:holiday
+SUMMARY:[Hannah] Holiday
+DTSTART;VALUE=DATE:20250110
+DTEND;VALUE=DATE:20250112
+DESCRIPTION:Pack the bags
+END:VEVENT
+BEGIN:VEVENT
+UID:dentist
+SUMMARY:[A+H] Dentist
+DTSTART;TZID=Europe/Berlin:20250107T093000
+DTEND:20250107T101500Z
+STATUS:TENTATIVE
+END:VEVENT
+END:VCALENDAR`
+	// The output of the fixer before folding was added
+	expected := `BEGIN:VCALENDAR
+VERSION:2.0
+CALSCALE:GREGORIAN
+METHOD:PUBLISH
+PRODID:-//ical_merger//RUBY_COMPAT//EN
+X-WR-CALNAME:Merged Calendar
+X-WR-TIMEZONE:Europe/Berlin
+BEGIN:VTIMEZONE
+TZID:Europe/Berlin
+BEGIN:STANDARD
+DTSTART:19701101T030000
+TZOFFSETFROM:+0200
+TZOFFSETTO:+0100
+RRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU
+END:STANDARD
+BEGIN:DAYLIGHT
+DTSTART:19700329T020000
+TZOFFSETFROM:+0100
+TZOFFSETTO:+0200
+RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU
+END:DAYLIGHT
+END:VTIMEZONE
+BEGIN:VEVENT
+UID:standup
+SUMMARY:[Arthur] Standup
+DTSTART;TZID=Europe/Berlin:20250106T080000
+DTEND;TZID=Europe/Berlin:20250106T081500
+RRULE:FREQ=WEEKLY;UNTIL=20250331T080000Z;BYDAY=MO,WE,FR
+LOCATION:Office
+EXDATE;TZID=Europe/Berlin:20250108T080000
+CATEGORIES:Work
+X-ICALMERGER-SOURCE:Arthur
+COLOR:steelblue
+END:VEVENT
+BEGIN:VEVENT
+UID:holiday
+SUMMARY:[Hannah] Holiday
+DTSTART;VALUE=DATE:20250110
+DTEND;VALUE=DATE:20250112
+DESCRIPTION:Pack the bags
+END:VEVENT
+BEGIN:VEVENT
+UID:dentist
+SUMMARY:[A+H] Dentist
+DTSTART;TZID=Europe/Berlin:20250107T093000
+DTEND;TZID=Europe/Berlin:20250107T101500Z
+STATUS:TENTATIVE
+END:VEVENT
+END:VCALENDAR`
+	if got := RubyCompatibilityFixer(input, "Europe/Berlin"); got != expected {
+		t.Errorf("Unexpected output:\n%s\nwant:\n%s", got, expected)
+	}
+}
+
+// TestRubyCompatibilityFixerFolding tests that folded input lines are kept
+// together and long lines are folded at 75 octets without splitting
+// multi-byte characters
+func TestRubyCompatibilityFixerFolding(t *testing.T) {
+	description := "Elternabend der Klasse 4b: Bitte bringt die unterschriebenen Formulare für " +
+		"die Klassenfahrt nach Österreich mit 🚌🏔️ – Rückfragen gern an Frau Müller-Lüdenscheidt"
+	location := "Grundschule am Rosengarten, Aula (Erdgeschoss, Eingang über den Schulhof)"
+	input := "BEGIN:VCALENDAR\nVERSION:2.0\nPRODID:-//ical_merger//GO\nBEGIN:VEVENT\nUID:parent-evening\n" +
+		"SUMMARY:[A+H] Elternabend\nDTSTART:20250312T190000\nDTEND:20250312T203000\n" +
+		// The source folded LOCATION in the middle of a word
+		"LOCATION:" + location[:40] + "\r\n " + location[40:] + "\n" +
+		"DESCRIPTION:" + description + "\nEND:VEVENT\nEND:VCALENDAR"
+
+	output := RubyCompatibilityFixer(input, "Europe/Berlin")
+	lines := strings.Split(output, "\n")
+	for _, line := range lines {
+		if len(line) > 75 {
+			t.Errorf("Line longer than 75 octets (%d): %q", len(line), line)
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("Line splits a multi-byte character: %q", line)
+		}
+	}
+
+	// Unfolding gives back the properties
+	unfolded := unfoldLines(lines)
+	for _, want := range []string{"LOCATION:" + location, "DESCRIPTION:" + description} {
+		found := false
+		for _, line := range unfolded {
+			if line == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected %q in the unfolded output:\n%s", want, output)
+		}
+	}
+
+	// Lines of exactly 75 octets are not folded, the next octet is
+	if got := foldLine(strings.Repeat("a", 75)); len(got) != 1 {
+		t.Errorf("Expected a 75 octet line to stay whole, got %q", got)
+	}
+	if got := foldLine(strings.Repeat("a", 74) + "ä"); len(got) != 2 || got[0] != strings.Repeat("a", 74) || got[1] != " ä" {
+		t.Errorf("Expected the 2-octet character on the continuation line, got %q", got)
+	}
+}
@@ -0,0 +1,91 @@
+package ical
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/arran4/golang-ical"
+)
+
+// StatusPolicy controls how events with a CANCELLED or TENTATIVE status are published
+type StatusPolicy string
+
+const (
+	// StatusKeep publishes the events unchanged
+	StatusKeep StatusPolicy = "keep"
+	// StatusDrop leaves the events out of the merged calendar
+	StatusDrop StatusPolicy = "drop"
+	// StatusMark prefixes the title with a marker
+	StatusMark StatusPolicy = "mark"
+)
+
+const (
+	// CancelledMarker is prepended to the title of marked cancelled events
+	CancelledMarker = "✕ "
+	// TentativeMarker is prepended to the title of marked tentative events
+	TentativeMarker = "? "
+)
+
+// ParseStatusPolicy parses a status policy from the config, "" means keep
+func ParseStatusPolicy(value string) (StatusPolicy, error) {
+	if value == "" {
+		return StatusKeep, nil
+	}
+	policy := StatusPolicy(strings.ToLower(strings.TrimSpace(value)))
+	switch policy {
+	case StatusKeep, StatusDrop, StatusMark:
+		return policy, nil
+	}
+	return StatusKeep, fmt.Errorf("invalid status policy %q (use keep, drop or mark)", value)
+}
+
+// statusPolicy returns the policy for an event's STATUS and the marker to use
+func statusPolicy(event *ics.VEvent, opts MergeOptions) (StatusPolicy, string) {
+	switch strings.ToUpper(PropertyValue(event, ics.ComponentPropertyStatus)) {
+	case "CANCELLED":
+		return opts.Cancelled, CancelledMarker
+	case "TENTATIVE":
+		return opts.Tentative, TentativeMarker
+	}
+	return StatusKeep, ""
+}
+
+// isCancelledOverride reports whether an event cancels a single occurrence of a recurring event
+func isCancelledOverride(event *ics.VEvent) bool {
+	return event.GetProperty(ics.ComponentPropertyRecurrenceId) != nil &&
+		strings.EqualFold(PropertyValue(event, ics.ComponentPropertyStatus), "CANCELLED")
+}
+
+// addExdates excludes the given occurrences from a recurring event, skipping
+// dates the event already excludes
+func addExdates(newEvent *ics.VEvent, exdates []*ics.IANAProperty) {
+	seen := make(map[string]bool)
+	for _, prop := range newEvent.GetProperties(ics.ComponentPropertyExdate) {
+		for _, value := range strings.Split(prop.Value, ",") {
+			seen[value] = true
+		}
+	}
+	for _, exdate := range exdates {
+		if seen[exdate.Value] {
+			continue
+		}
+		seen[exdate.Value] = true
+		newEvent.AddProperty(ics.ComponentPropertyExdate, exdate.Value, propertyParams(exdate)...)
+	}
+}
+
+// propertyParams returns the parameters of a property in a stable order
+func propertyParams(prop *ics.IANAProperty) []ics.PropertyParameter {
+	keys := make([]string, 0, len(prop.ICalParameters))
+	for key := range prop.ICalParameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	params := make([]ics.PropertyParameter, 0, len(keys))
+	for _, key := range keys {
+		params = append(params, &ics.KeyValues{Key: key, Value: prop.ICalParameters[key]})
+	}
+	return params
+}
@@ -0,0 +1,151 @@
+package ical
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// DefaultSummaryTemplate reproduces the classic "[Calendar] Summary" prefix
+const DefaultSummaryTemplate = "[{{.Calendar}}] {{.Summary}}"
+
+// SummaryData is the data available to summary templates.
+// For events found in several calendars, Calendar and Initials are the
+// joined values of all sources, e.g. "Arthur+Hannah" and "A+H".
+type SummaryData struct {
+	Summary   string
+	Calendar  string
+	Initials  string
+	Calendars []string
+}
+
+// summaryRenderer rewrites event summaries according to the source templates
+type summaryRenderer struct {
+	single   map[string]*template.Template
+	initials map[string]string
+	multi    *template.Template
+}
+
+// ParseSummaryTemplate compiles the summary template of a calendar and
+// tries it on the title of an event, returning an error if it is invalid
+// or fails to render, e.g. because it uses an unknown field
+func ParseSummaryTemplate(name, text string) (*template.Template, error) {
+	return parseSummaryTemplate(name, text, SummaryData{
+		Summary:   "Dentist",
+		Calendar:  "Arthur",
+		Initials:  "A",
+		Calendars: []string{"Arthur"},
+	})
+}
+
+// ParseMultiSourceTemplate is ParseSummaryTemplate for the template of
+// events found in several calendars
+func ParseMultiSourceTemplate(name, text string) (*template.Template, error) {
+	return parseSummaryTemplate(name, text, SummaryData{
+		Summary:   "Parent evening",
+		Calendar:  "Arthur+Hannah",
+		Initials:  "A+H",
+		Calendars: []string{"Arthur", "Hannah"},
+	})
+}
+
+// parseSummaryTemplate compiles a template and renders it with sample data
+func parseSummaryTemplate(name, text string, sample SummaryData) (*template.Template, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(io.Discard, sample); err != nil {
+		return nil, fmt.Errorf("template fails to render: %w", err)
+	}
+	return tmpl, nil
+}
+
+// Initials returns the default initials for a calendar name (its first letter)
+func Initials(name string) string {
+	for _, r := range name {
+		return string(unicode.ToUpper(r))
+	}
+	return ""
+}
+
+// newSummaryRenderer compiles the templates for all sources. Invalid templates
+// are logged and replaced by the default so a bad config never breaks a merge.
+func newSummaryRenderer(sources []Source, multiTemplate string) *summaryRenderer {
+	renderer := &summaryRenderer{
+		single:   make(map[string]*template.Template),
+		initials: make(map[string]string),
+	}
+	defaultTmpl := template.Must(ParseSummaryTemplate("default", DefaultSummaryTemplate))
+
+	for _, source := range sources {
+		initials := source.Initials
+		if initials == "" {
+			initials = Initials(source.Name)
+		}
+		renderer.initials[source.Name] = initials
+
+		if source.DisablePrefix {
+			continue
+		}
+
+		tmpl := defaultTmpl
+		if source.SummaryTemplate != "" {
+			parsed, err := ParseSummaryTemplate(source.Name, source.SummaryTemplate)
+			if err != nil {
+				log.Printf("Invalid summary template for calendar %s, using default: %v", source.Name, err)
+			} else {
+				tmpl = parsed
+			}
+		}
+		renderer.single[source.Name] = tmpl
+	}
+
+	if multiTemplate != "" {
+		parsed, err := ParseMultiSourceTemplate("multi-source", multiTemplate)
+		if err != nil {
+			log.Printf("Invalid multi-source summary template, keeping original titles: %v", err)
+		} else {
+			renderer.multi = parsed
+		}
+	}
+
+	return renderer
+}
+
+// render returns the summary to use for an event found in the given calendars
+func (r *summaryRenderer) render(summary string, calendarIDs []string) string {
+	var tmpl *template.Template
+	if len(calendarIDs) == 1 {
+		tmpl = r.single[calendarIDs[0]]
+	} else {
+		tmpl = r.multi
+	}
+
+	// No template means the title is kept as-is
+	if tmpl == nil {
+		return summary
+	}
+
+	initials := make([]string, 0, len(calendarIDs))
+	for _, id := range calendarIDs {
+		initials = append(initials, r.initials[id])
+	}
+
+	data := SummaryData{
+		Summary:   summary,
+		Calendar:  strings.Join(calendarIDs, "+"),
+		Initials:  strings.Join(initials, "+"),
+		Calendars: calendarIDs,
+	}
+
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, data); err != nil {
+		log.Printf("Error rendering summary template for %q: %v", summary, err)
+		return summary
+	}
+	return buf.String()
+}
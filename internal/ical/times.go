@@ -0,0 +1,110 @@
+package ical
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arran4/golang-ical"
+)
+
+// parseDateProperty parses a DTSTART/DTEND style property, honouring the
+// VALUE=DATE and TZID parameters. Floating times and dates are interpreted
+// in loc.
+func parseDateProperty(prop *ics.IANAProperty, loc *time.Location) (time.Time, bool, error) {
+	value := prop.Value
+	allDay := !strings.Contains(value, "T")
+	if values, ok := prop.ICalParameters["VALUE"]; ok && len(values) > 0 && values[0] == "DATE" {
+		allDay = true
+	}
+
+	if allDay {
+		t, err := time.ParseInLocation("20060102", value, loc)
+		return t, true, err
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+
+	if tzids, ok := prop.ICalParameters["TZID"]; ok && len(tzids) > 0 {
+		if tzLoc, err := time.LoadLocation(tzids[0]); err == nil {
+			loc = tzLoc
+		}
+	}
+
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	if err != nil {
+		// Fall back to the more lenient formats used elsewhere
+		t, err = parseTimedDate(value)
+	}
+	return t, false, err
+}
+
+// EventTimes returns the start and end of an event. The end is taken from
+// DTEND or DURATION; without either, all-day events last one day and timed
+// events one hour. Floating times are interpreted in loc.
+func EventTimes(event *ics.VEvent, loc *time.Location) (start, end time.Time, allDay bool, err error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	startProp := event.GetProperty(ics.ComponentPropertyDtStart)
+	if startProp == nil {
+		return start, end, false, fmt.Errorf("event has no DTSTART")
+	}
+
+	start, allDay, err = parseDateProperty(startProp, loc)
+	if err != nil {
+		return start, end, allDay, err
+	}
+
+	if endProp := event.GetProperty(ics.ComponentPropertyDtEnd); endProp != nil {
+		if end, _, err = parseDateProperty(endProp, loc); err == nil && !end.Before(start) {
+			return start, end, allDay, nil
+		}
+	}
+
+	if durProp := event.GetProperty(ics.ComponentPropertyDuration); durProp != nil {
+		if d, err := ParseDuration(durProp.Value); err == nil && d >= 0 {
+			return start, start.Add(d), allDay, nil
+		}
+	}
+
+	if allDay {
+		return start, start.AddDate(0, 0, 1), true, nil
+	}
+	return start, start.Add(time.Hour), false, nil
+}
+
+// durationPattern matches RFC 5545 durations like PT1H30M, -PT15M or P1W
+var durationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// ParseDuration parses an RFC 5545 DURATION value such as "PT1H30M" or "-P1D"
+func ParseDuration(value string) (time.Duration, error) {
+	matches := durationPattern.FindStringSubmatch(strings.TrimSpace(value))
+	if matches == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return 0, fmt.Errorf("could not parse duration: %s", value)
+	}
+
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var d time.Duration
+	for i, unit := range units {
+		if matches[i+2] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(matches[i+2])
+		if err != nil {
+			return 0, fmt.Errorf("could not parse duration: %s", value)
+		}
+		d += time.Duration(n) * unit
+	}
+
+	if matches[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
@@ -0,0 +1,148 @@
+package ical
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/arran4/golang-ical"
+)
+
+// PropertyTravel marks generated travel buffers with "before" or "after"
+const PropertyTravel = ics.ComponentProperty("X-ICALMERGER-TRAVEL")
+
+// TravelSummary is the title of generated travel buffers
+const TravelSummary = "Travel"
+
+// Travel configures the travel buffers generated around a source's located events
+type Travel struct {
+	// Before and After are the default buffer lengths, zero disables that side
+	Before time.Duration
+	After  time.Duration
+	// Locations override the defaults for matching locations, first match wins
+	Locations []TravelLocation
+}
+
+// TravelLocation sets the buffer lengths for locations matching Pattern
+type TravelLocation struct {
+	Pattern *regexp.Regexp
+	Before  time.Duration
+	After   time.Duration
+}
+
+// durations returns the buffer lengths for a location
+func (t *Travel) durations(location string) (before, after time.Duration) {
+	for _, rule := range t.Locations {
+		if rule.Pattern.MatchString(location) {
+			return rule.Before, rule.After
+		}
+	}
+	return t.Before, t.After
+}
+
+// travelBuffers returns the travel buffers of a merged event, using the
+// settings of the first of its calendars that has any. All-day and cancelled
+// events and events without a location get no buffers. The buffers of a
+// series skip the overridden occurrences, which get buffers of their own.
+func travelBuffers(newEvent *ics.VEvent, event *Event, sourcesByName map[string]Source, overrides []*ics.IANAProperty) []*ics.VEvent {
+	var travel *Travel
+	for _, calID := range event.CalendarIDs {
+		if travel = sourcesByName[calID].Travel; travel != nil {
+			break
+		}
+	}
+	location := strings.TrimSpace(PropertyValue(event.OriginalEvent, ics.ComponentPropertyLocation))
+	if travel == nil || location == "" || strings.EqualFold(PropertyValue(event.OriginalEvent, ics.ComponentPropertyStatus), "CANCELLED") {
+		return nil
+	}
+
+	start, end, allDay, err := EventTimes(event.OriginalEvent, time.UTC)
+	if err != nil || allDay {
+		return nil
+	}
+
+	before, after := travel.durations(location)
+	var buffers []*ics.VEvent
+	if before > 0 {
+		buffers = append(buffers, travelBuffer(newEvent, event, overrides, "before", start.Add(-before), start, -before))
+	}
+	if after > 0 {
+		buffers = append(buffers, travelBuffer(newEvent, event, overrides, "after", end, end.Add(after), end.Sub(start)))
+	}
+	return buffers
+}
+
+// travelBuffer builds one buffer event linked to the original via RELATED-TO.
+// offset moves the excluded dates and the overridden occurrences of
+// recurring events to the buffer's start.
+func travelBuffer(newEvent *ics.VEvent, event *Event, overrides []*ics.IANAProperty, side string, start, end time.Time, offset time.Duration) *ics.VEvent {
+	uid := event.UID + "-travel-" + side
+	// Moved occurrences need their own buffer UID, they are separate events here
+	if recurrenceID := PropertyValue(event.OriginalEvent, ics.ComponentPropertyRecurrenceId); recurrenceID != "" {
+		uid += "-" + recurrenceID
+	}
+
+	buffer := ics.NewEvent(uid)
+	buffer.SetProperty(ics.ComponentPropertySummary, TravelSummary)
+	// Write the times the way the event does, DTSTART is copied without its TZID
+	dtstart := event.OriginalEvent.GetProperty(ics.ComponentPropertyDtStart)
+	buffer.SetProperty(ics.ComponentPropertyDtStart, formatDateLike(dtstart, start))
+	buffer.SetProperty(ics.ComponentPropertyDtEnd, formatDateLike(dtstart, end))
+	buffer.SetProperty(ics.ComponentPropertyTransp, "OPAQUE")
+	buffer.SetProperty(ics.ComponentPropertyRelatedTo, event.UID)
+	buffer.SetProperty(PropertyTravel, side)
+	buffer.AddProperty(ics.ComponentPropertyCategories, TravelSummary)
+
+	// Recurring events get recurring buffers
+	if rrule := newEvent.GetProperty(ics.ComponentPropertyRrule); rrule != nil {
+		buffer.SetProperty(ics.ComponentPropertyRrule, rrule.Value)
+		seen := make(map[string]bool)
+		excludeOccurrence := func(prop *ics.IANAProperty, params []ics.PropertyParameter) {
+			excluded, _, err := parseDateProperty(prop, time.UTC)
+			if err != nil {
+				return
+			}
+			value := formatDateLike(prop, excluded.Add(offset))
+			if !seen[value] {
+				seen[value] = true
+				buffer.AddProperty(ics.ComponentPropertyExdate, value, params...)
+			}
+		}
+		for _, exdate := range newEvent.GetProperties(ics.ComponentPropertyExdate) {
+			for _, value := range strings.Split(exdate.Value, ",") {
+				excludeOccurrence(&ics.IANAProperty{BaseProperty: ics.BaseProperty{Value: value, ICalParameters: exdate.ICalParameters}}, propertyParams(exdate))
+			}
+		}
+		// A moved occurrence must not keep a buffer at its original time
+		for _, recurrenceID := range overrides {
+			var params []ics.PropertyParameter
+			if tzids, ok := recurrenceID.ICalParameters["TZID"]; ok {
+				params = append(params, &ics.KeyValues{Key: "TZID", Value: tzids})
+			}
+			excludeOccurrence(recurrenceID, params)
+		}
+	}
+
+	// Tag the buffer with the same calendars and color as the event
+	for _, prop := range newEvent.GetProperties(PropertySource) {
+		buffer.AddProperty(PropertySource, prop.Value)
+	}
+	if color := newEvent.GetProperty(ics.ComponentPropertyColor); color != nil {
+		buffer.SetProperty(ics.ComponentPropertyColor, color.Value)
+	}
+	return buffer
+}
+
+// formatDateLike formats t like the timed value of prop: in UTC with a Z
+// suffix, in the TZID of prop, or as a floating time (which is read as UTC)
+func formatDateLike(prop *ics.IANAProperty, t time.Time) string {
+	if strings.HasSuffix(prop.Value, "Z") {
+		return t.UTC().Format("20060102T150405Z")
+	}
+	if tzids, ok := prop.ICalParameters["TZID"]; ok && len(tzids) > 0 {
+		if loc, err := time.LoadLocation(tzids[0]); err == nil {
+			return t.In(loc).Format("20060102T150405")
+		}
+	}
+	return t.UTC().Format("20060102T150405")
+}
@@ -0,0 +1,290 @@
+package ical
+
+import (
+	"strings"
+
+	"github.com/arran4/golang-ical"
+)
+
+// valueTypes are the default value types of properties (RFC 5545 section
+// 3.8, RFC 7986), used by the jCal and xCal representations
+var valueTypes = map[string]string{
+	"DTSTART": "date-time", "DTEND": "date-time", "DUE": "date-time", "RECURRENCE-ID": "date-time",
+	"EXDATE": "date-time", "RDATE": "date-time", "CREATED": "date-time", "DTSTAMP": "date-time",
+	"LAST-MODIFIED": "date-time", "COMPLETED": "date-time", "ACKNOWLEDGED": "date-time",
+	"TRIGGER": "duration", "DURATION": "duration", "REFRESH-INTERVAL": "duration",
+	"RRULE": "recur", "EXRULE": "recur",
+	"FREEBUSY":     "period",
+	"TZOFFSETFROM": "utc-offset", "TZOFFSETTO": "utc-offset",
+	"ATTENDEE": "cal-address", "ORGANIZER": "cal-address",
+	"URL": "uri", "TZURL": "uri", "ATTACH": "uri", "IMAGE": "uri", "CONFERENCE": "uri", "SOURCE": "uri",
+	"SEQUENCE": "integer", "PRIORITY": "integer", "PERCENT-COMPLETE": "integer", "REPEAT": "integer",
+	"GEO":     "float",
+	"SUMMARY": "text", "DESCRIPTION": "text", "LOCATION": "text", "UID": "text", "STATUS": "text",
+	"CLASS": "text", "TRANSP": "text", "CATEGORIES": "text", "COMMENT": "text", "CONTACT": "text",
+	"RELATED-TO": "text", "RESOURCES": "text", "TZID": "text", "TZNAME": "text", "ACTION": "text",
+	"PRODID": "text", "VERSION": "text", "CALSCALE": "text", "METHOD": "text", "COLOR": "text",
+	"NAME": "text", "REQUEST-STATUS": "text",
+}
+
+// listProperties hold comma-separated lists of their value type
+var listProperties = map[string]bool{
+	"CATEGORIES": true, "RESOURCES": true, "EXDATE": true, "RDATE": true, "FREEBUSY": true,
+}
+
+// recurIntegers are the RRULE parts with integer values
+var recurIntegers = map[string]bool{
+	"count": true, "interval": true, "bysecond": true, "byminute": true, "byhour": true,
+	"bymonthday": true, "byyearday": true, "byweekno": true, "bymonth": true, "bysetpos": true,
+}
+
+// valueType returns the type of a property value: the VALUE parameter, or
+// the default type of the property. Properties without a known type, like
+// X- properties, are "unknown".
+func valueType(name string, params map[string][]string, value string) string {
+	if values := params["VALUE"]; len(values) > 0 && values[0] != "" {
+		return strings.ToLower(values[0])
+	}
+	typ, ok := valueTypes[strings.ToUpper(name)]
+	if !ok {
+		return "unknown"
+	}
+	// Dates without VALUE=DATE are common in the wild
+	if typ == "date-time" && value != "" && !strings.Contains(value, "T") {
+		return "date"
+	}
+	return typ
+}
+
+// valueParam returns the VALUE parameter needed for a property read with
+// type typ, "" if typ is the default of the property
+func valueParam(name, typ string) string {
+	def, ok := valueTypes[strings.ToUpper(name)]
+	if typ == "unknown" || typ == def || (!ok && typ == "text") {
+		return ""
+	}
+	return strings.ToUpper(typ)
+}
+
+// splitValue splits the value of a list property into its items
+func splitValue(name, value string) []string {
+	if listProperties[strings.ToUpper(name)] {
+		return strings.Split(value, ",")
+	}
+	return []string{value}
+}
+
+// componentName returns the lower-case name of a component, e.g. "vevent"
+func componentName(component ics.Component) string {
+	switch c := component.(type) {
+	case *ics.VEvent:
+		return "vevent"
+	case *ics.VTodo:
+		return "vtodo"
+	case *ics.VJournal:
+		return "vjournal"
+	case *ics.VBusy:
+		return "vfreebusy"
+	case *ics.VTimezone:
+		return "vtimezone"
+	case *ics.VAlarm:
+		return "valarm"
+	case *ics.Standard:
+		return "standard"
+	case *ics.Daylight:
+		return "daylight"
+	case *ics.GeneralComponent:
+		return strings.ToLower(c.Token)
+	}
+	return "x-unknown"
+}
+
+// newComponent creates an empty component by name and returns its base
+func newComponent(name string) (ics.Component, *ics.ComponentBase) {
+	switch strings.ToLower(name) {
+	case "vevent":
+		c := &ics.VEvent{}
+		return c, &c.ComponentBase
+	case "vtodo":
+		c := &ics.VTodo{}
+		return c, &c.ComponentBase
+	case "vjournal":
+		c := &ics.VJournal{}
+		return c, &c.ComponentBase
+	case "vfreebusy":
+		c := &ics.VBusy{}
+		return c, &c.ComponentBase
+	case "vtimezone":
+		c := &ics.VTimezone{}
+		return c, &c.ComponentBase
+	case "valarm":
+		c := &ics.VAlarm{}
+		return c, &c.ComponentBase
+	case "standard":
+		c := &ics.Standard{}
+		return c, &c.ComponentBase
+	case "daylight":
+		c := &ics.Daylight{}
+		return c, &c.ComponentBase
+	}
+	c := &ics.GeneralComponent{Token: strings.ToUpper(name)}
+	return c, &c.ComponentBase
+}
+
+// toISO converts a date, date-time or UTC offset value of type typ to the
+// extended ISO 8601 form of jCal and xCal, e.g. 20250106T090000Z to
+// 2025-01-06T09:00:00Z. Other values are returned unchanged.
+func toISO(typ, value string) string {
+	switch typ {
+	case "date":
+		if len(value) == 8 {
+			return value[:4] + "-" + value[4:6] + "-" + value[6:]
+		}
+	case "date-time":
+		if len(value) >= 15 && value[8] == 'T' {
+			return value[:4] + "-" + value[4:6] + "-" + value[6:8] + "T" + value[9:11] + ":" + value[11:13] + ":" + value[13:15] + value[15:]
+		}
+	case "utc-offset":
+		if len(value) == 5 {
+			return value[:3] + ":" + value[3:]
+		}
+		if len(value) == 7 {
+			return value[:3] + ":" + value[3:5] + ":" + value[5:]
+		}
+	case "period":
+		start, end, ok := strings.Cut(value, "/")
+		if !ok {
+			return value
+		}
+		if !strings.HasPrefix(strings.TrimLeft(end, "+-"), "P") {
+			end = toISO("date-time", end)
+		}
+		return toISO("date-time", start) + "/" + end
+	}
+	return value
+}
+
+// fromISO converts a value of type typ from the extended ISO 8601 form back
+// to iCalendar, it reverses toISO
+func fromISO(typ, value string) string {
+	switch typ {
+	case "date", "date-time":
+		return strings.NewReplacer("-", "", ":", "").Replace(value)
+	case "utc-offset":
+		if value != "" {
+			// Keep the sign
+			return value[:1] + strings.ReplaceAll(value[1:], ":", "")
+		}
+	case "period":
+		start, end, ok := strings.Cut(value, "/")
+		if !ok {
+			return value
+		}
+		if !strings.HasPrefix(strings.TrimLeft(end, "+-"), "P") {
+			end = fromISO("date-time", end)
+		}
+		return fromISO("date-time", start) + "/" + end
+	}
+	return value
+}
+
+// recurPart is a part of an RRULE like BYDAY=MO,TU, with a lower-case name
+type recurPart struct {
+	name   string
+	values []string
+}
+
+// parseRecur splits an RRULE value into its parts. UNTIL is converted to
+// the ISO 8601 form.
+func parseRecur(value string) []recurPart {
+	var parts []recurPart
+	for _, part := range strings.Split(value, ";") {
+		name, values, ok := strings.Cut(part, "=")
+		if !ok || name == "" {
+			continue
+		}
+		name = strings.ToLower(name)
+		if name == "until" {
+			typ := "date-time"
+			if !strings.Contains(values, "T") {
+				typ = "date"
+			}
+			values = toISO(typ, values)
+		}
+		parts = append(parts, recurPart{name: name, values: strings.Split(values, ",")})
+	}
+	return parts
+}
+
+// formatRecur joins RRULE parts, UNTIL is converted back from the ISO 8601
+// form
+func formatRecur(parts []recurPart) string {
+	var rule []string
+	for _, part := range parts {
+		values := part.values
+		if part.name == "until" && len(values) == 1 {
+			values = []string{fromISO("date-time", values[0])}
+		}
+		rule = append(rule, strings.ToUpper(part.name)+"="+strings.Join(values, ","))
+	}
+	return strings.Join(rule, ";")
+}
+
+// property is a property in the form shared by jCal and xCal: lower-case
+// names, the value type instead of the VALUE parameter, and the values of
+// lists split and converted to ISO 8601
+type property struct {
+	name   string
+	params map[string][]string
+	typ    string
+	values []string
+	// recur holds the parts of recur values instead of values
+	recur []recurPart
+}
+
+// exportProperty converts an iCalendar property to the shared form
+func exportProperty(p ics.BaseProperty) property {
+	prop := property{
+		name:   strings.ToLower(p.IANAToken),
+		params: make(map[string][]string),
+		typ:    valueType(p.IANAToken, p.ICalParameters, p.Value),
+	}
+	for name, values := range p.ICalParameters {
+		if strings.ToUpper(name) != "VALUE" {
+			prop.params[strings.ToLower(name)] = values
+		}
+	}
+	if prop.typ == "recur" {
+		prop.recur = parseRecur(p.Value)
+		return prop
+	}
+	for _, value := range splitValue(p.IANAToken, p.Value) {
+		prop.values = append(prop.values, toISO(prop.typ, value))
+	}
+	return prop
+}
+
+// base converts a property in the shared form back to iCalendar, the
+// VALUE parameter is only set where the type differs from the default
+func (p property) base() ics.BaseProperty {
+	base := ics.BaseProperty{
+		IANAToken:      strings.ToUpper(p.name),
+		ICalParameters: make(map[string][]string),
+	}
+	for name, values := range p.params {
+		base.ICalParameters[strings.ToUpper(name)] = values
+	}
+	if param := valueParam(p.name, p.typ); param != "" {
+		base.ICalParameters["VALUE"] = []string{param}
+	}
+	if p.typ == "recur" {
+		base.Value = formatRecur(p.recur)
+		return base
+	}
+	values := make([]string, len(p.values))
+	for i, value := range p.values {
+		values[i] = fromISO(p.typ, value)
+	}
+	base.Value = strings.Join(values, ",")
+	return base
+}
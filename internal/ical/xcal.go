@@ -0,0 +1,248 @@
+package ical
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/arran4/golang-ical"
+)
+
+// XCalMediaType is the media type of xCal (RFC 6321)
+const XCalMediaType = "application/calendar+xml"
+
+// xcalNamespace is the XML namespace of xCal elements
+const xcalNamespace = "urn:ietf:params:xml:ns:icalendar-2.0"
+
+// paramTypes are the value types of parameters that are not text
+var paramTypes = map[string]string{
+	"altrep": "uri", "dir": "uri",
+	"delegated-from": "cal-address", "delegated-to": "cal-address", "member": "cal-address", "sent-by": "cal-address",
+}
+
+// xmlNode is an XML element with its children, used to write and read
+// xCal without a struct per property
+type xmlNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Children []xmlNode  `xml:",any"`
+	Text     string     `xml:",chardata"`
+}
+
+// child returns the first child element named name, nil if there is none
+func (n *xmlNode) child(name string) *xmlNode {
+	for i := range n.Children {
+		if n.Children[i].XMLName.Local == name {
+			return &n.Children[i]
+		}
+	}
+	return nil
+}
+
+// element creates an XML element with children
+func element(name string, children ...xmlNode) xmlNode {
+	return xmlNode{XMLName: xml.Name{Local: name}, Children: children}
+}
+
+// textElement creates an XML element with text
+func textElement(name, text string) xmlNode {
+	return xmlNode{XMLName: xml.Name{Local: name}, Text: text}
+}
+
+// ToXCal converts a calendar to xCal, the XML representation of
+// iCalendar: <icalendar><vcalendar><properties/><components/></vcalendar></icalendar>
+func ToXCal(cal *ics.Calendar) ([]byte, error) {
+	var props []xmlNode
+	for _, p := range cal.CalendarProperties {
+		props = append(props, xcalProperty(exportProperty(p.BaseProperty)))
+	}
+	vcalendar := element("vcalendar", element("properties", props...))
+	if len(cal.Components) > 0 {
+		vcalendar.Children = append(vcalendar.Children, element("components", xcalComponents(cal.Components)...))
+	}
+	root := element("icalendar", vcalendar)
+	// As an attribute, so the children are in the namespace too
+	root.Attrs = []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: xcalNamespace}}
+
+	data, err := xml.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+// xcalComponents converts components to xCal elements
+func xcalComponents(components []ics.Component) []xmlNode {
+	var result []xmlNode
+	for _, c := range components {
+		var props []xmlNode
+		for _, p := range c.UnknownPropertiesIANAProperties() {
+			props = append(props, xcalProperty(exportProperty(p.BaseProperty)))
+		}
+		node := element(componentName(c), element("properties", props...))
+		if subComponents := c.SubComponents(); len(subComponents) > 0 {
+			node.Children = append(node.Children, element("components", xcalComponents(subComponents)...))
+		}
+		result = append(result, node)
+	}
+	return result
+}
+
+// xcalProperty converts a property to an element named after it, with the
+// parameters and one element per value named after the value type
+func xcalProperty(p property) xmlNode {
+	node := element(p.name)
+
+	if len(p.params) > 0 {
+		var names []string
+		for name := range p.params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		parameters := element("parameters")
+		for _, name := range names {
+			typ := paramTypes[name]
+			if typ == "" {
+				typ = "text"
+			}
+			param := element(name)
+			for _, value := range p.params[name] {
+				param.Children = append(param.Children, textElement(typ, value))
+			}
+			parameters.Children = append(parameters.Children, param)
+		}
+		node.Children = append(node.Children, parameters)
+	}
+
+	if p.typ == "recur" {
+		recur := element("recur")
+		for _, part := range p.recur {
+			for _, value := range part.values {
+				recur.Children = append(recur.Children, textElement(part.name, value))
+			}
+		}
+		node.Children = append(node.Children, recur)
+		return node
+	}
+	for _, value := range p.values {
+		// GEO is structured as latitude and longitude
+		if p.name == "geo" {
+			if latitude, longitude, ok := strings.Cut(value, ";"); ok {
+				node.Children = append(node.Children, textElement("latitude", latitude), textElement("longitude", longitude))
+				continue
+			}
+		}
+		node.Children = append(node.Children, textElement(p.typ, value))
+	}
+	return node
+}
+
+// ParseXCal reads an xCal document. Several vcalendar elements are merged
+// into one calendar.
+func ParseXCal(r io.Reader) (*ics.Calendar, error) {
+	var root xmlNode
+	if err := xml.NewDecoder(r).Decode(&root); err != nil {
+		return nil, fmt.Errorf("invalid xCal: %w", err)
+	}
+	if root.XMLName.Local != "icalendar" {
+		return nil, fmt.Errorf("invalid xCal: expected icalendar, got %s", root.XMLName.Local)
+	}
+
+	cal := &ics.Calendar{}
+	found := false
+	for _, vcalendar := range root.Children {
+		if vcalendar.XMLName.Local != "vcalendar" {
+			continue
+		}
+		props, components, err := xcalComponent(vcalendar)
+		if err != nil {
+			return nil, err
+		}
+		// Properties of the first calendar, components of all
+		if !found {
+			for _, p := range props {
+				cal.CalendarProperties = append(cal.CalendarProperties, ics.CalendarProperty{BaseProperty: p})
+			}
+		}
+		cal.Components = append(cal.Components, components...)
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("invalid xCal: no vcalendar")
+	}
+	return cal, nil
+}
+
+// xcalComponent reads the properties and sub-components of a component
+func xcalComponent(node xmlNode) ([]ics.BaseProperty, []ics.Component, error) {
+	var props []ics.BaseProperty
+	if properties := node.child("properties"); properties != nil {
+		for _, p := range properties.Children {
+			prop, err := parseXCalProperty(p)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", node.XMLName.Local, err)
+			}
+			props = append(props, prop)
+		}
+	}
+
+	var components []ics.Component
+	if subComponents := node.child("components"); subComponents != nil {
+		for _, c := range subComponents.Children {
+			subProps, subSubComponents, err := xcalComponent(c)
+			if err != nil {
+				return nil, nil, err
+			}
+			component, base := newComponent(c.XMLName.Local)
+			for _, p := range subProps {
+				base.Properties = append(base.Properties, ics.IANAProperty{BaseProperty: p})
+			}
+			base.Components = subSubComponents
+			components = append(components, component)
+		}
+	}
+	return props, components, nil
+}
+
+// parseXCalProperty reads a property element, the type comes from the
+// names of the value elements
+func parseXCalProperty(node xmlNode) (ics.BaseProperty, error) {
+	prop := property{name: node.XMLName.Local, params: make(map[string][]string)}
+	var latitude, longitude string
+	for _, child := range node.Children {
+		switch name := child.XMLName.Local; name {
+		case "parameters":
+			for _, param := range child.Children {
+				for _, value := range param.Children {
+					prop.params[param.XMLName.Local] = append(prop.params[param.XMLName.Local], value.Text)
+				}
+			}
+		case "recur":
+			prop.typ = "recur"
+			for _, part := range child.Children {
+				// Repeated elements like <byday> are one part with several values
+				if n := len(prop.recur); n > 0 && prop.recur[n-1].name == part.XMLName.Local {
+					prop.recur[n-1].values = append(prop.recur[n-1].values, part.Text)
+					continue
+				}
+				prop.recur = append(prop.recur, recurPart{name: part.XMLName.Local, values: []string{part.Text}})
+			}
+		case "latitude":
+			prop.typ, latitude = "float", child.Text
+		case "longitude":
+			prop.typ, longitude = "float", child.Text
+		default:
+			prop.typ = name
+			prop.values = append(prop.values, child.Text)
+		}
+	}
+	if latitude != "" || longitude != "" {
+		prop.values = append(prop.values, latitude+";"+longitude)
+	}
+	if prop.typ == "" {
+		return ics.BaseProperty{}, fmt.Errorf("invalid xCal property %s without a value", prop.name)
+	}
+	return prop.base(), nil
+}
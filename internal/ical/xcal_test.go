@@ -0,0 +1,58 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arran4/golang-ical"
+)
+
+// TestXCal tests the xCal representation of properties and that a calendar
+// survives the conversion to xCal and back
+func TestXCal(t *testing.T) {
+	cal, err := ics.ParseCalendar(strings.NewReader(strings.ReplaceAll(roundTripCalendar, "\n", "\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ToXCal(cal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Compare without the indentation
+	var compact strings.Builder
+	for _, line := range strings.Split(string(data), "\n") {
+		compact.WriteString(strings.TrimSpace(line))
+	}
+	for _, want := range []string{
+		`<icalendar xmlns="urn:ietf:params:xml:ns:icalendar-2.0"><vcalendar><properties><version><text>2.0</text></version>`,
+		`<tzoffsetfrom><utc-offset>+02:00</utc-offset></tzoffsetfrom>`,
+		`<summary><text>Standup, daily</text></summary>`,
+		`<dtstart><parameters><tzid><text>Europe/Berlin</text></tzid></parameters><date-time>2025-01-06T09:00:00</date-time></dtstart>`,
+		`<rrule><recur><freq>WEEKLY</freq><until>2025-01-31T23:59:59Z</until><interval>2</interval><byday>MO</byday><byday>WE</byday></recur></rrule>`,
+		`<date-time>2025-01-08T09:00:00</date-time><date-time>2025-01-20T09:00:00</date-time></exdate>`,
+		`<attendee><parameters><cn><text>Jane Doe</text></cn><partstat><text>ACCEPTED</text></partstat></parameters><cal-address>mailto:jane@example.com</cal-address></attendee>`,
+		`<geo><latitude>52.52</latitude><longitude>13.405</longitude></geo>`,
+		`<x-icalmerger-source><unknown>Arthur</unknown></x-icalmerger-source>`,
+		`<components><valarm><properties><action><text>DISPLAY</text></action><trigger><duration>-PT15M</duration></trigger></properties></valarm></components>`,
+		`<dtstart><date>2025-01-10</date></dtstart>`,
+	} {
+		if !strings.Contains(compact.String(), want) {
+			t.Errorf("Expected %s in the xCal output", want)
+		}
+	}
+
+	parsed, err := ParseXCal(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("Failed to parse the xCal output: %v", err)
+	}
+	if got, want := parsed.Serialize(), cal.Serialize(); got != want {
+		t.Errorf("Round trip changed the calendar:\n%s\nwant:\n%s", got, want)
+	}
+
+	for _, invalid := range []string{`<calendar/>`, `<icalendar/>`, `<icalendar><vcalendar><properties><summary/></properties></vcalendar></icalendar>`} {
+		if _, err := ParseXCal(strings.NewReader(invalid)); err == nil {
+			t.Errorf("Expected an error for %s", invalid)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package reminder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/config"
+	"github.com/arthur/ical_merger/internal/email"
+	"github.com/arthur/ical_merger/internal/webhook"
+)
+
+// channelTimeout is the timeout of each HTTP delivery
+const channelTimeout = 10 * time.Second
+
+// channel delivers reminders, when describes the start of the event
+type channel interface {
+	name() string
+	send(r Reminder, when string, now time.Time) error
+}
+
+// newChannel creates the channel of the config
+func newChannel(cfg config.ReminderChannel, mailer *email.Notifier) channel {
+	client := &http.Client{Timeout: channelTimeout}
+	switch cfg.Type {
+	case "ntfy":
+		return &ntfyChannel{cfg: cfg, client: client}
+	case "email":
+		return &emailChannel{mailer: mailer}
+	default:
+		return &webhookChannel{cfg: cfg, client: client}
+	}
+}
+
+// webhookChannel posts reminders as JSON, signed like the change webhooks
+type webhookChannel struct {
+	cfg    config.ReminderChannel
+	client *http.Client
+}
+
+func (c *webhookChannel) name() string {
+	return "webhook"
+}
+
+func (c *webhookChannel) send(r Reminder, when string, now time.Time) error {
+	body, err := json.Marshal(struct {
+		Type string `json:"type"`
+		Reminder
+		When string `json:"when"`
+	}{Type: "reminder", Reminder: r, When: when})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ical-merger")
+	req.Header.Set(webhook.HeaderEvent, "reminder")
+	if c.cfg.Secret != "" {
+		req.Header.Set(webhook.HeaderSignature, webhook.Sign(c.cfg.Secret, body))
+	}
+	return do(c.client, req)
+}
+
+// ntfyChannel pushes reminders as plain-text messages to a ntfy topic URL
+type ntfyChannel struct {
+	cfg    config.ReminderChannel
+	client *http.Client
+}
+
+func (c *ntfyChannel) name() string {
+	return "ntfy"
+}
+
+func (c *ntfyChannel) send(r Reminder, when string, now time.Time) error {
+	message := when
+	if r.Location != "" {
+		message += "\n" + r.Location
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.cfg.URL, strings.NewReader(message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("User-Agent", "ical-merger")
+	// Header values must be ASCII, ntfy decodes RFC 2047 encoded words
+	req.Header.Set("Title", mime.QEncoding.Encode("utf-8", r.Summary))
+	req.Header.Set("Tags", "calendar")
+	if c.cfg.Priority > 0 {
+		req.Header.Set("Priority", strconv.Itoa(c.cfg.Priority))
+	}
+	if c.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+	return do(c.client, req)
+}
+
+// emailChannel sends reminders with the email settings
+type emailChannel struct {
+	mailer *email.Notifier
+}
+
+func (c *emailChannel) name() string {
+	return "email"
+}
+
+func (c *emailChannel) send(r Reminder, when string, now time.Time) error {
+	return c.mailer.SendReminder(r.Summary, when, r.Location, now)
+}
+
+// do sends a request and fails on any status other than 2xx
+func do(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package reminder
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/config"
+	"github.com/arthur/ical_merger/internal/email"
+	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arthur/ical_merger/internal/store"
+	"github.com/arran4/golang-ical"
+)
+
+// Grace is how late a reminder may still be sent, e.g. after a restart.
+// Older reminders are dropped rather than sent long after their time.
+const Grace = 15 * time.Minute
+
+// Reminder is one notification about an upcoming event
+type Reminder struct {
+	UID      string        `json:"uid"`
+	Summary  string        `json:"summary"`
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	AllDay   bool          `json:"all_day"`
+	Location string        `json:"location,omitempty"`
+	Source   string        `json:"source,omitempty"`
+	Sources  []string      `json:"sources,omitempty"`
+	Lead     time.Duration `json:"-"`
+	// LeadText is the lead time as configured, e.g. "15m"
+	LeadText string    `json:"lead"`
+	DueAt    time.Time `json:"due_at"`
+}
+
+// key identifies a reminder in the state file
+func (r Reminder) key() string {
+	return r.UID + "|" + r.Start.UTC().Format(time.RFC3339) + "|" + r.Lead.String()
+}
+
+// Scheduler sends the reminders of a feed when they are due
+type Scheduler struct {
+	output    config.Output
+	loc       *time.Location
+	store     *store.Store
+	leads     []time.Duration
+	allDay    bool
+	sources   map[string]bool
+	channels  []channel
+	statePath string
+
+	mu sync.Mutex
+	// sent maps the keys of delivered reminders to the start of their event
+	sent map[string]time.Time
+	// pending are the reminders being delivered
+	pending map[string]bool
+	// wg tracks the running deliveries
+	wg sync.WaitGroup
+}
+
+// New creates the scheduler of the config, nil if reminders are not
+// configured. Previously delivered reminders are read from the state file.
+func New(cfg *config.Config, events *store.Store, mailer *email.Notifier) *Scheduler {
+	if cfg.Reminders == nil {
+		return nil
+	}
+	output, ok := cfg.FindOutput(cfg.Reminders.Output)
+	if !ok {
+		log.Printf("Reminders: unknown output %q, reminders disabled", cfg.Reminders.Output)
+		return nil
+	}
+	leads, err := cfg.Reminders.Leads()
+	if err != nil {
+		log.Printf("Reminders: %v, reminders disabled", err)
+		return nil
+	}
+	loc, err := time.LoadLocation(output.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	s := &Scheduler{
+		output:    output,
+		loc:       loc,
+		store:     events,
+		leads:     leads,
+		allDay:    cfg.Reminders.AllDay,
+		sources:   make(map[string]bool),
+		statePath: cfg.Reminders.StatePath,
+		sent:      make(map[string]time.Time),
+		pending:   make(map[string]bool),
+	}
+	if s.statePath == "" {
+		s.statePath = filepath.Join(filepath.Dir(cfg.OutputPath), "reminders.json")
+	}
+	for _, source := range cfg.Reminders.Sources {
+		s.sources[source] = true
+	}
+	for _, channel := range cfg.Reminders.Channels {
+		s.channels = append(s.channels, newChannel(channel, mailer))
+	}
+
+	if data, err := os.ReadFile(s.statePath); err == nil {
+		if err := json.Unmarshal(data, &s.sent); err != nil {
+			log.Printf("Reminders: ignoring unreadable state file %s: %v", s.statePath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("Reminders: error reading state file %s: %v", s.statePath, err)
+	}
+	return s
+}
+
+// Tick sends the reminders due at now in the background. It is called every
+// minute by the main loop.
+func (s *Scheduler) Tick(now time.Time) {
+	if s == nil {
+		return
+	}
+
+	feed, err := s.store.Lookup(s.output)
+	if err != nil {
+		log.Printf("Reminders: %v", err)
+		return
+	}
+
+	for _, r := range s.Due(feed.Calendar, now) {
+		s.mu.Lock()
+		skip := s.pending[r.key()]
+		s.pending[r.key()] = true
+		s.mu.Unlock()
+		if skip {
+			continue
+		}
+
+		s.wg.Add(1)
+		go func(r Reminder) {
+			defer s.wg.Done()
+			s.deliver(r, now)
+		}(r)
+	}
+}
+
+// Wait blocks until all running deliveries are done
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// Due returns the reminders of the calendar that are due at now and have not
+// been sent yet, in the order of their due time
+func (s *Scheduler) Due(cal *ics.Calendar, now time.Time) []Reminder {
+	maxLead := time.Duration(0)
+	for _, lead := range s.leads {
+		if lead > maxLead {
+			maxLead = lead
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []Reminder
+	for _, o := range ical.ExpandCalendar(cal, now, now.Add(maxLead+time.Minute), s.loc) {
+		// Events that already started need no reminder
+		if !o.Start.After(now) || (o.AllDay && !s.allDay) {
+			continue
+		}
+		if strings.EqualFold(ical.PropertyValue(o.Event, ics.ComponentPropertyStatus), "CANCELLED") {
+			continue
+		}
+
+		var sources []string
+		for _, prop := range o.Event.GetProperties(ical.PropertySource) {
+			sources = append(sources, prop.Value)
+		}
+		if !s.matchesSources(sources) {
+			continue
+		}
+
+		for _, lead := range s.leads {
+			dueAt := o.Start.Add(-lead)
+			if dueAt.After(now) || now.Sub(dueAt) > Grace {
+				continue
+			}
+			r := Reminder{
+				UID:      ical.PropertyValue(o.Event, ics.ComponentPropertyUniqueId),
+				Summary:  ical.PropertyValue(o.Event, ics.ComponentPropertySummary),
+				Start:    o.Start,
+				End:      o.End,
+				AllDay:   o.AllDay,
+				Location: ical.PropertyValue(o.Event, ics.ComponentPropertyLocation),
+				Sources:  sources,
+				Lead:     lead,
+				LeadText: formatLead(lead),
+				DueAt:    dueAt,
+			}
+			if len(sources) > 0 {
+				r.Source = sources[0]
+			}
+			if _, sent := s.sent[r.key()]; !sent {
+				due = append(due, r)
+			}
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].DueAt.Before(due[j].DueAt) })
+	return due
+}
+
+// matchesSources reports whether an event of these calendars is reminded of
+func (s *Scheduler) matchesSources(sources []string) bool {
+	if len(s.sources) == 0 {
+		return true
+	}
+	for _, source := range sources {
+		if s.sources[source] {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver sends a reminder through every channel. It counts as sent once
+// any channel succeeds, otherwise the next tick tries again.
+func (s *Scheduler) deliver(r Reminder, now time.Time) {
+	delivered := false
+	for _, channel := range s.channels {
+		if err := channel.send(r, s.describe(r, now), now); err != nil {
+			log.Printf("Reminders: error sending %s reminder of %q: %v", channel.name(), r.Summary, err)
+			continue
+		}
+		delivered = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, r.key())
+	if !delivered {
+		return
+	}
+	log.Printf("Reminders: sent the %s reminder of %q", r.LeadText, r.Summary)
+	s.sent[r.key()] = r.Start
+	s.saveLocked(now)
+}
+
+// saveLocked writes the state file, forgetting events that started a day ago
+func (s *Scheduler) saveLocked(now time.Time) {
+	for key, start := range s.sent {
+		if start.Before(now.Add(-24 * time.Hour)) {
+			delete(s.sent, key)
+		}
+	}
+	data, err := json.MarshalIndent(s.sent, "", "  ")
+	if err != nil {
+		log.Printf("Reminders: error encoding state: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(s.statePath), 0755); err != nil {
+		log.Printf("Reminders: error writing state file: %v", err)
+		return
+	}
+	// Write a temporary file first so a crash never leaves a truncated state
+	tmp := s.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("Reminders: error writing state file: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, s.statePath); err != nil {
+		log.Printf("Reminders: error writing state file: %v", err)
+	}
+}
+
+// describe says when an event starts, e.g. "In 15 minutes, at 3:00 PM"
+func (s *Scheduler) describe(r Reminder, now time.Time) string {
+	start := r.Start.In(s.loc)
+	if r.AllDay {
+		return "All day on " + start.Format("Monday, Jan 2")
+	}
+	in := r.Start.Sub(now).Round(time.Minute)
+	var text string
+	switch {
+	case in < time.Minute:
+		text = "Now"
+	case in < time.Hour:
+		text = fmt.Sprintf("In %d minutes", int(in.Minutes()))
+	case in%time.Hour == 0 && in < 48*time.Hour:
+		text = fmt.Sprintf("In %d hours", int(in.Hours()))
+	default:
+		text = "On " + start.Format("Monday, Jan 2")
+	}
+	return text + ", at " + start.Format("3:04 PM")
+}
+
+// formatLead formats a lead time without zero units, e.g. "1h" instead of "1h0m0s"
+func formatLead(lead time.Duration) string {
+	text := lead.String()
+	if strings.HasSuffix(text, "m0s") {
+		text = strings.TrimSuffix(text, "0s")
+	}
+	if strings.HasSuffix(text, "h0m") {
+		text = strings.TrimSuffix(text, "0m")
+	}
+	return text
+}
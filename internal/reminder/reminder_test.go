@@ -0,0 +1,120 @@
+package reminder
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/config"
+	"github.com/arthur/ical_merger/internal/store"
+)
+
+// TestScheduler tests that reminders of recurring events are sent through
+// every channel once, also across restarts
+func TestScheduler(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "merged.ics")
+	if err := os.WriteFile(path, []byte(strings.ReplaceAll(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:standup
+SUMMARY:[Arthur] Standup
+LOCATION:Office
+X-ICALMERGER-SOURCE:Arthur
+DTSTART:20250106T090000Z
+DTEND:20250106T091500Z
+RRULE:FREQ=DAILY
+END:VEVENT
+BEGIN:VEVENT
+UID:cancelled
+SUMMARY:[Arthur] Cancelled
+STATUS:CANCELLED
+X-ICALMERGER-SOURCE:Arthur
+DTSTART:20250106T090000Z
+DTEND:20250106T100000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:yoga
+SUMMARY:[Hannah] Yoga
+X-ICALMERGER-SOURCE:Hannah
+DTSTART:20250106T090000Z
+DTEND:20250106T100000Z
+END:VEVENT
+END:VCALENDAR
+`, "\n", "\r\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var webhooks []map[string]interface{}
+	var pushes []string
+	webhookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		webhooks = append(webhooks, payload)
+		mu.Unlock()
+	}))
+	defer webhookServer.Close()
+	ntfyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		pushes = append(pushes, r.Header.Get("Title")+": "+string(body))
+		mu.Unlock()
+	}))
+	defer ntfyServer.Close()
+
+	cfg := &config.Config{
+		OutputPath:     path,
+		OutputTimezone: "UTC",
+		Reminders: &config.Reminders{
+			LeadTimes: []string{"15m", "1h"},
+			Sources:   []string{"Arthur"},
+			Channels: []config.ReminderChannel{
+				{Type: "webhook", URL: webhookServer.URL},
+				{Type: "ntfy", URL: ntfyServer.URL},
+			},
+		},
+	}
+
+	events := store.New()
+	events.Load(cfg.AllOutputs())
+
+	now := time.Date(2025, 1, 7, 8, 46, 0, 0, time.UTC)
+	s := New(cfg, events, nil)
+	s.Tick(now)
+	s.Wait()
+	s.Tick(now.Add(time.Minute))
+	s.Wait()
+
+	// A restart remembers the reminder from the state file
+	restarted := New(cfg, events, nil)
+	restarted.Tick(now.Add(2 * time.Minute))
+	restarted.Wait()
+
+	if len(webhooks) != 1 || len(pushes) != 1 {
+		t.Fatalf("Expected one reminder per channel, got %d webhooks and %d pushes", len(webhooks), len(pushes))
+	}
+	if webhooks[0]["uid"] != "standup" || webhooks[0]["lead"] != "15m" || webhooks[0]["start"] != "2025-01-07T09:00:00Z" {
+		t.Errorf("Unexpected webhook payload: %v", webhooks[0])
+	}
+	if want := "[Arthur] Standup: In 14 minutes, at 9:00 AM\nOffice"; pushes[0] != want {
+		t.Errorf("Unexpected push %q, want %q", pushes[0], want)
+	}
+
+	// The next occurrence gets its own reminder
+	s = New(cfg, events, nil)
+	s.Tick(now.AddDate(0, 0, 1).Add(-45 * time.Minute))
+	s.Wait()
+	if len(webhooks) != 2 || webhooks[1]["lead"] != "1h" {
+		t.Errorf("Expected the 1h reminder of the next day, got %v", webhooks)
+	}
+}
@@ -0,0 +1,410 @@
+package search
+
+import (
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Searchable fields of a document
+const (
+	FieldSummary     = "summary"
+	FieldLocation    = "location"
+	FieldAttendees   = "attendees"
+	FieldDescription = "description"
+)
+
+// fields lists the searchable fields in the order of their weight
+var fields = []string{FieldSummary, FieldLocation, FieldAttendees, FieldDescription}
+
+// weights makes hits in short, descriptive fields count more
+var weights = map[string]float64{
+	FieldSummary:     3,
+	FieldLocation:    2,
+	FieldAttendees:   1.5,
+	FieldDescription: 1,
+}
+
+// prefixWeight is the share of the score of a prefix match compared to a whole word
+const prefixWeight = 0.5
+
+// snippetRadius is the number of characters kept around the first hit of a
+// long field
+const snippetRadius = 40
+
+// Document is the searchable text of an event
+type Document struct {
+	Summary     string
+	Description string
+	Location    string
+	Attendees   []string
+}
+
+// text returns the text of a field, attendees are one per line
+func (d Document) text(field string) string {
+	switch field {
+	case FieldSummary:
+		return d.Summary
+	case FieldLocation:
+		return d.Location
+	case FieldAttendees:
+		return strings.Join(d.Attendees, "\n")
+	default:
+		return d.Description
+	}
+}
+
+// token is a word of a field with its position and byte offsets
+type token struct {
+	term       string
+	position   int
+	start, end int
+}
+
+// tokenize splits text into lower-case words of letters and digits
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if word && start < 0 {
+			start = i
+		}
+		if !word && start >= 0 {
+			tokens = append(tokens, token{term: strings.ToLower(text[start:i]), position: len(tokens), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{term: strings.ToLower(text[start:]), position: len(tokens), start: start, end: len(text)})
+	}
+	return tokens
+}
+
+// posting lists where a term occurs in a field of a document
+type posting struct {
+	doc       int
+	field     string
+	positions []int
+}
+
+// Index is an inverted index of documents. It is built once and only read
+// afterwards, so it can be shared between requests.
+type Index struct {
+	docs     []Document
+	postings map[string][]posting
+	// terms are the indexed terms in order, for prefix lookups
+	terms []string
+}
+
+// NewIndex indexes documents, results refer to them by their position
+func NewIndex(docs []Document) *Index {
+	ix := &Index{docs: docs, postings: make(map[string][]posting)}
+	for doc, d := range docs {
+		for _, field := range fields {
+			positions := make(map[string][]int)
+			var order []string
+			for _, t := range tokenize(d.text(field)) {
+				if _, seen := positions[t.term]; !seen {
+					order = append(order, t.term)
+				}
+				positions[t.term] = append(positions[t.term], t.position)
+			}
+			for _, term := range order {
+				ix.postings[term] = append(ix.postings[term], posting{doc: doc, field: field, positions: positions[term]})
+			}
+		}
+	}
+	for term := range ix.postings {
+		ix.terms = append(ix.terms, term)
+	}
+	sort.Strings(ix.terms)
+	return ix
+}
+
+// Clause is one part of a query: a word, a word prefix (word*) or a
+// quoted phrase
+type Clause struct {
+	Terms  []string
+	Prefix bool
+}
+
+// ParseQuery splits a query into clauses. Text in double quotes is a
+// phrase, an unclosed quote runs to the end. A trailing * makes a word a
+// prefix.
+func ParseQuery(query string) []Clause {
+	var clauses []Clause
+	for i, part := range strings.Split(query, `"`) {
+		// Odd parts are inside quotes
+		if i%2 == 1 {
+			var terms []string
+			for _, t := range tokenize(part) {
+				terms = append(terms, t.term)
+			}
+			if len(terms) > 0 {
+				clauses = append(clauses, Clause{Terms: terms})
+			}
+			continue
+		}
+		for _, word := range strings.Fields(part) {
+			prefix := strings.HasSuffix(word, "*")
+			// Words like "e-mail" are several terms, matched as a phrase
+			var terms []string
+			for _, t := range tokenize(word) {
+				terms = append(terms, t.term)
+			}
+			if len(terms) > 0 {
+				clauses = append(clauses, Clause{Terms: terms, Prefix: prefix})
+			}
+		}
+	}
+	return clauses
+}
+
+// Match is a document matching all clauses of a query
+type Match struct {
+	Doc   int
+	Score float64
+	// Highlights are HTML snippets of the matching fields with the hits in <mark>
+	Highlights map[string]string
+}
+
+// hit is a matched range of a field
+type hit struct {
+	field     string
+	positions []int
+	length    int
+}
+
+// Search returns the documents matching every clause of the query, best
+// matches first. Each clause counts more the fewer documents it matches.
+func (ix *Index) Search(query string) []Match {
+	clauses := ParseQuery(query)
+	if len(clauses) == 0 {
+		return nil
+	}
+
+	var scores map[int]float64
+	hits := make(map[int][]hit)
+	for i, clause := range clauses {
+		clauseScores, clauseHits := ix.match(clause)
+		if i == 0 {
+			scores = clauseScores
+		} else {
+			for doc := range scores {
+				score, ok := clauseScores[doc]
+				if !ok {
+					delete(scores, doc)
+					continue
+				}
+				scores[doc] += score
+			}
+		}
+		for doc := range scores {
+			hits[doc] = append(hits[doc], clauseHits[doc]...)
+		}
+	}
+
+	matches := make([]Match, 0, len(scores))
+	for doc, score := range scores {
+		matches = append(matches, Match{Doc: doc, Score: math.Round(score*1000) / 1000, Highlights: ix.highlight(doc, hits[doc])})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Doc < matches[j].Doc
+	})
+	return matches
+}
+
+// match scores the documents matching a clause and finds the hits
+func (ix *Index) match(c Clause) (map[int]float64, map[int][]hit) {
+	scores := make(map[int]float64)
+	hits := make(map[int][]hit)
+
+	// Postings of the last term, all terms starting with it for prefixes
+	last := c.Terms[len(c.Terms)-1]
+	var lastPostings []posting
+	// exact tells whole-word postings from prefix ones
+	var exact []bool
+	if c.Prefix {
+		from := sort.SearchStrings(ix.terms, last)
+		for _, term := range ix.terms[from:] {
+			if !strings.HasPrefix(term, last) {
+				break
+			}
+			for _, p := range ix.postings[term] {
+				lastPostings = append(lastPostings, p)
+				exact = append(exact, term == last)
+			}
+		}
+	} else {
+		for _, p := range ix.postings[last] {
+			lastPostings = append(lastPostings, p)
+			exact = append(exact, true)
+		}
+	}
+
+	docs := make(map[int]bool)
+	for i, p := range lastPostings {
+		// A phrase ends where its last term is, preceded by the other terms
+		var positions []int
+		for _, end := range p.positions {
+			if ix.phraseAt(p.doc, p.field, c.Terms[:len(c.Terms)-1], end-len(c.Terms)+1) {
+				positions = append(positions, end-len(c.Terms)+1)
+			}
+		}
+		if len(positions) == 0 {
+			continue
+		}
+		weight := weights[p.field] * float64(len(c.Terms))
+		if !exact[i] {
+			weight *= prefixWeight
+		}
+		scores[p.doc] += weight * (1 + math.Log(float64(len(positions))))
+		hits[p.doc] = append(hits[p.doc], hit{field: p.field, positions: positions, length: len(c.Terms)})
+		docs[p.doc] = true
+	}
+
+	// Rare clauses tell documents apart better than common ones
+	idf := math.Log(1 + float64(len(ix.docs))/float64(len(docs)+1))
+	for doc := range scores {
+		scores[doc] *= 1 + idf
+	}
+	return scores, hits
+}
+
+// phraseAt reports whether terms occur in a field of a document in order,
+// starting at position start
+func (ix *Index) phraseAt(doc int, field string, terms []string, start int) bool {
+	for i, term := range terms {
+		found := false
+		for _, p := range ix.postings[term] {
+			if p.doc != doc || p.field != field {
+				continue
+			}
+			for _, position := range p.positions {
+				if position == start+i {
+					found = true
+					break
+				}
+			}
+			break
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// highlight builds the snippets of the fields with hits. Short fields are
+// shown whole, the description around its first hit.
+func (ix *Index) highlight(doc int, hits []hit) map[string]string {
+	marked := make(map[string]map[int]bool)
+	for _, h := range hits {
+		if marked[h.field] == nil {
+			marked[h.field] = make(map[int]bool)
+		}
+		for _, position := range h.positions {
+			for i := 0; i < h.length; i++ {
+				marked[h.field][position+i] = true
+			}
+		}
+	}
+
+	highlights := make(map[string]string)
+	for field, positions := range marked {
+		text := ix.docs[doc].text(field)
+		tokens := tokenize(text)
+		from, to := 0, len(text)
+		if field == FieldDescription {
+			first := len(text)
+			for _, t := range tokens {
+				if positions[t.position] {
+					first = t.start
+					break
+				}
+			}
+			from, to = window(text, first)
+		}
+		if field == FieldAttendees {
+			// Only the matching attendees
+			var lines []string
+			offset := 0
+			for _, line := range strings.Split(text, "\n") {
+				for _, t := range tokens {
+					if positions[t.position] && t.start >= offset && t.end <= offset+len(line) {
+						lines = append(lines, mark(text, tokens, positions, offset, offset+len(line)))
+						break
+					}
+				}
+				offset += len(line) + 1
+			}
+			highlights[field] = strings.Join(lines, ", ")
+			continue
+		}
+
+		snippet := mark(text, tokens, positions, from, to)
+		if from > 0 {
+			snippet = "…" + snippet
+		}
+		if to < len(text) {
+			snippet += "…"
+		}
+		highlights[field] = strings.Join(strings.Fields(snippet), " ")
+	}
+	return highlights
+}
+
+// mark escapes text[from:to] as HTML with the marked tokens in <mark>
+func mark(text string, tokens []token, positions map[int]bool, from, to int) string {
+	var b strings.Builder
+	at := from
+	for _, t := range tokens {
+		if !positions[t.position] || t.start < from || t.end > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[at:t.start]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[t.start:t.end]))
+		b.WriteString("</mark>")
+		at = t.end
+	}
+	b.WriteString(html.EscapeString(text[at:to]))
+	return b.String()
+}
+
+// window returns the byte range of about snippetRadius characters around
+// offset, extended to whole words
+func window(text string, offset int) (int, int) {
+	from := offset
+	for n := 0; n < snippetRadius && from > 0; n++ {
+		_, size := utf8.DecodeLastRuneInString(text[:from])
+		from -= size
+	}
+	for from > 0 {
+		r, size := utf8.DecodeLastRuneInString(text[:from])
+		if unicode.IsSpace(r) {
+			break
+		}
+		from -= size
+	}
+	to := offset
+	for n := 0; n < 2*snippetRadius && to < len(text); n++ {
+		_, size := utf8.DecodeRuneInString(text[to:])
+		to += size
+	}
+	for to < len(text) {
+		r, size := utf8.DecodeRuneInString(text[to:])
+		if unicode.IsSpace(r) {
+			break
+		}
+		to += size
+	}
+	return from, to
+}
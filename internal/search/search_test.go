@@ -0,0 +1,62 @@
+package search
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestSearch tests word, prefix and phrase matching, the ranking and the
+// highlighted snippets
+func TestSearch(t *testing.T) {
+	ix := NewIndex([]Document{
+		{Summary: "Yoga class", Location: "Studio <B>"},
+		{Summary: "Team lunch", Description: strings.Repeat("filler ", 10) + "We talk about yoga retreats and the class schedule for next year." + strings.Repeat(" filler", 10)},
+		{Summary: "Parent evening", Attendees: []string{"Jane Doe <jane@example.com>", "Max <max@example.com>"}},
+		{Summary: "Class reunion"},
+	})
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"yoga", []int{0, 1}},
+		{"YOGA class", []int{0, 1}},
+		{"yog", nil},
+		{"yog*", []int{0, 1}},
+		{"retreat*", []int{1}},
+		{`"yoga class"`, []int{0}},
+		{`"class yoga"`, nil},
+		{"jane", []int{2}},
+		{"class", []int{0, 3, 1}},
+		{`"yoga retreats`, []int{1}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		var got []int
+		for _, match := range ix.Search(tt.query) {
+			got = append(got, match.Doc)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	matches := ix.Search("yoga studio")
+	if len(matches) != 1 {
+		t.Fatalf("Expected one match, got %v", matches)
+	}
+	if want := map[string]string{"summary": "<mark>Yoga</mark> class", "location": "<mark>Studio</mark> &lt;B&gt;"}; !reflect.DeepEqual(matches[0].Highlights, want) {
+		t.Errorf("Unexpected highlights %v", matches[0].Highlights)
+	}
+
+	matches = ix.Search(`"yoga retreats"`)
+	if want := "…filler filler filler filler We talk about <mark>yoga</mark> <mark>retreats</mark> and the class schedule for next year. filler filler filler filler filler…"; matches[0].Highlights["description"] != want {
+		t.Errorf("Unexpected description snippet %q", matches[0].Highlights["description"])
+	}
+
+	matches = ix.Search("max")
+	if got := matches[0].Highlights["attendees"]; got != "<mark>Max</mark> &lt;<mark>max</mark>@example.com&gt;" {
+		t.Errorf("Unexpected attendee snippet %q", got)
+	}
+}
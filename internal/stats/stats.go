@@ -0,0 +1,218 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arran4/golang-ical"
+)
+
+// Uncategorized groups the events without CATEGORIES
+const Uncategorized = "uncategorized"
+
+// busiestDays is the number of days listed in Stats.BusiestDays
+const busiestDays = 5
+
+// Count is the number of events and their scheduled hours. All-day events
+// are counted but add no hours.
+type Count struct {
+	Events int     `json:"events"`
+	Hours  float64 `json:"hours"`
+}
+
+// add counts one occurrence
+func (c *Count) add(hours float64) {
+	c.Events++
+	c.Hours += hours
+}
+
+// Group is the allocation of one calendar or category
+type Group struct {
+	Name string `json:"name"`
+	Count
+	Recurring int              `json:"recurring"`
+	OneOff    int              `json:"one_off"`
+	ByDay     map[string]Count `json:"by_day"`
+	ByWeek    map[string]Count `json:"by_week"`
+	ByMonth   map[string]Count `json:"by_month"`
+}
+
+// Day is the allocation of a single day
+type Day struct {
+	Date string `json:"date"`
+	Count
+}
+
+// Stats is the time allocation over a period
+type Stats struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Timezone   string    `json:"timezone"`
+	Total      Group     `json:"total"`
+	Sources    []*Group  `json:"sources"`
+	Categories []*Group  `json:"categories"`
+	// BusiestDays are the days with the most scheduled hours
+	BusiestDays []Day `json:"busiest_days"`
+}
+
+// newGroup creates an empty group
+func newGroup(name string) *Group {
+	return &Group{
+		Name:    name,
+		ByDay:   make(map[string]Count),
+		ByWeek:  make(map[string]Count),
+		ByMonth: make(map[string]Count),
+	}
+}
+
+// add counts one occurrence starting at start (in the stats timezone)
+func (g *Group) add(start time.Time, hours float64, recurring bool) {
+	g.Count.add(hours)
+	if recurring {
+		g.Recurring++
+	} else {
+		g.OneOff++
+	}
+
+	year, week := start.ISOWeek()
+	for key, buckets := range map[string]map[string]Count{
+		start.Format("2006-01-02"):          g.ByDay,
+		fmt.Sprintf("%d-W%02d", year, week): g.ByWeek,
+		start.Format("2006-01"):             g.ByMonth,
+	} {
+		count := buckets[key]
+		count.add(hours)
+		buckets[key] = count
+	}
+}
+
+// round rounds the hours of the group to minutes precision for the output
+func (g *Group) round() {
+	g.Hours = roundHours(g.Hours)
+	for _, buckets := range []map[string]Count{g.ByDay, g.ByWeek, g.ByMonth} {
+		for key, count := range buckets {
+			count.Hours = roundHours(count.Hours)
+			buckets[key] = count
+		}
+	}
+}
+
+// roundHours rounds to two decimals
+func roundHours(hours float64) float64 {
+	return math.Round(hours*100) / 100
+}
+
+// Compute returns the allocation of the occurrences of a merged calendar per
+// calendar (in the order of sources, from X-ICALMERGER-SOURCE) and per
+// category. Events are attributed to the day they start in loc.
+func Compute(occurrences []ical.Occurrence, from, to time.Time, loc *time.Location, sources []string) *Stats {
+	stats := &Stats{Start: from, End: to, Timezone: loc.String()}
+	total := newGroup("total")
+
+	bySource := make(map[string]*Group)
+	for _, name := range sources {
+		bySource[name] = newGroup(name)
+	}
+	byCategory := make(map[string]*Group)
+
+	for _, o := range occurrences {
+		// The info event of empty calendars is not a real event
+		if ical.IsInfoEvent(ical.PropertyValue(o.Event, ics.ComponentPropertyUniqueId)) {
+			continue
+		}
+		start := o.Start.In(loc)
+		hours := 0.0
+		if !o.AllDay {
+			hours = o.End.Sub(o.Start).Hours()
+		}
+		recurring := o.Event.GetProperty(ics.ComponentPropertyRrule) != nil ||
+			o.Event.GetProperty(ics.ComponentPropertyRecurrenceId) != nil
+
+		total.add(start, hours, recurring)
+
+		for _, prop := range o.Event.GetProperties(ical.PropertySource) {
+			group, ok := bySource[prop.Value]
+			if !ok {
+				group = newGroup(prop.Value)
+				bySource[prop.Value] = group
+				sources = append(sources, prop.Value)
+			}
+			group.add(start, hours, recurring)
+		}
+
+		categories := eventCategories(o.Event)
+		if len(categories) == 0 {
+			categories = []string{Uncategorized}
+		}
+		for _, category := range categories {
+			group, ok := byCategory[strings.ToLower(category)]
+			if !ok {
+				group = newGroup(category)
+				byCategory[strings.ToLower(category)] = group
+			}
+			group.add(start, hours, recurring)
+		}
+	}
+
+	for _, name := range sources {
+		stats.Sources = append(stats.Sources, bySource[name])
+	}
+	for _, group := range byCategory {
+		stats.Categories = append(stats.Categories, group)
+	}
+	sort.Slice(stats.Categories, func(i, j int) bool {
+		a, b := stats.Categories[i], stats.Categories[j]
+		if a.Hours != b.Hours {
+			return a.Hours > b.Hours
+		}
+		return a.Name < b.Name
+	})
+
+	for date, count := range total.ByDay {
+		stats.BusiestDays = append(stats.BusiestDays, Day{Date: date, Count: Count{Events: count.Events, Hours: roundHours(count.Hours)}})
+	}
+	sort.Slice(stats.BusiestDays, func(i, j int) bool {
+		a, b := stats.BusiestDays[i], stats.BusiestDays[j]
+		if a.Hours != b.Hours {
+			return a.Hours > b.Hours
+		}
+		if a.Events != b.Events {
+			return a.Events > b.Events
+		}
+		return a.Date < b.Date
+	})
+	if len(stats.BusiestDays) > busiestDays {
+		stats.BusiestDays = stats.BusiestDays[:busiestDays]
+	}
+
+	total.round()
+	stats.Total = *total
+	for _, group := range stats.Sources {
+		group.round()
+	}
+	for _, group := range stats.Categories {
+		group.round()
+	}
+	return stats
+}
+
+// eventCategories returns the distinct categories of an event
+func eventCategories(event *ics.VEvent) []string {
+	seen := make(map[string]bool)
+	var categories []string
+	for _, prop := range event.GetProperties(ics.ComponentPropertyCategories) {
+		for _, category := range strings.Split(prop.Value, ",") {
+			category = strings.TrimSpace(category)
+			if category == "" || seen[strings.ToLower(category)] {
+				continue
+			}
+			seen[strings.ToLower(category)] = true
+			categories = append(categories, category)
+		}
+	}
+	return categories
+}
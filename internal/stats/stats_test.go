@@ -0,0 +1,70 @@
+package stats
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arran4/golang-ical"
+)
+
+// TestCompute tests the per-calendar and per-category counts, the recurring
+// split and the busiest days
+func TestCompute(t *testing.T) {
+	cal, err := ics.ParseCalendar(strings.NewReader(strings.ReplaceAll(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:standup
+SUMMARY:Standup
+CATEGORIES:Work
+X-ICALMERGER-SOURCE:Arthur
+DTSTART:20250106T090000Z
+DTEND:20250106T093000Z
+RRULE:FREQ=DAILY;COUNT=3
+END:VEVENT
+BEGIN:VEVENT
+UID:dinner
+SUMMARY:Dinner
+X-ICALMERGER-SOURCE:Arthur
+X-ICALMERGER-SOURCE:Hannah
+DTSTART:20250107T180000Z
+DTEND:20250107T200000Z
+END:VEVENT
+BEGIN:VEVENT
+UID:trip
+SUMMARY:Trip
+CATEGORIES:Family
+X-ICALMERGER-SOURCE:Hannah
+DTSTART;VALUE=DATE:20250108
+END:VEVENT
+END:VCALENDAR
+`, "\n", "\r\n")))
+	if err != nil {
+		t.Fatalf("Failed to parse test calendar: %v", err)
+	}
+
+	// The info event of empty calendars is not counted
+	cal.AddVEvent(ical.NewInfoEvent(time.Date(2025, 1, 12, 12, 0, 0, 0, time.UTC)))
+	from := time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 1, 13, 0, 0, 0, 0, time.UTC)
+	stats := Compute(ical.ExpandCalendar(cal, from, to, time.UTC), from, to, time.UTC, []string{"Arthur", "Hannah"})
+
+	if stats.Total.Events != 5 || stats.Total.Hours != 3.5 || stats.Total.Recurring != 3 || stats.Total.OneOff != 2 {
+		t.Errorf("Unexpected totals: %+v", stats.Total.Count)
+	}
+	arthur, hannah := stats.Sources[0], stats.Sources[1]
+	if arthur.Events != 4 || arthur.Hours != 3.5 || hannah.Events != 2 || hannah.Hours != 2 {
+		t.Errorf("Unexpected source counts: Arthur %+v, Hannah %+v", arthur.Count, hannah.Count)
+	}
+	if week := arthur.ByWeek["2025-W02"]; week.Events != 4 {
+		t.Errorf("Unexpected weekly count for Arthur: %+v", week)
+	}
+	if stats.Categories[0].Name != "uncategorized" || stats.Categories[1].Name != "Work" {
+		t.Errorf("Categories not ordered by hours: %s, %s", stats.Categories[0].Name, stats.Categories[1].Name)
+	}
+	if stats.BusiestDays[0].Date != "2025-01-07" || stats.BusiestDays[0].Hours != 2.5 {
+		t.Errorf("Unexpected busiest day: %+v", stats.BusiestDays[0])
+	}
+}
@@ -0,0 +1,510 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/config"
+	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arthur/ical_merger/internal/search"
+	"github.com/arran4/golang-ical"
+)
+
+// Event is a merged event as kept by the store. Recurring events are kept
+// once, as written, occurrences are expanded by Feed.Occurrences.
+type Event struct {
+	UID          string    `json:"uid"`
+	RecurrenceID string    `json:"recurrence_id,omitempty"`
+	Summary      string    `json:"summary"`
+	Description  string    `json:"description,omitempty"`
+	Location     string    `json:"location,omitempty"`
+	Status       string    `json:"status,omitempty"`
+	Categories   []string  `json:"categories,omitempty"`
+	Attendees    []string  `json:"attendees,omitempty"`
+	Sources      []string  `json:"sources,omitempty"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	AllDay       bool      `json:"all_day"`
+	Recurring    bool      `json:"recurring"`
+	// VEvent is the event as written to the merged file
+	VEvent *ics.VEvent `json:"-"`
+
+	// recurrenceAt is the RECURRENCE-ID as an instant, zero for other events
+	recurrenceAt time.Time
+}
+
+// Property is a property of an event as written
+type Property struct {
+	Name   string              `json:"name"`
+	Params map[string][]string `json:"params,omitempty"`
+	Value  string              `json:"value"`
+}
+
+// Feed is the merged calendar of one output with its indexes. A feed is
+// never changed once built, a merge replaces it with a new one.
+type Feed struct {
+	Name string
+	// Data is the file as written by the merger
+	Data []byte
+	// Calendar is the parsed file. It is shared by all readers and must not
+	// be changed.
+	Calendar  *ics.Calendar
+	Events    []*Event
+	UpdatedAt time.Time
+
+	loc      *time.Location
+	byUID    map[string][]*Event
+	bySource map[string][]*Event
+	// index finds events by text, its documents are Events in order
+	index *search.Index
+}
+
+// NewFeed parses and indexes a merged file. Floating times are interpreted
+// in loc. The merged file has no attendees, the merger passes those of the
+// source events (see ical.Attendees) to make them searchable, nil for none.
+func NewFeed(name string, data []byte, loc *time.Location, updatedAt time.Time, attendees map[ical.EventKey][]*ics.IANAProperty) (*Feed, error) {
+	cal, err := ical.ParseCalendar(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	ical.NormalizeCalendar(cal)
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	f := &Feed{
+		Name:      name,
+		Data:      data,
+		Calendar:  cal,
+		UpdatedAt: updatedAt,
+		loc:       loc,
+		byUID:     make(map[string][]*Event),
+		bySource:  make(map[string][]*Event),
+	}
+	for _, vevent := range cal.Events() {
+		event := newEvent(vevent, loc)
+		if event == nil {
+			continue
+		}
+		for _, prop := range attendees[ical.EventKey{UID: event.UID, RecurrenceID: event.RecurrenceID}] {
+			event.Attendees = append(event.Attendees, attendee(prop))
+		}
+		f.Events = append(f.Events, event)
+		f.byUID[event.UID] = append(f.byUID[event.UID], event)
+		for _, source := range event.Sources {
+			key := strings.ToLower(source)
+			f.bySource[key] = append(f.bySource[key], event)
+		}
+	}
+
+	docs := make([]search.Document, len(f.Events))
+	for i, event := range f.Events {
+		docs[i] = search.Document{
+			Summary:     event.Summary,
+			Description: event.Description,
+			Location:    event.Location,
+			Attendees:   event.Attendees,
+		}
+	}
+	f.index = search.NewIndex(docs)
+	return f, nil
+}
+
+// newEvent converts an event of the merged file, nil if it has no valid times
+func newEvent(vevent *ics.VEvent, loc *time.Location) *Event {
+	start, end, allDay, err := ical.EventTimes(vevent, loc)
+	if err != nil {
+		return nil
+	}
+	event := &Event{
+		UID:          ical.PropertyValue(vevent, ics.ComponentPropertyUniqueId),
+		RecurrenceID: ical.PropertyValue(vevent, ics.ComponentPropertyRecurrenceId),
+		Summary:      ical.PropertyValue(vevent, ics.ComponentPropertySummary),
+		Description:  ical.PropertyValue(vevent, ics.ComponentPropertyDescription),
+		Location:     ical.PropertyValue(vevent, ics.ComponentPropertyLocation),
+		Status:       strings.ToLower(ical.PropertyValue(vevent, ics.ComponentPropertyStatus)),
+		Start:        start,
+		End:          end,
+		AllDay:       allDay,
+		Recurring:    vevent.GetProperty(ics.ComponentPropertyRrule) != nil,
+		VEvent:       vevent,
+	}
+	for _, prop := range vevent.GetProperties(ics.ComponentPropertyCategories) {
+		for _, category := range strings.Split(prop.Value, ",") {
+			if category = strings.TrimSpace(category); category != "" {
+				event.Categories = append(event.Categories, category)
+			}
+		}
+	}
+	for _, prop := range vevent.GetProperties(ical.PropertySource) {
+		event.Sources = append(event.Sources, prop.Value)
+	}
+	if prop := vevent.GetProperty(ics.ComponentPropertyRecurrenceId); prop != nil {
+		event.recurrenceAt, _ = recurrenceTime(prop.Value, prop.ICalParameters, loc)
+	}
+	return event
+}
+
+// Event returns the events with a UID: the event itself and the overrides
+// of its occurrences
+func (f *Feed) Event(uid string) []*Event {
+	return f.byUID[uid]
+}
+
+// Source returns the events of a calendar, matched case-insensitively
+func (f *Feed) Source(name string) []*Event {
+	return f.bySource[strings.ToLower(name)]
+}
+
+// Find returns the event with a UID, or the override of its occurrence with
+// the RECURRENCE-ID recurrenceID. The ID is compared as an instant, it may be
+// written as in the file (20250108T090000Z or 20250108) or in RFC 3339.
+// Find returns nil if there is no such event.
+func (f *Feed) Find(uid, recurrenceID string) *Event {
+	var at time.Time
+	if recurrenceID != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, recurrenceID); err != nil {
+			if at, err = recurrenceTime(recurrenceID, nil, f.loc); err != nil {
+				return nil
+			}
+		}
+	}
+	for _, event := range f.byUID[uid] {
+		if recurrenceID == "" && event.RecurrenceID == "" {
+			return event
+		}
+		if recurrenceID != "" && (event.RecurrenceID == recurrenceID || (!event.recurrenceAt.IsZero() && event.recurrenceAt.Equal(at))) {
+			return event
+		}
+	}
+	return nil
+}
+
+// Properties returns the properties of the event as written, in order
+func (e *Event) Properties() []Property {
+	return properties(e.VEvent.Properties)
+}
+
+// Alarms returns the properties of each VALARM of the event
+func (e *Event) Alarms() [][]Property {
+	var alarms [][]Property
+	for _, alarm := range e.VEvent.Alarms() {
+		alarms = append(alarms, properties(alarm.Properties))
+	}
+	return alarms
+}
+
+// properties converts properties for the API
+func properties(props []ics.IANAProperty) []Property {
+	result := make([]Property, 0, len(props))
+	for _, prop := range props {
+		result = append(result, Property{Name: prop.IANAToken, Params: prop.ICalParameters, Value: prop.Value})
+	}
+	return result
+}
+
+// recurrenceTime parses a RECURRENCE-ID value with its parameters
+func recurrenceTime(value string, params map[string][]string, loc *time.Location) (time.Time, error) {
+	// EventTimes reads the value like a DTSTART
+	event := &ics.VEvent{}
+	var props []ics.PropertyParameter
+	for key, values := range params {
+		props = append(props, &ics.KeyValues{Key: key, Value: values})
+	}
+	event.SetProperty(ics.ComponentPropertyDtStart, value, props...)
+	start, _, _, err := ical.EventTimes(event, loc)
+	return start, err
+}
+
+// Query selects the occurrences of a feed. Empty fields match everything.
+type Query struct {
+	// From and To limit the occurrences to those overlapping [From, To)
+	From, To time.Time
+	// Sources are calendar names, matched case-insensitively
+	Sources []string
+	UID     string
+	// Text is a search query, see Search
+	Text string
+}
+
+// Occurrences returns the occurrences of the events matching q, ordered by
+// start time. Cancelled events and occurrences are left out.
+func (f *Feed) Occurrences(q Query) []ical.Occurrence {
+	candidates := f.Events
+	if q.UID != "" {
+		candidates = f.byUID[q.UID]
+	}
+	if len(q.Sources) > 0 {
+		wanted := make(map[*Event]bool)
+		for _, source := range q.Sources {
+			for _, event := range f.bySource[strings.ToLower(strings.TrimSpace(source))] {
+				wanted[event] = true
+			}
+		}
+		var selected []*Event
+		for _, event := range candidates {
+			if wanted[event] {
+				selected = append(selected, event)
+			}
+		}
+		candidates = selected
+	}
+	var found map[*Event]bool
+	if strings.TrimSpace(q.Text) != "" {
+		found = make(map[*Event]bool)
+		for _, result := range f.Search(q.Text) {
+			found[result.Event] = true
+		}
+	}
+
+	// Overrides of a matching series are expanded too, they replace their
+	// occurrence even when they don't match themselves
+	matched := make(map[*ics.VEvent]bool)
+	series := make(map[string]bool)
+	for _, event := range candidates {
+		if (found != nil && !found[event]) || !event.overlaps(q.From, q.To) {
+			continue
+		}
+		matched[event.VEvent] = true
+		series[event.UID] = true
+	}
+	expanded := &ics.Calendar{CalendarProperties: f.Calendar.CalendarProperties}
+	for _, event := range f.Events {
+		if series[event.UID] {
+			expanded.Components = append(expanded.Components, event.VEvent)
+		}
+	}
+
+	from, to := q.From, q.To
+	if to.IsZero() {
+		// Without an end, recurring events are expanded for a year from now
+		// (or from From) while single events are all included
+		to = time.Now().AddDate(1, 0, 0)
+		if from.After(to) {
+			to = from.AddDate(1, 0, 0)
+		}
+		for _, event := range candidates {
+			if matched[event.VEvent] && !event.Recurring && !event.Start.Before(to) {
+				to = event.Start.Add(time.Second)
+			}
+		}
+	}
+	var occurrences []ical.Occurrence
+	for _, o := range ical.ExpandCalendar(expanded, from, to, f.loc) {
+		if matched[o.Event] {
+			occurrences = append(occurrences, o)
+		}
+	}
+	return occurrences
+}
+
+// Filter selects whole events of a feed for the calendar feeds. Empty
+// fields match everything.
+type Filter struct {
+	// From and To keep the events with an occurrence overlapping [From, To)
+	From, To time.Time
+	// Sources and Categories are matched case-insensitively
+	Sources    []string
+	Categories []string
+	// Text is a search query, see Search
+	Text          string
+	ExcludeAllDay bool
+	// Statuses are lower-case STATUS values, events without one are "confirmed"
+	Statuses []string
+}
+
+// Active reports whether the filter selects anything at all
+func (flt Filter) Active() bool {
+	return !flt.From.IsZero() || !flt.To.IsZero() || len(flt.Sources) > 0 || len(flt.Categories) > 0 ||
+		strings.TrimSpace(flt.Text) != "" || flt.ExcludeAllDay || len(flt.Statuses) > 0
+}
+
+// Select returns a calendar with the events of the feed matching the filter,
+// as written, with the calendar properties and time zones of the feed.
+// Recurring events are kept whole if any occurrence is in the period.
+func (f *Feed) Select(flt Filter) *ics.Calendar {
+	if !flt.Active() {
+		return f.Calendar
+	}
+
+	var found map[*Event]bool
+	if strings.TrimSpace(flt.Text) != "" {
+		found = make(map[*Event]bool)
+		for _, result := range f.Search(flt.Text) {
+			found[result.Event] = true
+		}
+	}
+	// An open period ends ten years on, far enough for any feed while
+	// keeping endless recurrences cheap to expand
+	from, to := flt.From, flt.To
+	if to.IsZero() {
+		to = from.AddDate(10, 0, 0)
+	}
+
+	selected := make(map[*ics.VEvent]bool)
+	for _, event := range f.Events {
+		if found != nil && !found[event] {
+			continue
+		}
+		if flt.ExcludeAllDay && event.AllDay {
+			continue
+		}
+		if !matchesAny(event.Sources, flt.Sources) || !matchesAny(event.Categories, flt.Categories) {
+			continue
+		}
+		status := event.Status
+		if status == "" {
+			status = "confirmed"
+		}
+		if !matchesAny([]string{status}, flt.Statuses) {
+			continue
+		}
+		// Cancelled events are checked too, unlike in Occurrences
+		if (!flt.From.IsZero() || !flt.To.IsZero()) && len(ical.ExpandEvent(event.VEvent, from, to, f.loc)) == 0 {
+			continue
+		}
+		selected[event.VEvent] = true
+	}
+
+	cal := &ics.Calendar{CalendarProperties: f.Calendar.CalendarProperties}
+	for _, component := range f.Calendar.Components {
+		if event, ok := component.(*ics.VEvent); ok && !selected[event] {
+			continue
+		}
+		cal.Components = append(cal.Components, component)
+	}
+	return cal
+}
+
+// matchesAny reports whether any value is wanted, case-insensitively. Every
+// value is wanted if none are given.
+func matchesAny(values, wanted []string) bool {
+	if len(wanted) == 0 {
+		return true
+	}
+	for _, value := range values {
+		for _, w := range wanted {
+			if strings.EqualFold(value, strings.TrimSpace(w)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Result is an event found by a search
+type Result struct {
+	Event *Event
+	Score float64
+	// Highlights are HTML snippets of the matching fields with the hits in <mark>
+	Highlights map[string]string
+}
+
+// Search finds the events matching a query in their summary, description,
+// location or attendees, best matches first. All words of the query must
+// match, "quoted words" match as a phrase and word* matches as a prefix.
+func (f *Feed) Search(query string) []Result {
+	var results []Result
+	for _, match := range f.index.Search(query) {
+		results = append(results, Result{Event: f.Events[match.Doc], Score: match.Score, Highlights: match.Highlights})
+	}
+	return results
+}
+
+// overlaps reports whether the event may have an occurrence in [from, to).
+// Recurring events always may, their occurrences are checked on expansion.
+func (e *Event) overlaps(from, to time.Time) bool {
+	if e.Recurring {
+		return to.IsZero() || e.Start.Before(to)
+	}
+	return (to.IsZero() || e.Start.Before(to)) && e.End.After(from)
+}
+
+// Store keeps the feeds of all outputs in memory so that requests don't
+// reparse the merged files
+type Store struct {
+	mu    sync.RWMutex
+	feeds map[string]*Feed
+}
+
+// New creates an empty store
+func New() *Store {
+	return &Store{feeds: make(map[string]*Feed)}
+}
+
+// Feed returns the feed of an output by name ("" for the default output),
+// nil before its first merge
+func (s *Store) Feed(name string) *Feed {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.feeds[name]
+}
+
+// Lookup returns the feed of an output or an error if it has not been merged yet
+func (s *Store) Lookup(output config.Output) (*Feed, error) {
+	feed := s.Feed(output.Name)
+	if feed == nil {
+		return nil, fmt.Errorf("no merged calendar for %s yet", output.Path)
+	}
+	return feed, nil
+}
+
+// Replace swaps in the feeds of a merge at once, readers see either all the
+// old feeds or all the new ones
+func (s *Store) Replace(feeds ...*Feed) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, feed := range feeds {
+		s.feeds[feed.Name] = feed
+	}
+}
+
+// Load fills the store from the merged files on disk, so the events of the
+// last merge are served right after a restart. Missing or unreadable files
+// are skipped, the next merge writes them. The files have no attendees, these
+// are only indexed again by the next merge.
+func (s *Store) Load(outputs []config.Output) {
+	var feeds []*Feed
+	for _, output := range outputs {
+		info, err := os.Stat(output.Path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("Store: error reading %s: %v", output.Path, err)
+			}
+			continue
+		}
+		data, err := os.ReadFile(output.Path)
+		if err != nil {
+			log.Printf("Store: error reading %s: %v", output.Path, err)
+			continue
+		}
+		loc, err := time.LoadLocation(output.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		// The attendees come from the sources, they are indexed again by the next merge
+		feed, err := NewFeed(output.Name, data, loc, info.ModTime(), nil)
+		if err != nil {
+			log.Printf("Store: error parsing %s: %v", output.Path, err)
+			continue
+		}
+		log.Printf("Store: loaded %d events from %s", len(feed.Events), output.Path)
+		feeds = append(feeds, feed)
+	}
+	s.Replace(feeds...)
+}
+
+// attendee names an attendee by its name and address, e.g. "Jane Doe <jane@example.com>"
+func attendee(prop *ics.IANAProperty) string {
+	address := strings.TrimPrefix(strings.TrimPrefix(prop.Value, "mailto:"), "MAILTO:")
+	if names := prop.ICalParameters["CN"]; len(names) > 0 && names[0] != "" && names[0] != address {
+		return names[0] + " <" + address + ">"
+	}
+	return address
+}
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/config"
+	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arran4/golang-ical"
+)
+
+const testCalendar = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//TEST//EN
+BEGIN:VEVENT
+UID:standup
+SUMMARY:[Arthur] Standup
+DESCRIPTION:Daily sync
+X-ICALMERGER-SOURCE:Arthur
+DTSTART:20250106T090000Z
+DTEND:20250106T091500Z
+RRULE:FREQ=DAILY;COUNT=5
+END:VEVENT
+BEGIN:VEVENT
+UID:standup
+RECURRENCE-ID:20250108T090000Z
+SUMMARY:[Arthur] Late standup
+X-ICALMERGER-SOURCE:Arthur
+DTSTART:20250108T110000Z
+DTEND:20250108T111500Z
+END:VEVENT
+BEGIN:VEVENT
+UID:yoga
+SUMMARY:[Hannah] Yoga
+LOCATION:Studio
+X-ICALMERGER-SOURCE:Hannah
+DTSTART:20250107T180000Z
+DTEND:20250107T190000Z
+END:VEVENT
+END:VCALENDAR
+`
+
+// starts formats the start times of occurrences for comparison
+func starts(t *testing.T, feed *Feed, q Query) string {
+	t.Helper()
+	var result []string
+	for _, o := range feed.Occurrences(q) {
+		result = append(result, o.Start.UTC().Format("Jan 2 15:04"))
+	}
+	return strings.Join(result, ", ")
+}
+
+// TestStore tests queries by time range, source, UID and text, and that a
+// restart loads the feeds from the merged files
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "merged.ics")
+	if err := os.WriteFile(path, []byte(strings.ReplaceAll(testCalendar, "\n", "\r\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{OutputPath: path, OutputTimezone: "UTC"}
+
+	events := New()
+	if events.Feed("") != nil {
+		t.Fatal("Expected no feed before loading")
+	}
+	events.Load(cfg.AllOutputs())
+	feed, err := events.Lookup(cfg.DefaultOutput())
+	if err != nil {
+		t.Fatalf("Failed to load the feed: %v", err)
+	}
+	if len(feed.Events) != 3 || len(feed.Event("standup")) != 2 {
+		t.Fatalf("Expected 3 events, 2 of them for standup, got %d", len(feed.Events))
+	}
+
+	week := Query{From: time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC), To: time.Date(2025, 1, 13, 0, 0, 0, 0, time.UTC)}
+	tests := []struct {
+		name  string
+		query func(q Query) Query
+		want  string
+	}{
+		{"range", func(q Query) Query { return q },
+			"Jan 6 09:00, Jan 7 09:00, Jan 7 18:00, Jan 8 11:00, Jan 9 09:00, Jan 10 09:00"},
+		{"narrow range", func(q Query) Query { q.From, q.To = q.From.AddDate(0, 0, 1), q.From.AddDate(0, 0, 2); return q },
+			"Jan 7 09:00, Jan 7 18:00"},
+		{"source", func(q Query) Query { q.Sources = []string{"hannah"}; return q }, "Jan 7 18:00"},
+		{"uid", func(q Query) Query { q.UID = "standup"; return q },
+			"Jan 6 09:00, Jan 7 09:00, Jan 8 11:00, Jan 9 09:00, Jan 10 09:00"},
+		// The override doesn't match but still replaces its occurrence
+		{"text", func(q Query) Query { q.Text = "daily"; return q }, "Jan 6 09:00, Jan 7 09:00, Jan 9 09:00, Jan 10 09:00"},
+		{"override", func(q Query) Query { q.Text = "late"; return q }, "Jan 8 11:00"},
+		{"words", func(q Query) Query { q.Text = "yoga studio"; return q }, "Jan 7 18:00"},
+		{"no match", func(q Query) Query { q.Text = "yoga office"; return q }, ""},
+	}
+	for _, tt := range tests {
+		if got := starts(t, feed, tt.query(week)); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// Overrides are found by their RECURRENCE-ID in any format
+	for recurrenceID, want := range map[string]string{
+		"":                          "[Arthur] Standup",
+		"20250108T090000Z":          "[Arthur] Late standup",
+		"2025-01-08T10:00:00+01:00": "[Arthur] Late standup",
+	} {
+		if event := feed.Find("standup", recurrenceID); event == nil || event.Summary != want {
+			t.Errorf("Find(standup, %q) = %v, want %s", recurrenceID, event, want)
+		}
+	}
+	if feed.Find("standup", "20250109T090000Z") != nil || feed.Find("unknown", "") != nil {
+		t.Error("Expected no event for unknown IDs")
+	}
+	if got := len(feed.Source("ARTHUR")); got != 2 {
+		t.Errorf("Expected 2 events of Arthur, got %d", got)
+	}
+
+	// Select keeps whole events, a series with any occurrence in the period
+	summaries := func(flt Filter) string {
+		var result []string
+		for _, event := range feed.Select(flt).Events() {
+			result = append(result, event.GetProperty("SUMMARY").Value)
+		}
+		return strings.Join(result, ", ")
+	}
+	jan8 := time.Date(2025, 1, 8, 0, 0, 0, 0, time.UTC)
+	filters := []struct {
+		name   string
+		filter Filter
+		want   string
+	}{
+		{"all", Filter{}, "[Arthur] Standup, [Arthur] Late standup, [Hannah] Yoga"},
+		{"period", Filter{From: jan8, To: jan8.AddDate(0, 0, 1)}, "[Arthur] Standup, [Arthur] Late standup"},
+		{"after the series", Filter{From: jan8.AddDate(0, 0, 3)}, ""},
+		{"source", Filter{Sources: []string{"HANNAH"}}, "[Hannah] Yoga"},
+		{"text", Filter{Text: "stand*"}, "[Arthur] Standup, [Arthur] Late standup"},
+		{"status", Filter{Statuses: []string{"cancelled"}}, ""},
+	}
+	for _, tt := range filters {
+		if got := summaries(tt.filter); got != tt.want {
+			t.Errorf("Select %s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// A replaced feed is what readers see from then on
+	updated, err := NewFeed("", []byte(strings.ReplaceAll(testCalendar, "Yoga", "Pilates")), time.UTC, time.Now(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events.Replace(updated)
+	if got := events.Feed("").Event("yoga")[0].Summary; got != "[Hannah] Pilates" {
+		t.Errorf("Expected the replaced feed, got %q", got)
+	}
+
+	// Attendees of the source events are searchable without being in the file
+	attendees := map[ical.EventKey][]*ics.IANAProperty{
+		{UID: "yoga"}: {{BaseProperty: ics.BaseProperty{IANAToken: "ATTENDEE", Value: "mailto:jane@example.com", ICalParameters: map[string][]string{"CN": {"Jane Doe"}}}}},
+	}
+	indexed, err := NewFeed("", []byte(testCalendar), time.UTC, time.Now(), attendees)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results := indexed.Search("jane"); len(results) != 1 || results[0].Event.UID != "yoga" {
+		t.Errorf("Expected yoga for an attendee search, got %v", results)
+	} else if got := results[0].Event.Attendees; len(got) != 1 || got[0] != "Jane Doe <jane@example.com>" {
+		t.Errorf("Unexpected attendees %v", got)
+	}
+}
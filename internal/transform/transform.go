@@ -0,0 +1,256 @@
+package transform
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/config"
+	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arran4/golang-ical"
+)
+
+// Supported transform operations
+const (
+	OpReplace         = config.TransformReplace
+	OpSet             = config.TransformSet
+	OpRemove          = config.TransformRemove
+	OpMove            = config.TransformMove
+	OpNormalizeAllDay = config.TransformNormalizeAllDay
+)
+
+// Pipeline rewrites the events of a calendar with a list of transforms
+type Pipeline struct {
+	steps []step
+	loc   *time.Location
+	// normalize is set when the pipeline turns events into all-day events
+	normalize bool
+}
+
+// step is a compiled transform, it reports whether it changed the event
+type step func(event *ics.VEvent, loc *time.Location) bool
+
+// New compiles the transforms of a calendar. Times of UTC events are
+// evaluated in loc when normalizing all-day events.
+func New(transforms []config.Transform, loc *time.Location) (*Pipeline, error) {
+	p := &Pipeline{loc: loc}
+	for i, t := range transforms {
+		s, err := compile(t)
+		if err != nil {
+			return nil, fmt.Errorf("transform #%d: %w", i+1, err)
+		}
+		p.steps = append(p.steps, s)
+		p.normalize = p.normalize || t.Op == OpNormalizeAllDay
+	}
+	return p, nil
+}
+
+// Apply runs all transforms on every event of cal and returns the number of changed events
+func (p *Pipeline) Apply(cal *ics.Calendar) int {
+	if len(p.steps) == 0 {
+		return 0
+	}
+
+	changed := make(map[*ics.VEvent]bool)
+	var overrides []*ics.VEvent
+	allDaySeries := make(map[string]bool)
+	for _, event := range cal.Events() {
+		for _, s := range p.steps {
+			if s(event, p.loc) {
+				changed[event] = true
+			}
+		}
+		if !p.normalize {
+			continue
+		}
+		if event.GetProperty(ics.ComponentPropertyRecurrenceId) != nil {
+			overrides = append(overrides, event)
+		} else if event.GetProperty(ics.ComponentPropertyRrule) != nil && isDate(event.GetProperty(ics.ComponentPropertyDtStart)) {
+			allDaySeries[event.Id()] = true
+		}
+	}
+
+	// Overrides of all-day series are matched by date, also when the
+	// override itself was not turned into an all-day event
+	for _, event := range overrides {
+		if allDaySeries[event.Id()] && toDate(event.GetProperty(ics.ComponentPropertyRecurrenceId), p.loc) {
+			changed[event] = true
+		}
+	}
+	return len(changed)
+}
+
+// compile turns a config transform into a step. The transform is checked
+// like the config does when it is loaded.
+func compile(t config.Transform) (step, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	property := ics.ComponentProperty(strings.ToUpper(t.Property))
+	pattern, _ := t.PatternRegexp()
+
+	switch t.Op {
+	case OpReplace:
+		return func(event *ics.VEvent, _ *time.Location) bool {
+			changed := false
+			for _, prop := range event.GetProperties(property) {
+				if value := pattern.ReplaceAllString(prop.Value, t.Replacement); value != prop.Value {
+					prop.Value = strings.TrimSpace(value)
+					changed = true
+				}
+			}
+			return changed
+		}, nil
+
+	case OpSet:
+		return func(event *ics.VEvent, _ *time.Location) bool {
+			if prop := event.GetProperty(property); prop != nil && prop.Value == t.Value {
+				return false
+			}
+			event.SetProperty(property, t.Value)
+			return true
+		}, nil
+
+	case OpRemove:
+		return func(event *ics.VEvent, _ *time.Location) bool {
+			return len(event.RemoveProperty(property)) > 0
+		}, nil
+
+	case OpMove:
+		from := ics.ComponentProperty(strings.ToUpper(t.From))
+		to := ics.ComponentProperty(strings.ToUpper(t.To))
+		return func(event *ics.VEvent, _ *time.Location) bool {
+			return moveText(event, from, to, pattern)
+		}, nil
+
+	case OpNormalizeAllDay:
+		tolerance, _ := t.ToleranceDuration()
+		return func(event *ics.VEvent, loc *time.Location) bool {
+			return normalizeAllDay(event, loc, tolerance)
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown operation %q", t.Op)
+}
+
+// moveText moves text from one property to another. With a pattern only the
+// match is moved (its first capture group if it has one), otherwise the whole
+// value. The target property is replaced.
+func moveText(event *ics.VEvent, from, to ics.ComponentProperty, pattern *regexp.Regexp) bool {
+	source := event.GetProperty(from)
+	if source == nil {
+		return false
+	}
+
+	if pattern == nil {
+		value := source.Value
+		event.RemoveProperty(from)
+		event.SetProperty(to, value)
+		return true
+	}
+
+	match := pattern.FindStringSubmatchIndex(source.Value)
+	if match == nil {
+		return false
+	}
+
+	extracted := source.Value[match[0]:match[1]]
+	if len(match) >= 4 && match[2] >= 0 {
+		extracted = source.Value[match[2]:match[3]]
+	}
+	remaining := strings.TrimSpace(source.Value[:match[0]] + source.Value[match[1]:])
+
+	if remaining == "" {
+		event.RemoveProperty(from)
+	} else {
+		source.Value = remaining
+	}
+	event.SetProperty(to, strings.TrimSpace(extracted))
+	return true
+}
+
+// normalizeAllDay turns timed events spanning whole days, like 00:00-23:59,
+// into VALUE=DATE events
+func normalizeAllDay(event *ics.VEvent, loc *time.Location, tolerance time.Duration) bool {
+	start, end, allDay, err := ical.EventTimes(event, loc)
+	if err != nil || allDay {
+		return false
+	}
+
+	// UTC values are judged by the wall clock of the output timezone
+	if start.Location() == time.UTC {
+		start, end = start.In(loc), end.In(loc)
+	}
+
+	startDay := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+	if start.Sub(startDay) > tolerance {
+		return false
+	}
+
+	// The end must be close to a midnight, either just before or just after it
+	endDay := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, end.Location())
+	nextDay := endDay.AddDate(0, 0, 1)
+	switch {
+	case end.Sub(endDay) <= tolerance:
+	case nextDay.Sub(end) <= tolerance:
+		endDay = nextDay
+	default:
+		return false
+	}
+	if !endDay.After(startDay) {
+		return false
+	}
+
+	event.SetProperty(ics.ComponentPropertyDtStart, startDay.Format("20060102"), ics.WithValue(string(ics.ValueDataTypeDate)))
+	event.SetProperty(ics.ComponentPropertyDtEnd, endDay.Format("20060102"), ics.WithValue(string(ics.ValueDataTypeDate)))
+	event.RemoveProperty(ics.ComponentPropertyDuration)
+
+	// Excluded and overridden occurrences must be dates too, or they no
+	// longer match the occurrences of the series
+	for _, exdate := range event.GetProperties(ics.ComponentPropertyExdate) {
+		toDate(exdate, loc)
+	}
+	if recurrenceID := event.GetProperty(ics.ComponentPropertyRecurrenceId); recurrenceID != nil {
+		toDate(recurrenceID, loc)
+	}
+	return true
+}
+
+// isDate reports whether a property has a DATE value
+func isDate(prop *ics.IANAProperty) bool {
+	if prop == nil {
+		return false
+	}
+	if value := prop.ICalParameters["VALUE"]; len(value) > 0 {
+		return strings.EqualFold(value[0], string(ics.ValueDataTypeDate))
+	}
+	return len(prop.Value) == 8
+}
+
+// toDate turns the date-time values of a property, like an EXDATE list,
+// into DATE values. UTC times are taken at their date in loc, other times
+// at their own date. It reports whether the property changed.
+func toDate(prop *ics.IANAProperty, loc *time.Location) bool {
+	if isDate(prop) {
+		return false
+	}
+	var dates []string
+	for _, value := range strings.Split(prop.Value, ",") {
+		value = strings.TrimSpace(value)
+		if t, err := time.Parse("20060102T150405Z", value); err == nil {
+			value = t.In(loc).Format("20060102")
+		}
+		if len(value) < 8 {
+			return false
+		}
+		dates = append(dates, value[:8])
+	}
+	prop.Value = strings.Join(dates, ",")
+	if prop.ICalParameters == nil {
+		prop.ICalParameters = make(map[string][]string)
+	}
+	delete(prop.ICalParameters, "TZID")
+	prop.ICalParameters["VALUE"] = []string{string(ics.ValueDataTypeDate)}
+	return true
+}
@@ -0,0 +1,150 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/config"
+	"github.com/arthur/ical_merger/internal/ical"
+	"github.com/arran4/golang-ical"
+)
+
+// TestPipelineApply tests replace, move and all-day normalization
+func TestPipelineApply(t *testing.T) {
+	cal, err := ics.ParseCalendar(strings.NewReader(strings.ReplaceAll(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//ical_merger//TEST//EN
+BEGIN:VEVENT
+UID:forwarded
+SUMMARY:FW: Parent evening
+DESCRIPTION:Room: B12\nPlease be on time
+DTSTART;TZID=Europe/Berlin:20250114T190000
+DTEND;TZID=Europe/Berlin:20250114T210000
+END:VEVENT
+BEGIN:VEVENT
+UID:holiday
+SUMMARY:Holiday
+DTSTART;TZID=Europe/Berlin:20250120T000000
+DTEND;TZID=Europe/Berlin:20250121T235900
+END:VEVENT
+END:VCALENDAR
+`, "\n", "\r\n")))
+	if err != nil {
+		t.Fatalf("Failed to parse test calendar: %v", err)
+	}
+
+	loc, _ := time.LoadLocation("Europe/Berlin")
+	pipeline, err := New([]config.Transform{
+		{Op: OpReplace, Property: "summary", Pattern: `^FW:\s*`},
+		{Op: OpMove, From: "DESCRIPTION", To: "LOCATION", Pattern: `Room:\s*(\S+)`},
+		{Op: OpNormalizeAllDay},
+	}, loc)
+	if err != nil {
+		t.Fatalf("Failed to compile transforms: %v", err)
+	}
+
+	if changed := pipeline.Apply(cal); changed != 2 {
+		t.Errorf("Expected 2 changed events, got %d", changed)
+	}
+
+	for _, event := range cal.Events() {
+		switch event.Id() {
+		case "forwarded":
+			if got := event.GetProperty(ics.ComponentPropertySummary).Value; got != "Parent evening" {
+				t.Errorf("Unexpected summary %q", got)
+			}
+			if got := event.GetProperty(ics.ComponentPropertyLocation).Value; got != "B12" {
+				t.Errorf("Unexpected location %q", got)
+			}
+			if got := event.GetProperty(ics.ComponentPropertyDescription).Value; got != "Please be on time" {
+				t.Errorf("Unexpected description %q", got)
+			}
+		case "holiday":
+			start := event.GetProperty(ics.ComponentPropertyDtStart)
+			end := event.GetProperty(ics.ComponentPropertyDtEnd)
+			if start.Value != "20250120" || end.Value != "20250122" {
+				t.Errorf("Event not normalized: %s - %s", start.Value, end.Value)
+			}
+		}
+	}
+}
+
+// TestNewRejectsUnknownOperation tests that typos in transforms are reported
+func TestNewRejectsUnknownOperation(t *testing.T) {
+	if _, err := New([]config.Transform{{Op: "rename"}}, time.UTC); err == nil {
+		t.Errorf("Expected an error for an unknown operation")
+	}
+}
+
+// TestNormalizeAllDayRecurring tests that the excluded and overridden
+// occurrences of a normalized series still match its occurrences
+func TestNormalizeAllDayRecurring(t *testing.T) {
+	cal, err := ics.ParseCalendar(strings.NewReader(strings.ReplaceAll(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//ical_merger//TEST//EN
+BEGIN:VEVENT
+UID:office-day
+SUMMARY:Office day
+DTSTART;TZID=Europe/Berlin:20250106T000000
+DTEND;TZID=Europe/Berlin:20250106T235900
+RRULE:FREQ=WEEKLY;COUNT=4
+EXDATE;TZID=Europe/Berlin:20250113T000000
+END:VEVENT
+BEGIN:VEVENT
+UID:office-day
+RECURRENCE-ID;TZID=Europe/Berlin:20250120T000000
+SUMMARY:Office day
+DTSTART;TZID=Europe/Berlin:20250121T000000
+DTEND;TZID=Europe/Berlin:20250121T235900
+END:VEVENT
+BEGIN:VEVENT
+UID:office-day
+RECURRENCE-ID:20250126T230000Z
+SUMMARY:Office morning
+DTSTART;TZID=Europe/Berlin:20250127T090000
+DTEND;TZID=Europe/Berlin:20250127T120000
+END:VEVENT
+END:VCALENDAR
+`, "\n", "\r\n")))
+	if err != nil {
+		t.Fatalf("Failed to parse test calendar: %v", err)
+	}
+
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("Timezone data not available: %v", err)
+	}
+	pipeline, err := New([]config.Transform{{Op: OpNormalizeAllDay}}, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed := pipeline.Apply(cal); changed != 3 {
+		t.Errorf("Expected 3 changed events, got %d", changed)
+	}
+
+	events := cal.Events()
+	for _, prop := range []*ics.IANAProperty{
+		events[0].GetProperty(ics.ComponentPropertyExdate),
+		events[1].GetProperty(ics.ComponentPropertyRecurrenceId),
+		events[2].GetProperty(ics.ComponentPropertyRecurrenceId),
+	} {
+		if len(prop.Value) != 8 || prop.ICalParameters["VALUE"][0] != "DATE" || prop.ICalParameters["TZID"] != nil {
+			t.Errorf("Expected a DATE value for %s, got %s %v", prop.IANAToken, prop.Value, prop.ICalParameters)
+		}
+	}
+
+	// The excluded Monday is gone, the overrides replace their Mondays
+	var occurrences []string
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, loc)
+	for _, o := range ical.ExpandCalendar(cal, from, from.AddDate(0, 1, 0), loc) {
+		if o.AllDay {
+			occurrences = append(occurrences, o.Start.Format("Jan 2"))
+		} else {
+			occurrences = append(occurrences, o.Start.In(loc).Format("Jan 2 15:04"))
+		}
+	}
+	if got, want := strings.Join(occurrences, ", "), "Jan 6, Jan 21, Jan 27 09:00"; got != want {
+		t.Errorf("Unexpected occurrences %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,224 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/config"
+	"github.com/arthur/ical_merger/internal/history"
+)
+
+// Defaults for hooks that don't configure them
+const (
+	DefaultRetries = 3
+	DefaultTimeout = 10 * time.Second
+)
+
+// Request headers
+const (
+	HeaderEvent     = "X-ICalMerger-Event"
+	HeaderSignature = "X-ICalMerger-Signature"
+)
+
+// defaultTypes are the changes posted when a hook doesn't list any:
+// everything but details-changed
+var defaultTypes = []string{history.Added, history.Removed, history.TimeChanged}
+
+// Payload is the JSON body posted for each change
+type Payload struct {
+	history.Change
+	// Source is the calendar the event came from, the first one if it is in several
+	Source  string   `json:"source"`
+	Sources []string `json:"sources"`
+}
+
+// hook is a webhook with its filters resolved
+type hook struct {
+	url     string
+	output  string
+	secret  string
+	sources map[string]bool
+	types   map[string]bool
+	retries int
+	timeout time.Duration
+}
+
+// Dispatcher posts changes to the configured webhooks
+type Dispatcher struct {
+	hooks  []hook
+	client *http.Client
+	// backoff is the delay before the first retry, doubled for each further retry
+	backoff time.Duration
+	wg      sync.WaitGroup
+}
+
+// New creates a dispatcher for the configured webhooks
+func New(hooks []config.Webhook) *Dispatcher {
+	d := &Dispatcher{client: &http.Client{}, backoff: time.Second}
+	for _, cfg := range hooks {
+		h := hook{
+			url:     cfg.URL,
+			output:  cfg.Output,
+			secret:  cfg.Secret,
+			sources: make(map[string]bool),
+			types:   make(map[string]bool),
+			retries: DefaultRetries,
+			timeout: DefaultTimeout,
+		}
+		for _, source := range cfg.Sources {
+			h.sources[source] = true
+		}
+		types := cfg.Types
+		if len(types) == 0 {
+			types = defaultTypes
+		}
+		for _, changeType := range types {
+			h.types[changeType] = true
+		}
+		if cfg.Retries != nil {
+			h.retries = *cfg.Retries
+		}
+		if timeout, err := cfg.TimeoutDuration(); err == nil && timeout > 0 {
+			h.timeout = timeout
+		}
+		d.hooks = append(d.hooks, h)
+	}
+	return d
+}
+
+// Notify posts the matching changes to each hook in the background. The
+// changes of a hook are delivered in order, one request per change.
+func (d *Dispatcher) Notify(changes []history.Change) {
+	for _, h := range d.hooks {
+		var payloads []Payload
+		for _, change := range changes {
+			if payload, ok := h.match(change); ok {
+				payloads = append(payloads, payload)
+			}
+		}
+		if len(payloads) == 0 {
+			continue
+		}
+
+		d.wg.Add(1)
+		go func(h hook) {
+			defer d.wg.Done()
+			for _, payload := range payloads {
+				if err := d.deliver(h, payload); err != nil {
+					log.Printf("Webhook %s: giving up on %s of %s: %v", h.url, payload.Type, payload.UID, err)
+				}
+			}
+		}(h)
+	}
+}
+
+// Wait blocks until all pending deliveries are done
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// match returns the payload of a change if the hook wants it
+func (h hook) match(change history.Change) (Payload, bool) {
+	if change.Output != h.output || !h.types[change.Type] {
+		return Payload{}, false
+	}
+
+	// An event moved between calendars matches both the old and the new one
+	var sources []string
+	seen := make(map[string]bool)
+	for _, event := range []*history.Event{change.After, change.Before} {
+		if event == nil {
+			continue
+		}
+		for _, source := range event.Sources {
+			if !seen[source] {
+				seen[source] = true
+				sources = append(sources, source)
+			}
+		}
+	}
+
+	matched := len(h.sources) == 0
+	for _, source := range sources {
+		matched = matched || h.sources[source]
+	}
+	if !matched {
+		return Payload{}, false
+	}
+
+	payload := Payload{Change: change, Sources: sources}
+	if len(sources) > 0 {
+		payload.Source = sources[0]
+	}
+	return payload, true
+}
+
+// deliver posts a payload, retrying network errors, 429 and 5xx responses
+// with exponential backoff
+func (d *Dispatcher) deliver(h hook, payload Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	delay := d.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := d.post(h, payload.Type, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= h.retries {
+			return err
+		}
+		log.Printf("Webhook %s: attempt %d failed, retrying in %s: %v", h.url, attempt+1, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post sends one request and reports whether a failure is worth retrying
+func (d *Dispatcher) post(h hook, changeType string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ical-merger")
+	req.Header.Set(HeaderEvent, changeType)
+	if h.secret != "" {
+		req.Header.Set(HeaderSignature, Sign(h.secret, body))
+	}
+
+	client := *d.client
+	client.Timeout = h.timeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+}
+
+// Sign returns the signature header value of a body: "sha256=" followed by
+// the hex HMAC-SHA256 of the body with the secret as key
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,69 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arthur/ical_merger/internal/config"
+	"github.com/arthur/ical_merger/internal/history"
+)
+
+// TestNotify tests the filters, the signature and the retry of failed deliveries
+func TestNotify(t *testing.T) {
+	var mu sync.Mutex
+	var received []Payload
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		if got, want := r.Header.Get(HeaderSignature), Sign("secret", body); got != want {
+			t.Errorf("Unexpected signature %q, want %q", got, want)
+		}
+		var payload Payload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("Invalid payload: %v", err)
+		}
+		if r.Header.Get(HeaderEvent) != payload.Type {
+			t.Errorf("Event header %q doesn't match the payload type %q", r.Header.Get(HeaderEvent), payload.Type)
+		}
+		received = append(received, payload)
+	}))
+	defer server.Close()
+
+	d := New([]config.Webhook{{URL: server.URL, Secret: "secret", Sources: []string{"Hannah"}}})
+	d.backoff = time.Millisecond
+
+	start := time.Date(2025, 1, 14, 19, 0, 0, 0, time.UTC)
+	d.Notify([]history.Change{
+		{Type: history.TimeChanged, UID: "parent-evening",
+			Before: &history.Event{Summary: "Parent evening", Start: start, Sources: []string{"Hannah"}},
+			After:  &history.Event{Summary: "Parent evening", Start: start.AddDate(0, 0, 1), Sources: []string{"Hannah"}}},
+		{Type: history.Added, UID: "standup", After: &history.Event{Summary: "Standup", Sources: []string{"Arthur"}}},
+		{Type: history.DetailsChanged, UID: "dinner", After: &history.Event{Summary: "Dinner", Sources: []string{"Hannah"}}},
+		{Type: history.Removed, UID: "kids", Output: "kids", Before: &history.Event{Summary: "Kids", Sources: []string{"Hannah"}}},
+	})
+	d.Wait()
+
+	if attempts != 2 {
+		t.Errorf("Expected one retry, got %d attempts", attempts)
+	}
+	if len(received) != 1 {
+		t.Fatalf("Expected one delivered change, got %d", len(received))
+	}
+	if payload := received[0]; payload.UID != "parent-evening" || payload.Source != "Hannah" ||
+		payload.Before == nil || payload.After == nil || !payload.After.Start.Equal(start.AddDate(0, 0, 1)) {
+		t.Errorf("Unexpected payload: %+v", payload)
+	}
+}